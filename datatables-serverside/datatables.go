@@ -70,6 +70,12 @@ type ColData struct {
 	Orderable bool
 	// columns[i][search][value] Search value to apply to this specific column.
 	Searchval string
+	// RawSearchval is the column search value exactly as it was sent by DataTables.
+	// It is what gets bound to the placeholders by the MySQLBuild* functions.
+	RawSearchval string
+	// Type of the column data, this is not sent by DataTables (see SetColumnTypes).
+	// For anything but ColText the search value is parsed by ParseColumnFilter.
+	Type ColType
 	// columns[i][search][regex]
 	// Flag to indicate if the search term for this column should be treated as regular expression (true) or not (false).
 	// As with global search, normally server-side processing scripts will not perform regular expression searching
@@ -95,7 +101,11 @@ type DataTablesInfo struct {
 	//  Note that this can be -1 to indicate that all records should be returned (although that negates any benefits of server-side processing!)
 	Length int
 	// Searchval holds the global search value. To be applied to all columns which have searchable as true.
+	// ParseDatatablesRequest escapes and quotes it so that it can be spliced in by MySQLFilter.
 	Searchval string
+	// RawSearchval holds the global search value exactly as it was sent by DataTables.
+	// It is what gets bound to the placeholders by the MySQLBuild* functions.
+	RawSearchval string
 	// UseRegex is true if the global filter should be treated as a regular expression for advanced searching.
 	//  Note that normally server-side processing scripts will not perform regular expression
 	//  searching for performance reasons on large data sets, but it is technically possible and at the discretion of your script.
@@ -104,6 +114,12 @@ type DataTablesInfo struct {
 	Order []OrderInfo
 	// Columns provides a mapping of what fields are to be searched
 	Columns []ColData
+	// Filter is the nested AND/OR filter sent by the SearchBuilder and SearchPanes extensions,
+	// nil when neither is used
+	Filter *FilterNode
+	// Cursor is the opaque keyset pagination cursor (see NextCursor). It is not part of the
+	// DataTables protocol, the page has to add it to the request as the cursor parameter.
+	Cursor string
 }
 
 // MySQLFilter generates the filter for a mySQL query based on the request and a map of the strings
@@ -334,7 +350,7 @@ func (di *DataTablesInfo) MySQLOrderby(SQLFieldMap map[string]string) (res strin
 //       nameparts[1]  '2]'
 //       nameparts[2]  'search]'
 //       nameparts[3]  'regex]'
-func parseParts(field string, nameparts []string) (index int, elem1 string, elem2 string, err error) {
+func parseParts(field string, nameparts []string, maxColumns int) (index int, elem1 string, elem2 string, err error) {
 	defaultErr := fmt.Errorf("Invalid order[] element %v", field)
 	numRegex, err := regexp.Compile("^[0-9]+]$")
 	if err != nil {
@@ -376,66 +392,19 @@ func parseParts(field string, nameparts []string) (index int, elem1 string, elem
 		elem2 = strings.TrimSuffix(nameparts[3], "]")
 	}
 	// Let's sanity check and make sure they aren't returning an index that is way out of range.
-	// We shall assume that no more than 200 columns are being returned
-	if index > 200 || index < 0 {
+	if index < 0 {
 		err = defaultErr
+	} else if index >= maxColumns {
+		err = &RequestError{Err: ErrTooManyColumns, Field: field, Detail: fmt.Sprintf("index over %v", maxColumns-1)}
 	}
 	return
 }
 
-// ParseDatatablesRequest checks the HTTP request to see if it corresponds
-// to a datatables AJAX data request and parses the request data into
-// the DataTablesInfo structure.
-//
-// This structure can be used by MySQLFilter and MySQLOrderby to generate a
-// MySQL query to run against a database.
-//
-// For example assuming you are going to fill in a response structure to DataTables
-// such as:
-//
-//   type QueryResponse struct {
-//       DateAdded   time.Time
-//       Status      string
-//       Email       struct {
-//           Name      string
-//           Email     string
-//       }
-//   }
-//   var emailQueueFields = map[string]string{
-//       "DateAdded":          "t1.dateadded",
-//       "Status":             "t1.status",
-//       "Email.Name":         "t2.Name",
-//       "Email.Email":        "t2.Email",
-//   }
-//
-//   const baseQuery = `
-//       SELECT t1.dateadded
-//             ,t1.status
-//             ,t2.Name
-//             ,t2.Email
-//       FROM infotable t1
-//       LEFT JOIN usertable t2
-//         ON t1.key = t2.key`
-//
-//       // See if we have a where clause to add to the base query
-//       query := baseQuery
-//       sqlPart, err := di.MySQLFilter(sqlFields)
-//       // If we did have a where filter, append it.  Note that it doesn't put the " WHERE "
-//       // in front because we might be doing a boolean operation.
-//       if sqlPart != "" {
-//           query += " WHERE " + sqlPart
-//       }
-//       sqlPart, err = di.MySQLOrderby(sqlFields)
-//       query += sqlPart
-//
-// At that point you have a query that you can send straight to mySQL
-//
-func ParseDatatablesRequest(r *http.Request) (res *DataTablesInfo, err error) {
+// parseForm parses the form encoded (GET or POST) DataTables request into res
+func parseForm(r *http.Request, res *DataTablesInfo, opts *ParseOptions) (foundDraw bool, err error) {
 	var index int
 	var elem string
 	var elem2 string
-	foundDraw := false
-	res = &DataTablesInfo{}
 	// Let the request parse the post values into the r.Form structure
 	err = r.ParseForm()
 	if err != nil {
@@ -456,18 +425,21 @@ func ParseDatatablesRequest(r *http.Request) (res *DataTablesInfo, err error) {
 			res.Start, err = strconv.Atoi(val0)
 		case "length":
 			res.Length, err = strconv.Atoi(val0)
+		case "cursor":
+			res.Cursor = val0
 		case "search":
 			if len(nameparts) != 2 {
 				err = fmt.Errorf("Invalid search[] element %v", field)
 			} else if nameparts[1] == "value]" {
 				res.Searchval = val0
+				res.RawSearchval = val0
 			} else if nameparts[1] == "regex]" {
 				res.UseRegex = (val0 == "true")
 			} else {
 				err = fmt.Errorf("Invalid search[] element %v", field)
 			}
 		case "order":
-			index, elem, _, err = parseParts(field, nameparts)
+			index, elem, _, err = parseParts(field, nameparts, opts.maxColumns())
 			if err == nil {
 				// Make sure there is a spot to store this one.  Note that we may see
 				// order[3][column] before we see order[0][dir]
@@ -485,15 +457,16 @@ func ParseDatatablesRequest(r *http.Request) (res *DataTablesInfo, err error) {
 				}
 			}
 		case "columns":
-			index, elem, elem2, err = parseParts(field, nameparts)
+			index, elem, elem2, err = parseParts(field, nameparts, opts.maxColumns())
 			// First make sure we have a valid column number to work against
-			if err == nil {
-				// Fill up the slice to get to the spot where it is going
-				// because the columns may come out of order.. I.e. we may see
-				// columns[4][search][value] before we see columns[0][data]
-				for len(res.Columns) <= index {
-					res.Columns = append(res.Columns, ColData{})
-				}
+			if err != nil {
+				return
+			}
+			// Fill up the slice to get to the spot where it is going
+			// because the columns may come out of order.. I.e. we may see
+			// columns[4][search][value] before we see columns[0][data]
+			for len(res.Columns) <= index {
+				res.Columns = append(res.Columns, ColData{})
 			}
 			// Now fill in the field in the column slice
 			switch elem {
@@ -509,6 +482,7 @@ func ParseDatatablesRequest(r *http.Request) (res *DataTablesInfo, err error) {
 				switch elem2 {
 				case "value":
 					res.Columns[index].Searchval = val0
+					res.Columns[index].RawSearchval = val0
 				case "regex":
 					res.Columns[index].UseRegex = (val0 != "false")
 				}
@@ -519,13 +493,97 @@ func ParseDatatablesRequest(r *http.Request) (res *DataTablesInfo, err error) {
 			return
 		}
 	}
+	// The SearchBuilder and SearchPanes extensions send nested structures
+	res.Filter, err = parseFormFilter(r.Form)
+	return
+}
+
+// ParseDatatablesRequest checks the HTTP request to see if it corresponds
+// to a datatables AJAX data request and parses the request data into
+// the DataTablesInfo structure. Both the default form encoded request and
+// a JSON body (Content-Type application/json) are accepted, including the
+// criteria of the SearchBuilder and the selections of the SearchPanes extensions.
+//
+// This structure can be used by MySQLFilter and MySQLOrderby to generate a
+// MySQL query to run against a database.
+//
+// For example assuming you are going to fill in a response structure to DataTables
+// such as:
+//
+//   type QueryResponse struct {
+//       DateAdded   time.Time
+//       Status      string
+//       Email       struct {
+//           Name      string
+//           Email     string
+//       }
+//   }
+//   var emailQueueFields = map[string]string{
+//       "DateAdded":          "t1.dateadded",
+//       "Status":             "t1.status",
+//       "Email.Name":         "t2.Name",
+//       "Email.Email":        "t2.Email",
+//   }
+//
+//   const baseQuery = `
+//       SELECT t1.dateadded
+//             ,t1.status
+//             ,t2.Name
+//             ,t2.Email
+//       FROM infotable t1
+//       LEFT JOIN usertable t2
+//         ON t1.key = t2.key`
+//
+//       // See if we have a where clause to add to the base query
+//       query := baseQuery
+//       sqlPart, err := di.MySQLFilter(sqlFields)
+//       // If we did have a where filter, append it.  Note that it doesn't put the " WHERE "
+//       // in front because we might be doing a boolean operation.
+//       if sqlPart != "" {
+//           query += " WHERE " + sqlPart
+//       }
+//       sqlPart, err = di.MySQLOrderby(sqlFields)
+//       query += sqlPart
+//
+// At that point you have a query that you can send straight to mySQL
+//
+// Prefer MySQLBuildQuery (or MySQLBuildFilter and MySQLBuildOrderby) which generate the same
+// kind of query with ? placeholders and return the arguments to bind, instead of relying on
+// the quoting of the search values.
+//
+// The request is checked against DefaultParseOptions, use ParseDatatablesRequestWithOptions
+// for stricter limits on public endpoints.
+//
+func ParseDatatablesRequest(r *http.Request) (res *DataTablesInfo, err error) {
+	return ParseDatatablesRequestWithOptions(r, DefaultParseOptions)
+}
+
+// ParseDatatablesRequestWithOptions is ParseDatatablesRequest with the limits of opts.
+// A request breaking them returns a *RequestError, which matches ErrInvalidRequest and
+// the sentinel error of the limit with errors.Is.
+func ParseDatatablesRequestWithOptions(r *http.Request, opts ParseOptions) (res *DataTablesInfo, err error) {
+	foundDraw := false
+	res = &DataTablesInfo{}
+	// DataTables can also be configured to send the request as a JSON body
+	if isJSONRequest(r) {
+		foundDraw, err = parseJSON(r, res, &opts)
+	} else {
+		foundDraw, err = parseForm(r, res, &opts)
+	}
+	if err != nil {
+		return
+	}
 	// If no Draw was specified in the request, then this isn't a datatables request and we can safely ignore it
 	if !foundDraw {
 		res = nil
-		err = errors.New("Not a DataTables request")
+		err = ErrNotDataTablesReq
 	} else {
+		if err = opts.validate(res); err != nil {
+			res = nil
+			return
+		}
 		// We have a valid datatables request.  See if we actually have any filtering
-		res.HasFilter = false
+		res.HasFilter = res.Filter != nil
 		// Check the global search value to see if it has anything on it
 		if res.Searchval != "" {
 			// We do have a filter so note that for later
@@ -0,0 +1,229 @@
+package datatablessrv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Binder adds value to the arguments of the query being generated and returns the
+// placeholder that has to be put in the SQL in its place
+type Binder func(value interface{}) string
+
+// Dialect renders the parts of a generated query that differ between databases.
+// The BuildFilter, BuildOrderby and BuildQuery functions take care of the structure of
+// the query and call into the Dialect for the actual SQL fragments.
+type Dialect interface {
+	// QuoteIdentifier validates that name is a plain (optionally table qualified)
+	// identifier and returns it quoted for the database
+	QuoteIdentifier(name string) (string, error)
+	// Placeholder returns the bind parameter marker for the n-th (1 based) argument
+	Placeholder(n int) string
+	// GlobalSearch returns the condition matching field against the global search value
+	GlobalSearch(field string, value string, regex bool, bind Binder) string
+	// ColumnSearch returns the condition matching field against its individual search value
+	ColumnSearch(field string, value string, regex bool, bind Binder) string
+	// Like returns the case insensitive LIKE condition of field against pattern, which uses \ as escape character
+	Like(field string, pattern string, bind Binder) string
+	// Limit returns the paging clause (including the leading space) for the given start and length
+	Limit(start int, length int, bind Binder) string
+}
+
+var (
+	// MySQL generates queries for MySQL/MariaDB. The global search uses MATCH ... AGAINST so a
+	// fulltext index is assumed on the searchable fields, regex uses REGEXP and paging LIMIT x, y
+	MySQL Dialect = mysqlDialect{}
+	// PostgreSQL generates queries for PostgreSQL with $n placeholders. The global search uses
+	// to_tsvector/plainto_tsquery, regex uses ~* and column searches ILIKE, paging LIMIT/OFFSET
+	PostgreSQL Dialect = postgresDialect{}
+	// SQLite generates queries for SQLite. Searches use LIKE, regex searches are translated to
+	// GLOB patterns (see SQLiteGlob) and paging uses LIMIT ? OFFSET ?
+	SQLite Dialect = sqliteDialect{}
+)
+
+// quoteIdentifier validates name with identRegex and quotes every part of it with quote
+func quoteIdentifier(name string, quote string) (quoted string, err error) {
+	if !identRegex.MatchString(name) {
+		err = fmt.Errorf("Invalid SQL identifier %q", name)
+		return
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + part + quote
+	}
+	quoted = strings.Join(parts, ".")
+	return
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) QuoteIdentifier(name string) (string, error) {
+	return QuoteMySQLIdentifier(name)
+}
+
+func (mysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) GlobalSearch(field string, value string, regex bool, bind Binder) string {
+	if regex {
+		return field + " REGEXP " + bind(value)
+	}
+	return "MATCH(" + field + ") AGAINST(" + bind(value) + ")"
+}
+
+func (d mysqlDialect) ColumnSearch(field string, value string, regex bool, bind Binder) string {
+	if regex {
+		return field + " REGEXP " + bind(value)
+	}
+	return d.Like(field, "%"+likeEscaper.Replace(value)+"%", bind)
+}
+
+func (mysqlDialect) Like(field string, pattern string, bind Binder) string {
+	return field + " LIKE " + bind(pattern)
+}
+
+func (mysqlDialect) Limit(start int, length int, bind Binder) string {
+	return " LIMIT " + bind(start) + ", " + bind(length)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) QuoteIdentifier(name string) (string, error) {
+	return quoteIdentifier(name, `"`)
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// fields are cast to text so that searches work on any column type, like they do in MySQL
+func (postgresDialect) GlobalSearch(field string, value string, regex bool, bind Binder) string {
+	if regex {
+		return field + "::text ~* " + bind(value)
+	}
+	return "to_tsvector(" + field + "::text) @@ plainto_tsquery(" + bind(value) + ")"
+}
+
+func (d postgresDialect) ColumnSearch(field string, value string, regex bool, bind Binder) string {
+	if regex {
+		return field + "::text ~* " + bind(value)
+	}
+	return d.Like(field, "%"+likeEscaper.Replace(value)+"%", bind)
+}
+
+func (postgresDialect) Like(field string, pattern string, bind Binder) string {
+	return field + "::text ILIKE " + bind(pattern)
+}
+
+func (postgresDialect) Limit(start int, length int, bind Binder) string {
+	return " LIMIT " + bind(length) + " OFFSET " + bind(start)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) QuoteIdentifier(name string) (string, error) {
+	return quoteIdentifier(name, `"`)
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+// SQLite has no fulltext search without a virtual table, so the global search is a LIKE as well
+func (d sqliteDialect) GlobalSearch(field string, value string, regex bool, bind Binder) string {
+	return d.ColumnSearch(field, value, regex, bind)
+}
+
+func (d sqliteDialect) ColumnSearch(field string, value string, regex bool, bind Binder) string {
+	if regex {
+		if glob, ok := SQLiteGlob(value); ok {
+			return field + " GLOB " + bind(glob)
+		}
+		// Leave anything GLOB can't express to a user supplied regexp() function
+		return field + " REGEXP " + bind(value)
+	}
+	return d.Like(field, "%"+likeEscaper.Replace(value)+"%", bind)
+}
+
+func (sqliteDialect) Like(field string, pattern string, bind Binder) string {
+	return field + ` LIKE ` + bind(pattern) + ` ESCAPE '\'`
+}
+
+func (sqliteDialect) Limit(start int, length int, bind Binder) string {
+	return " LIMIT " + bind(length) + " OFFSET " + bind(start)
+}
+
+// SQLiteGlob translates the simple regular expressions that DataTables users type into an
+// equivalent (case sensitive) GLOB pattern. Supported are the ^ and $ anchors, . and .*,
+// character classes and backslash escaped characters. ok is false when the expression
+// uses anything else (alternation, groups, other quantifiers) which GLOB can't express.
+func SQLiteGlob(regex string) (glob string, ok bool) {
+	var b strings.Builder
+	r := []rune(regex)
+	if len(r) > 0 && r[0] == '^' {
+		r = r[1:]
+	} else {
+		b.WriteRune('*')
+	}
+	anchoredEnd := false
+	if len(r) > 0 && r[len(r)-1] == '$' && (len(r) < 2 || r[len(r)-2] != '\\') {
+		r = r[:len(r)-1]
+		anchoredEnd = true
+	}
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; c {
+		case '.':
+			if i+1 < len(r) && r[i+1] == '*' {
+				b.WriteRune('*')
+				i++
+			} else {
+				b.WriteRune('?')
+			}
+		case '[':
+			// copy the character class up to the closing ], GLOB uses the same syntax
+			end := i + 1
+			if end < len(r) && r[end] == '^' {
+				end++
+			}
+			if end < len(r) && r[end] == ']' {
+				end++
+			}
+			for end < len(r) && r[end] != ']' {
+				if r[end] == '\\' {
+					return "", false
+				}
+				end++
+			}
+			if end >= len(r) {
+				return "", false
+			}
+			b.WriteString(string(r[i : end+1]))
+			i = end
+		case '\\':
+			if i+1 >= len(r) {
+				return "", false
+			}
+			i++
+			writeGlobLiteral(&b, r[i])
+		case '*', '+', '?', '|', '(', ')', '{', '}', '^', '$':
+			return "", false
+		default:
+			writeGlobLiteral(&b, c)
+		}
+	}
+	if !anchoredEnd {
+		b.WriteRune('*')
+	}
+	return b.String(), true
+}
+
+// writeGlobLiteral writes c so that GLOB matches it literally
+func writeGlobLiteral(b *strings.Builder, c rune) {
+	switch c {
+	case '*', '?', '[', ']':
+		b.WriteString("[" + string(c) + "]")
+	default:
+		b.WriteRune(c)
+	}
+}
@@ -0,0 +1,63 @@
+package datatablessrv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostgreSQLBuildQuery(t *testing.T) {
+	testData := DataTablesInfo{
+		Draw:         1,
+		HasFilter:    true,
+		Start:        20,
+		Length:       10,
+		RawSearchval: "george",
+		Columns: []ColData{
+			{Data: "first", Orderable: true, Searchable: true, RawSearchval: "50%"},
+			{Data: "last", Orderable: true, Searchable: true, RawSearchval: "^bo.*", UseRegex: true},
+			{Data: "age", Orderable: true, Searchable: false},
+		},
+		Order: []OrderInfo{
+			{ColNum: 2, Direction: Desc},
+		},
+	}
+
+	query, args, err := testData.BuildQuery(PostgreSQL, "person", testFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	expectedQuery := `SELECT "first_name","last_name","p"."age" FROM "person"` +
+		` WHERE (to_tsvector("first_name"::text) @@ plainto_tsquery($1) OR to_tsvector("last_name"::text) @@ plainto_tsquery($2))` +
+		` AND "first_name"::text ILIKE $3 AND "last_name"::text ~* $4` +
+		` ORDER BY "p"."age" DESC LIMIT $5 OFFSET $6`
+	if query != expectedQuery {
+		t.Errorf("unexpected query:\n got: %s\nwant: %s", query, expectedQuery)
+	}
+	expectedArgs := []interface{}{"george", "george", `%50\%%`, "^bo.*", 10, 20}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected args: got %#v want %#v", args, expectedArgs)
+	}
+}
+
+func TestPostgreSQLBuildFilterGlobalRegex(t *testing.T) {
+	testData := DataTablesInfo{
+		RawSearchval: "^ge",
+		UseRegex:     true,
+		Columns: []ColData{
+			{Data: "first", Searchable: true},
+			{Data: "hidden", Searchable: false},
+		},
+	}
+
+	filter, args, err := testData.BuildFilter(PostgreSQL, testFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if expected := `("first_name"::text ~* $1)`; filter != expected {
+		t.Errorf("unexpected filter: got %s want %s", filter, expected)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"^ge"}) {
+		t.Errorf("unexpected args: %#v", args)
+	}
+}
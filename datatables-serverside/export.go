@@ -0,0 +1,385 @@
+package datatablessrv
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is the file format of an export
+type ExportFormat string
+
+const (
+	// ExportCSV is comma separated values with a header row
+	ExportCSV ExportFormat = "csv"
+	// ExportNDJSON is one JSON object per line, keyed by columns[i][data]
+	ExportNDJSON ExportFormat = "ndjson"
+	// ExportXLSX is an Excel workbook with a single sheet and a header row
+	ExportXLSX ExportFormat = "xlsx"
+)
+
+// DefaultExportBatch is the number of records fetched at a time when exporting
+const DefaultExportBatch = 1000
+
+// ContentType returns the MIME type of the format
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// exportWriter writes the rows of an export in one format
+type exportWriter interface {
+	header(cols []ColData) error
+	row(values []interface{}) error
+	close() error
+}
+
+// Export writes all the records of src matching the filter of the request, in the requested order,
+// to w in the given format. Start and Length are ignored: the records are fetched batchSize at a time
+// (DefaultExportBatch when batchSize <= 0) and written out as they come, so the export does not have to
+// fit in memory. When src is a CursorSource handing out cursors the batches are fetched with keyset pagination.
+// The header row holds the columns[i][name] of the request, or columns[i][data] when there is no name.
+func (di *DataTablesInfo) Export(w io.Writer, format ExportFormat, src DataSource, batchSize int) (err error) {
+	if len(di.Columns) == 0 {
+		err = fmt.Errorf("Datatables request has no columns")
+		return
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultExportBatch
+	}
+	var ew exportWriter
+	switch format {
+	case ExportCSV:
+		ew = &csvExport{w: csv.NewWriter(w)}
+	case ExportNDJSON:
+		ew = &ndjsonExport{enc: json.NewEncoder(w), cols: di.Columns}
+	case ExportXLSX:
+		ew = &xlsxExport{zw: zip.NewWriter(w)}
+	default:
+		err = fmt.Errorf("Unsupported export format %v", format)
+		return
+	}
+	var accessors map[string]Accessor
+	if sliceSrc, isSliceSrc := src.(*SliceSource); isSliceSrc {
+		accessors = sliceSrc.Accessors
+	}
+	cursorSrc, isCursorSrc := src.(CursorSource)
+
+	if err = ew.header(di.Columns); err != nil {
+		return
+	}
+	// work on a copy so that the paging of the request is left alone
+	batch := *di
+	batch.Start = 0
+	batch.Length = batchSize
+	batch.Cursor = ""
+	for {
+		var data interface{}
+		if data, err = src.Page(&batch); err != nil {
+			return
+		}
+		records := reflect.ValueOf(data)
+		if data == nil {
+			records = reflect.ValueOf([]interface{}{})
+		} else if records.Kind() != reflect.Slice {
+			err = fmt.Errorf("Datatables records must be a slice, got %T", data)
+			return
+		}
+		values := make([]interface{}, len(di.Columns))
+		for i := 0; i < records.Len(); i++ {
+			record := records.Index(i).Interface()
+			for j, colData := range di.Columns {
+				if values[j], err = exportValue(record, colData.Data, accessors); err != nil {
+					return
+				}
+			}
+			if err = ew.row(values); err != nil {
+				return
+			}
+		}
+		if records.Len() < batchSize {
+			break
+		}
+		batch.Cursor = ""
+		if isCursorSrc {
+			if batch.Cursor, err = cursorSrc.NextCursor(&batch, data); err != nil {
+				return
+			}
+		}
+		// without a cursor for a full page (SQLSource without a Tiebreaker) go on with the offset
+		if batch.Cursor == "" {
+			batch.Start += batchSize
+		}
+	}
+	return ew.close()
+}
+
+// exportValue returns the value of a column of record. The records of SQLSource are keyed by
+// the whole columns[i][data], even when it is a dotted path.
+func exportValue(record interface{}, data string, accessors map[string]Accessor) (value interface{}, err error) {
+	if m, isMap := record.(map[string]interface{}); isMap && accessors[data] == nil {
+		if value, isFound := m[data]; isFound {
+			return value, nil
+		}
+	}
+	return columnValue(record, data, accessors)
+}
+
+// exportString formats a value for the text formats
+func exportString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// exportHeader returns the header of a column
+func exportHeader(colData ColData) string {
+	if colData.Name != "" {
+		return colData.Name
+	}
+	return colData.Data
+}
+
+type csvExport struct {
+	w *csv.Writer
+}
+
+func (e *csvExport) header(cols []ColData) error {
+	record := make([]string, len(cols))
+	for i, colData := range cols {
+		record[i] = exportHeader(colData)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) row(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = exportString(value)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExport struct {
+	enc  *json.Encoder
+	cols []ColData
+}
+
+func (e *ndjsonExport) header(cols []ColData) error {
+	return nil
+}
+
+func (e *ndjsonExport) row(values []interface{}) error {
+	record := make(map[string]interface{}, len(values))
+	for i, value := range values {
+		if b, isBytes := value.([]byte); isBytes {
+			value = string(b)
+		}
+		record[e.cols[i].Data] = value
+	}
+	return e.enc.Encode(record)
+}
+
+func (e *ndjsonExport) close() error {
+	return nil
+}
+
+// xlsxExport writes the smallest workbook Excel and LibreOffice open: the sheet is streamed
+// into the zip with inline strings, so that nothing has to be kept until the end.
+type xlsxExport struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func (e *xlsxExport) header(cols []ColData) (err error) {
+	for _, part := range xlsxParts {
+		var pw io.Writer
+		if pw, err = e.zw.Create(part.name); err != nil {
+			return
+		}
+		if _, err = io.WriteString(pw, part.content); err != nil {
+			return
+		}
+	}
+	if e.sheet, err = e.zw.Create("xl/worksheets/sheet1.xml"); err != nil {
+		return
+	}
+	_, err = io.WriteString(e.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return
+	}
+	values := make([]interface{}, len(cols))
+	for i, colData := range cols {
+		values[i] = exportHeader(colData)
+	}
+	return e.row(values)
+}
+
+func (e *xlsxExport) row(values []interface{}) (err error) {
+	e.rows++
+	buf := []byte(`<row r="` + strconv.Itoa(e.rows) + `">`)
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			buf = append(buf, "<c/>"...)
+			continue
+		case bool:
+			buf = append(buf, `<c t="b"><v>`...)
+			if v {
+				buf = append(buf, '1')
+			} else {
+				buf = append(buf, '0')
+			}
+			buf = append(buf, "</v></c>"...)
+			continue
+		}
+		if f, isNumber := toFloat(value); isNumber && reflect.ValueOf(value).Kind() != reflect.String {
+			buf = append(buf, "<c><v>"...)
+			buf = strconv.AppendFloat(buf, f, 'g', -1, 64)
+			buf = append(buf, "</v></c>"...)
+			continue
+		}
+		buf = append(buf, `<c t="inlineStr"><is><t xml:space="preserve">`...)
+		buf = appendXMLText(buf, exportString(value))
+		buf = append(buf, "</t></is></c>"...)
+	}
+	buf = append(buf, "</row>"...)
+	_, err = e.sheet.Write(buf)
+	return
+}
+
+func (e *xlsxExport) close() (err error) {
+	if _, err = io.WriteString(e.sheet, "</sheetData></worksheet>"); err != nil {
+		return
+	}
+	return e.zw.Close()
+}
+
+// appendXMLText appends s escaped as XML character data (characters XML can't hold become U+FFFD)
+func appendXMLText(buf []byte, s string) []byte {
+	w := &byteAppender{buf: buf}
+	xml.EscapeText(w, []byte(s))
+	return w.buf
+}
+
+type byteAppender struct {
+	buf []byte
+}
+
+func (a *byteAppender) Write(p []byte) (int, error) {
+	a.buf = append(a.buf, p...)
+	return len(p), nil
+}
+
+// ExportHandler returns an http.Handler that serves the export of src for a DataTables request,
+// as a download in the format of the format parameter (csv when missing), see DataTablesInfo.Export.
+// filename is the name of the download, without the extension.
+func ExportHandler(src DataSource, filename string, batchSize int) http.Handler {
+	return ExportHandlerWithOptions(src, filename, batchSize, DefaultParseOptions)
+}
+
+// ExportHandlerWithOptions is ExportHandler with the request limits of opts, see ParseDatatablesRequestWithOptions.
+// The paging limits don't apply as the export ignores the paging of the request.
+func ExportHandlerWithOptions(src DataSource, filename string, batchSize int, opts ParseOptions) http.Handler {
+	// the export pages through everything whatever the length of the request
+	opts.AllowAll = true
+	opts.MaxLength = 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		di, err := ParseDatatablesRequestWithOptions(r, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := ExportFormat(r.FormValue("format"))
+		switch format {
+		case "":
+			format = ExportCSV
+		case ExportCSV, ExportNDJSON, ExportXLSX:
+		default:
+			http.Error(w, fmt.Sprintf("Unsupported export format %v", format), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+"."+string(format)+`"`)
+		cw := &countingWriter{w: w}
+		if err = di.Export(cw, format, src, batchSize); err == nil {
+			return
+		}
+		if cw.n == 0 {
+			// nothing was sent yet, the error can still replace the download
+			w.Header().Del("Content-Disposition")
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrInvalidRequest) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		// once the download started there is no way to report an error but to cut it short
+		log.Printf("Datatables export %v failed after %v bytes: %v", filename, cw.n, err)
+	})
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n += int64(n)
+	return
+}
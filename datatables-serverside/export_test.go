@@ -0,0 +1,235 @@
+package datatablessrv
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportCSV(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+	expected := "id,first,last,age,city\n" +
+		"2,Anna,Jones,28,Ottawa\n" +
+		"4,Brian,Kane,51,Montreal\n" +
+		"6,Johan,Meyer,62,Toronto\n" +
+		"5,Stefan,Olsen,19,Vancouver\n" +
+		"3,Joanne,Tran,45,Toronto\n"
+	for _, src := range []DataSource{
+		&SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap},
+		&SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap, Tiebreaker: "id"},
+	} {
+		di, err := ParseDatatablesRequest(recordedRequest(t, "request_global_search.txt"))
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		// the page size of the request is ignored, the 5 matches come in batches of 2
+		di.Start, di.Length = 2, 1
+		var buf bytes.Buffer
+		if err = di.Export(&buf, ExportCSV, src, 2); err != nil {
+			t.Fatal("Error:", err)
+		}
+		if buf.String() != expected {
+			t.Errorf("unexpected export:\n%s", buf.String())
+		}
+		if di.Start != 2 || di.Length != 1 {
+			t.Errorf("the paging of the request changed %+v", di)
+		}
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	di := &DataTablesInfo{
+		Columns: []ColData{{Data: "id", Orderable: true}, {Data: "added"}, {Data: "Contact.Email"}},
+		Order:   []OrderInfo{{ColNum: 0, Direction: Desc}},
+	}
+	var buf bytes.Buffer
+	if err := di.Export(&buf, ExportNDJSON, &SliceSource{Records: testRecords}, 3); err != nil {
+		t.Fatal("Error:", err)
+	}
+	expected := `{"Contact.Email":"brian@example.com","added":"2018-04-01T00:00:00Z","id":10}
+{"Contact.Email":null,"added":"2018-02-01T00:00:00Z","id":3}
+{"Contact.Email":"anna@example.org","added":"2018-01-01T00:00:00Z","id":2}
+{"Contact.Email":"john@example.com","added":"2018-03-01T00:00:00Z","id":1}
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected export:\n%s", buf.String())
+	}
+}
+
+func TestExportXLSX(t *testing.T) {
+	di := &DataTablesInfo{
+		Columns: []ColData{{Data: "id", Name: "ID", Orderable: true}, {Data: "status", Name: "Status <&>"}, {Data: "Contact.Name"}},
+		Order:   []OrderInfo{{ColNum: 0, Direction: Asc}},
+	}
+	var buf bytes.Buffer
+	if err := di.Export(&buf, ExportXLSX, &SliceSource{Records: testRecords}, 0); err != nil {
+		t.Fatal("Error:", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		// every part has to be well formed
+		if err = xml.Unmarshal(b, new(interface{})); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			if err = xml.Unmarshal(b, &sheet); err != nil {
+				t.Fatal("Error:", err)
+			}
+		}
+	}
+	if len(zr.File) != 5 || len(sheet.Rows) != 5 {
+		t.Fatalf("expected 5 parts and 5 rows got %d and %d", len(zr.File), len(sheet.Rows))
+	}
+	header := sheet.Rows[0].Cells
+	if header[1].Inline != "Status <&>" || header[2].Inline != "Contact.Name" {
+		t.Errorf("unexpected header %+v", header)
+	}
+	row := sheet.Rows[3].Cells
+	if row[0].Type != "" || row[0].Value != "3" || row[1].Inline != "failed" || row[2].Inline != "" {
+		t.Errorf("unexpected row %+v", row)
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+	handler := ExportHandler(&SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap}, "people", 0)
+
+	r := recordedRequest(t, "request_page.txt")
+	r.URL.RawQuery += "&format=ndjson"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="people.ndjson"` {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	// all 6 people, not just the page of 3
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 6 {
+		t.Errorf("expected 6 records got %d:\n%s", lines, w.Body.String())
+	}
+
+	r = recordedRequest(t, "request_page.txt")
+	r.URL.RawQuery += "&format=pdf"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 got %d", w.Code)
+	}
+}
+
+func TestExportHandlerWithOptions(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+	src := &SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap}
+
+	tests := []struct {
+		name     string
+		opts     ParseOptions
+		request  string
+		length   string
+		expected int
+	}{
+		{name: "too many columns", opts: ParseOptions{MaxColumns: 3}, request: "request_page.txt", expected: http.StatusBadRequest},
+		{name: "regex not allowed", opts: ParseOptions{RegexColumns: map[string]bool{}}, request: "request_column_regex.txt", expected: http.StatusBadRequest},
+		{name: "regex allowed", opts: ParseOptions{RegexColumns: map[string]bool{"first": true}}, request: "request_column_regex.txt", expected: http.StatusOK},
+		{name: "all records", opts: ParseOptions{MaxLength: 2}, request: "request_page.txt", length: "-1", expected: http.StatusOK},
+		{name: "length over the page limit", opts: ParseOptions{MaxLength: 2}, request: "request_page.txt", length: "500", expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := recordedRequest(t, tt.request)
+		if tt.length != "" {
+			query := r.URL.Query()
+			query.Set("length", tt.length)
+			r.URL.RawQuery = query.Encode()
+		}
+		w := httptest.NewRecorder()
+		ExportHandlerWithOptions(src, "people", 0, tt.opts).ServeHTTP(w, r)
+		if w.Code != tt.expected {
+			t.Errorf("%s: expected status %d got %d %v", tt.name, tt.expected, w.Code, w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	ExportHandlerWithOptions(src, "people", 0, ParseOptions{}).ServeHTTP(w, recordedRequest(t, "request_page.txt"))
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 7 {
+		t.Errorf("unexpected response %d:\n%s", w.Code, w.Body.String())
+	}
+}
+
+// failingSource returns records pages times, then err
+type failingSource struct {
+	records []map[string]interface{}
+	pages   int
+	err     error
+}
+
+func (s *failingSource) Count(di *DataTablesInfo) (total int, filtered int, err error) {
+	return 0, 0, s.err
+}
+
+func (s *failingSource) Page(di *DataTablesInfo) (data interface{}, err error) {
+	if s.pages == 0 {
+		return nil, s.err
+	}
+	s.pages--
+	return s.records, nil
+}
+
+func TestExportHandlerError(t *testing.T) {
+	r := recordedRequest(t, "request_page.txt")
+	r.URL.RawQuery += "&format=csv"
+
+	// before anything was written the error replaces the download
+	w := httptest.NewRecorder()
+	ExportHandler(&failingSource{err: errors.New("connection refused")}, "records", 0).ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Disposition") != "" ||
+		!strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("unexpected response %d %v:\n%s", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	src := &failingSource{err: &RequestError{Err: ErrInvalidPaging, Field: "cursor"}}
+	ExportHandler(src, "records", 0).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 got %d", w.Code)
+	}
+
+	// once the download started it is cut short
+	r = recordedRequest(t, "request_page.txt")
+	r.URL.RawQuery += "&format=ndjson"
+	w = httptest.NewRecorder()
+	people := []map[string]interface{}{
+		{"id": 1, "first": "John", "last": "Smith", "age": 30, "city": "Toronto"},
+		{"id": 2, "first": "Anna", "last": "Jones", "age": 25, "city": "Ottawa"},
+	}
+	src = &failingSource{records: people, pages: 1, err: errors.New("connection reset")}
+	ExportHandler(src, "records", 2).ServeHTTP(w, r)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 2 {
+		t.Errorf("unexpected response %d:\n%s", w.Code, w.Body.String())
+	}
+}
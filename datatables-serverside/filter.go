@@ -0,0 +1,264 @@
+package datatablessrv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColType is the type of the data of a column. DataTables doesn't send it, so it has to be
+// set by the server (see SetColumnTypes) and it decides how a column search value is interpreted.
+type ColType int
+
+const (
+	// ColText is the default, the search value is matched as text (LIKE/MATCH/REGEXP)
+	ColText ColType = iota
+	// ColInt is an integer column
+	ColInt
+	// ColFloat is a floating point column
+	ColFloat
+	// ColDate is a date or timestamp column, values are 2006-01-02 dates or RFC3339 timestamps
+	ColDate
+	// ColBool is a boolean column, values are true/false, yes/no, 1/0
+	ColBool
+	// ColEnum is a column holding one of a set of strings (a status for example), matched exactly
+	ColEnum
+)
+
+// Condition is a single typed comparison parsed from a column search value
+type Condition struct {
+	// Op is one of =, !=, <, <=, >, >=, IN or OR
+	Op string
+	// Values holds the value to compare to, the list of values for IN, or for OR the
+	// alternatives, each a []Condition that all have to match
+	Values []interface{}
+}
+
+// SetColumnTypes sets the Type of the columns of the request from a map keyed by columns[i][data].
+// Columns not in the map keep their type (ColText unless set before).
+func (di *DataTablesInfo) SetColumnTypes(types map[string]ColType) {
+	for i := range di.Columns {
+		if colType, isFound := types[di.Columns[i].Data]; isFound {
+			di.Columns[i].Type = colType
+		}
+	}
+}
+
+// ParseColumnFilter parses the search value of a column of the given (non text) type into the list of
+// conditions that all have to match. The supported syntaxes are
+//
+//	10          equal to (for a date without time, anywhere in that day)
+//	>=10 <5 !=3 comparisons with =, !=, <, <=, > and >=
+//	10..20      range including both ends, either end may be left out (10.. or ..20)
+//	a|b|c       any of the values (dates without time, anywhere in one of the days)
+func ParseColumnFilter(colType ColType, searchval string) (conds []Condition, err error) {
+	searchval = strings.TrimSpace(searchval)
+	switch {
+	case searchval == "":
+		err = fmt.Errorf("Empty column filter")
+	case strings.Contains(searchval, ".."):
+		parts := strings.SplitN(searchval, "..", 2)
+		if parts[0] == "" && parts[1] == "" {
+			err = fmt.Errorf("Invalid range %q", searchval)
+			return
+		}
+		var c []Condition
+		if parts[0] != "" {
+			if c, err = compareCondition(colType, ">=", parts[0]); err != nil {
+				return
+			}
+			conds = append(conds, c...)
+		}
+		if parts[1] != "" {
+			if c, err = compareCondition(colType, "<=", parts[1]); err != nil {
+				return
+			}
+			conds = append(conds, c...)
+		}
+	case strings.Contains(searchval, "|"):
+		var cond Condition
+		if cond, err = listCondition(colType, strings.Split(searchval, "|")); err != nil {
+			return
+		}
+		conds = []Condition{cond}
+	default:
+		op := "="
+		for _, prefix := range []string{">=", "<=", "!=", ">", "<", "="} {
+			if strings.HasPrefix(searchval, prefix) {
+				op = prefix
+				searchval = searchval[len(prefix):]
+				break
+			}
+		}
+		conds, err = compareCondition(colType, op, searchval)
+	}
+	return
+}
+
+// compareCondition parses value and returns the condition(s) for op. Dates without a time
+// are days, so they turn into comparisons against the start of that day or the next one.
+func compareCondition(colType ColType, op string, value string) (conds []Condition, err error) {
+	typed, err := parseTypedValue(colType, value)
+	if err != nil {
+		return
+	}
+	day, isDay := typed.(time.Time)
+	isDay = isDay && colType == ColDate && len(strings.TrimSpace(value)) == len("2006-01-02")
+	if !isDay {
+		conds = []Condition{{Op: op, Values: []interface{}{typed}}}
+		return
+	}
+	next := day.AddDate(0, 0, 1)
+	switch op {
+	case "=":
+		conds = []Condition{{Op: ">=", Values: []interface{}{day}}, {Op: "<", Values: []interface{}{next}}}
+	case "!=":
+		// not in that day, which is the OR of the two, so we only support it on timestamps
+		err = fmt.Errorf("Invalid date filter !=%v, use a timestamp", value)
+	case "<=":
+		conds = []Condition{{Op: "<", Values: []interface{}{next}}}
+	case ">":
+		conds = []Condition{{Op: ">=", Values: []interface{}{next}}}
+	default:
+		conds = []Condition{{Op: op, Values: []interface{}{day}}}
+	}
+	return
+}
+
+// listCondition returns the condition matching any of values. Dates without a time are days,
+// so on a date column this is the OR of what each value on its own matches rather than an IN.
+func listCondition(colType ColType, values []string) (cond Condition, err error) {
+	if colType == ColDate {
+		cond.Op = "OR"
+		for _, value := range values {
+			var c []Condition
+			if c, err = compareCondition(colType, "=", value); err != nil {
+				return
+			}
+			cond.Values = append(cond.Values, c)
+		}
+		return
+	}
+	cond.Op = "IN"
+	for _, value := range values {
+		var typed interface{}
+		if typed, err = parseTypedValue(colType, value); err != nil {
+			return
+		}
+		cond.Values = append(cond.Values, typed)
+	}
+	return
+}
+
+// parseTypedValue converts a single value of the search string to the Go type of the column
+func parseTypedValue(colType ColType, value string) (typed interface{}, err error) {
+	value = strings.TrimSpace(value)
+	switch colType {
+	case ColInt:
+		typed, err = strconv.ParseInt(value, 10, 64)
+	case ColFloat:
+		typed, err = strconv.ParseFloat(value, 64)
+	case ColDate:
+		var t time.Time
+		if t, err = time.Parse("2006-01-02", value); err != nil {
+			t, err = time.Parse(time.RFC3339, value)
+		}
+		typed = t
+	case ColBool:
+		switch strings.ToLower(value) {
+		case "true", "yes", "1":
+			typed = true
+		case "false", "no", "0":
+			typed = false
+		default:
+			err = fmt.Errorf("invalid boolean")
+		}
+	case ColEnum:
+		if value == "" {
+			err = fmt.Errorf("empty value")
+		}
+		typed = value
+	default:
+		err = fmt.Errorf("not a typed column")
+	}
+	if err != nil {
+		err = fmt.Errorf("Invalid column filter value %q: %v", value, err)
+	}
+	return
+}
+
+// typedSearch renders the conditions of a typed column search
+func typedSearch(field string, conds []Condition, bind Binder) string {
+	var parts []string
+	for _, cond := range conds {
+		switch cond.Op {
+		case "IN":
+			var placeholders []string
+			for _, value := range cond.Values {
+				placeholders = append(placeholders, bind(value))
+			}
+			parts = append(parts, field+" IN ("+strings.Join(placeholders, ", ")+")")
+		case "OR":
+			var alternatives []string
+			for _, value := range cond.Values {
+				alternative := value.([]Condition)
+				if len(alternative) > 1 {
+					alternatives = append(alternatives, "("+typedSearch(field, alternative, bind)+")")
+				} else {
+					alternatives = append(alternatives, typedSearch(field, alternative, bind))
+				}
+			}
+			parts = append(parts, "("+strings.Join(alternatives, " OR ")+")")
+		default:
+			parts = append(parts, field+" "+cond.Op+" "+bind(cond.Values[0]))
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// matchConditions is the in-memory version of typedSearch
+func matchConditions(value interface{}, conds []Condition) bool {
+	if value == nil {
+		return false
+	}
+	for _, cond := range conds {
+		matched := false
+		switch cond.Op {
+		case "IN":
+			for _, v := range cond.Values {
+				if compareValues(value, v) == 0 {
+					matched = true
+					break
+				}
+			}
+		case "OR":
+			for _, v := range cond.Values {
+				if matchConditions(value, v.([]Condition)) {
+					matched = true
+					break
+				}
+			}
+		default:
+			cmp := compareValues(value, cond.Values[0])
+			switch cond.Op {
+			case "=":
+				matched = cmp == 0
+			case "!=":
+				matched = cmp != 0
+			case "<":
+				matched = cmp < 0
+			case "<=":
+				matched = cmp <= 0
+			case ">":
+				matched = cmp > 0
+			case ">=":
+				matched = cmp >= 0
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
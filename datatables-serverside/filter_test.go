@@ -0,0 +1,143 @@
+package datatablessrv
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseColumnFilter(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		colType  ColType
+		value    string
+		expected []Condition
+	}{
+		{ColInt, "10..20", []Condition{{">=", []interface{}{int64(10)}}, {"<=", []interface{}{int64(20)}}}},
+		{ColInt, "-5..", []Condition{{">=", []interface{}{int64(-5)}}}},
+		{ColFloat, "..2.5", []Condition{{"<=", []interface{}{2.5}}}},
+		{ColInt, "!=3", []Condition{{"!=", []interface{}{int64(3)}}}},
+		{ColInt, " 7 ", []Condition{{"=", []interface{}{int64(7)}}}},
+		{ColEnum, "sent|queued", []Condition{{"IN", []interface{}{"sent", "queued"}}}},
+		{ColBool, "yes", []Condition{{"=", []interface{}{true}}}},
+		{ColDate, ">=2023-01-01", []Condition{{">=", []interface{}{day(1)}}}},
+		{ColDate, "2023-01-05", []Condition{{">=", []interface{}{day(5)}}, {"<", []interface{}{day(6)}}}},
+		{ColDate, "2023-01-01..2023-01-09", []Condition{{">=", []interface{}{day(1)}}, {"<", []interface{}{day(10)}}}},
+		{ColDate, ">2023-01-09", []Condition{{">=", []interface{}{day(10)}}}},
+		{ColDate, "<2023-01-02T10:00:00Z", []Condition{{"<", []interface{}{time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)}}}},
+		{ColDate, "2023-01-05|2023-01-09", []Condition{{"OR", []interface{}{
+			[]Condition{{">=", []interface{}{day(5)}}, {"<", []interface{}{day(6)}}},
+			[]Condition{{">=", []interface{}{day(9)}}, {"<", []interface{}{day(10)}}},
+		}}}},
+	}
+	for _, tt := range tests {
+		conds, err := ParseColumnFilter(tt.colType, tt.value)
+		if err != nil {
+			t.Errorf("%q: Error: %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(conds, tt.expected) {
+			t.Errorf("%q: expected %v got %v", tt.value, tt.expected, conds)
+		}
+	}
+
+	for _, invalid := range []struct {
+		colType ColType
+		value   string
+	}{
+		{ColInt, "abc"}, {ColInt, ".."}, {ColFloat, "1|x"}, {ColBool, "maybe"}, {ColDate, "01/02/2023"}, {ColDate, "!=2023-01-01"},
+	} {
+		if _, err := ParseColumnFilter(invalid.colType, invalid.value); err == nil {
+			t.Errorf("%q: expected an error", invalid.value)
+		}
+	}
+}
+
+func TestMySQLBuildFilterTyped(t *testing.T) {
+	di := DataTablesInfo{
+		Columns: []ColData{
+			{Data: "age", Searchable: true, RawSearchval: "30..40"},
+			{Data: "hidden", Searchable: true, RawSearchval: "a|b"},
+			{Data: "first", Searchable: true, RawSearchval: "jo"},
+		},
+	}
+	di.SetColumnTypes(map[string]ColType{"age": ColInt, "hidden": ColEnum})
+
+	filter, args, err := di.MySQLBuildFilter(testFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	expected := "`p`.`age` >= ? AND `p`.`age` <= ? AND `secret` IN (?, ?) AND `first_name` LIKE ?"
+	if filter != expected {
+		t.Errorf("unexpected filter:\n got: %s\nwant: %s", filter, expected)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(30), int64(40), "a", "b", "%jo%"}) {
+		t.Errorf("unexpected args %#v", args)
+	}
+
+	di.Columns[0].RawSearchval = "30 to 40"
+	if _, _, err = di.MySQLBuildFilter(testFieldMap); err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+}
+
+func TestSQLiteTypedFilters(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+
+	tests := []struct {
+		age         string
+		joined      string
+		expectedIDs []int
+	}{
+		{"30..50", "", []int{1, 3}},
+		{">=45", "2018-02-01..2018-03-01", []int{3, 4}},
+		{"", "2018-03-31", []int{5}},
+		{"19|62", ">2018-03-31", []int{6}},
+		{"", "2018-01-15|2018-03-31", []int{1, 5}},
+	}
+	for _, tt := range tests {
+		di := DataTablesInfo{
+			Length: -1,
+			Columns: []ColData{
+				{Data: "id", Orderable: true},
+				{Data: "age", Searchable: true, RawSearchval: tt.age},
+				{Data: "joined", Searchable: true, RawSearchval: tt.joined},
+			},
+			Order: []OrderInfo{{ColNum: 0}},
+		}
+		di.SetColumnTypes(map[string]ColType{"age": ColInt, "joined": ColDate})
+		query, args, err := di.BuildQuery(SQLite, "person", personFieldMap)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if ids := queryIDs(t, db, query, args); !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("age %q joined %q: expected %v got %v", tt.age, tt.joined, tt.expectedIDs, ids)
+		}
+	}
+}
+
+func TestApplyToSliceTyped(t *testing.T) {
+	di := DataTablesInfo{
+		Length: -1,
+		Columns: []ColData{
+			{Data: "id", Searchable: true, RawSearchval: "2..10", Type: ColInt},
+			{Data: "added", Searchable: true, RawSearchval: "<2018-03-01", Type: ColDate},
+		},
+	}
+	page, _, _, err := di.ApplyToSlice(testRecords, nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ids := recordIDs(page); !reflect.DeepEqual(ids, []int{2, 3}) {
+		t.Errorf("unexpected result %v", ids)
+	}
+
+	di.Columns[1].RawSearchval = "2018-01-01|2018-04-01"
+	if page, _, _, err = di.ApplyToSlice(testRecords, nil); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ids := recordIDs(page); !reflect.DeepEqual(ids, []int{2, 10}) {
+		t.Errorf("unexpected result %v", ids)
+	}
+}
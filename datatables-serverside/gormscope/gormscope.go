@@ -0,0 +1,107 @@
+// Package gormscope applies DataTables server side processing requests, as parsed by
+// datatablessrv, to GORM (gorm.io/gorm) queries
+package gormscope
+
+import (
+	"fmt"
+	"strings"
+
+	datatablessrv "github.com/gbolo/go-util/datatables-serverside"
+	"gorm.io/gorm"
+)
+
+// questionDialect generates ? placeholders whatever the database, GORM binds them itself
+type questionDialect struct {
+	datatablessrv.Dialect
+}
+
+func (questionDialect) Placeholder(n int) string {
+	return "?"
+}
+
+// Dialect returns the datatablessrv Dialect of the database db is connected to
+func Dialect(db *gorm.DB) (dialect datatablessrv.Dialect, err error) {
+	switch db.Dialector.Name() {
+	case "mysql":
+		dialect = datatablessrv.MySQL
+	case "postgres":
+		dialect = datatablessrv.PostgreSQL
+	case "sqlite":
+		dialect = datatablessrv.SQLite
+	default:
+		err = fmt.Errorf("Unsupported GORM dialect %v", db.Dialector.Name())
+		return
+	}
+	dialect = questionDialect{dialect}
+	return
+}
+
+// FilterScope returns a GORM scope adding the searches and the SearchBuilder/SearchPanes filter
+// of di to the WHERE clause, with bound parameters. SQLFieldMap maps the columns[i][data]
+// of the request to the database columns, as for DataTablesInfo.BuildFilter.
+// Errors are added to the *gorm.DB, as GORM does.
+func FilterScope(di *datatablessrv.DataTablesInfo, SQLFieldMap map[string]string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		dialect, err := Dialect(db)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		where, args, err := di.BuildFilter(dialect, SQLFieldMap)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		if where == "" {
+			return db
+		}
+		return db.Where(where, args...)
+	}
+}
+
+// Scope returns a GORM scope applying di like DataTablesInfo.BuildQuery does: the filter of
+// FilterScope, the ordering and the paging (Start and Length, all records when Length is -1).
+//
+//	var people []Person
+//	err := db.Model(&Person{}).Scopes(gormscope.Scope(di, fieldMap)).Find(&people).Error
+func Scope(di *datatablessrv.DataTablesInfo, SQLFieldMap map[string]string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if di.Start < 0 || di.Length < -1 {
+			db.AddError(fmt.Errorf("Datatables request has invalid paging start %v length %v", di.Start, di.Length))
+			return db
+		}
+		dialect, err := Dialect(db)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		db = FilterScope(di, SQLFieldMap)(db)
+		// without an ordering in the request leave the ordering to GORM
+		if len(di.Order) > 0 {
+			orderBy, err := di.BuildOrderby(dialect, SQLFieldMap)
+			if err != nil {
+				db.AddError(err)
+				return db
+			}
+			db = db.Order(strings.TrimPrefix(orderBy, " ORDER BY "))
+		}
+		switch {
+		case di.Length == 0:
+			// GORM leaves out a LIMIT 0
+			db = db.Where("1 = 0")
+		case di.Length > 0:
+			db = db.Offset(di.Start).Limit(di.Length)
+		}
+		return db
+	}
+}
+
+// Count returns the recordsTotal and recordsFiltered of the response to di, db has
+// to have the Model (or Table) set and may hold other conditions that apply to both.
+func Count(db *gorm.DB, di *datatablessrv.DataTablesInfo, SQLFieldMap map[string]string) (total int64, filtered int64, err error) {
+	if err = db.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Session(&gorm.Session{}).Scopes(FilterScope(di, SQLFieldMap)).Count(&filtered).Error
+	return
+}
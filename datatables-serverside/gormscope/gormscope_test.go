@@ -0,0 +1,137 @@
+package gormscope
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	datatablessrv "github.com/gbolo/go-util/datatables-serverside"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type person struct {
+	ID        int `gorm:"primaryKey"`
+	FirstName string
+	LastName  string
+	Age       int
+	City      string
+}
+
+var personFieldMap = map[string]string{
+	"id":    "id",
+	"first": "first_name",
+	"last":  "last_name",
+	"age":   "age",
+	"city":  "city",
+}
+
+func openPersonDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	sqlDB, _ := db.DB()
+	// every connection would get its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(&person{}); err != nil {
+		t.Fatal("Error:", err)
+	}
+	err = db.Create([]person{
+		{1, "John", "Smith", 34, "Toronto"},
+		{2, "Anna", "Jones", 28, "Ottawa"},
+		{3, "Joanne", "Tran", 45, "Toronto"},
+		{4, "Brian", "Kane", 51, "Montreal"},
+		{5, "Stefan", "Olsen", 19, "Vancouver"},
+		{6, "Johan", "Meyer", 62, "Toronto"},
+	}).Error
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	return db
+}
+
+func parseRequest(t *testing.T, form url.Values) *datatablessrv.DataTablesInfo {
+	r, _ := http.NewRequest("GET", "/data?"+form.Encode(), nil)
+	di, err := datatablessrv.ParseDatatablesRequest(r)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	return di
+}
+
+func TestScope(t *testing.T) {
+	db := openPersonDB(t)
+	form := url.Values{
+		"draw":                 {"1"},
+		"start":                {"1"},
+		"length":               {"2"},
+		"columns[0][data]":     {"id"},
+		"columns[1][data]":     {"first"},
+		"columns[2][data]":     {"age"},
+		"columns[3][data]":     {"city"},
+		"order[0][column]":     {"2"},
+		"order[0][dir]":        {"desc"},
+		"search[value]":        {"o"},
+		"searchPanes[city][0]": {"Toronto"},
+		"searchPanes[city][1]": {"Ottawa"},
+	}
+	for i := 0; i < 4; i++ {
+		form.Set(fmt.Sprintf("columns[%d][searchable]", i), "true")
+		form.Set(fmt.Sprintf("columns[%d][orderable]", i), "true")
+	}
+	di := parseRequest(t, form)
+
+	// Toronto or Ottawa with an o in a searchable column: 6, 3, 1, 2 by age, and the second page of 2
+	var people []person
+	if err := db.Model(&person{}).Scopes(Scope(di, personFieldMap)).Find(&people).Error; err != nil {
+		t.Fatal("Error:", err)
+	}
+	var ids []int
+	for _, p := range people {
+		ids = append(ids, p.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{3, 1}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+
+	total, filtered, err := Count(db.Model(&person{}), di, personFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if total != 6 || filtered != 4 {
+		t.Errorf("expected 6 and 4 got %d and %d", total, filtered)
+	}
+
+	// conditions of db apply to both counts
+	total, filtered, err = Count(db.Model(&person{}).Where("age > ?", 30), di, personFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if total != 4 || filtered != 3 {
+		t.Errorf("expected 4 and 3 got %d and %d", total, filtered)
+	}
+
+	sql := db.Session(&gorm.Session{DryRun: true}).Model(&person{}).Scopes(Scope(di, personFieldMap)).Find(&people).Statement.SQL.String()
+	expected := `SELECT * FROM ` + "`people`" + ` WHERE ("id" LIKE ? ESCAPE '\' OR "first_name" LIKE ? ESCAPE '\' OR "age" LIKE ? ESCAPE '\'` +
+		` OR "city" LIKE ? ESCAPE '\') AND "city" IN (?, ?) ORDER BY "age" DESC LIMIT 2 OFFSET 1`
+	if sql != expected {
+		t.Errorf("unexpected query:\n got: %s\nwant: %s", sql, expected)
+	}
+}
+
+func TestScopeErrors(t *testing.T) {
+	db := openPersonDB(t)
+	for _, form := range []url.Values{
+		{"draw": {"1"}, "columns[0][data]": {"nope"}, "columns[0][orderable]": {"true"}, "order[0][column]": {"0"}},
+		{"draw": {"1"}, "columns[0][data]": {"id"}, "columns[0][search][value]": {"x"}, "columns[1][data]": {"nope"}, "columns[1][searchable]": {"true"}, "columns[1][search][value]": {"x"}},
+	} {
+		var people []person
+		if err := db.Model(&person{}).Scopes(Scope(parseRequest(t, form), personFieldMap)).Find(&people).Error; err == nil {
+			t.Errorf("expected an error for %v", form)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package datatablessrv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keysetColumn is one of the columns the keyset is made of
+type keysetColumn struct {
+	Data      string
+	Direction SortDir
+}
+
+// keysetCursor is what is encoded in the opaque cursor. Order is the ordering the values
+// belong to, so that a cursor can't be used after the user changed the ordering.
+type keysetCursor struct {
+	Order  string        `json:"o"`
+	Values []cursorValue `json:"v"`
+}
+
+// cursorValue keeps the type of a value through JSON: T is int, uint or time for those
+// (ints as strings so that large ids don't lose precision) and empty for anything else
+type cursorValue struct {
+	T string      `json:"t,omitempty"`
+	V interface{} `json:"v"`
+}
+
+// keyset returns the ordering columns of the request followed by the tiebreaker
+// (unless it is ordered on already), which has the direction of the last ordering column
+func (di *DataTablesInfo) keyset(tiebreaker string) (keys []keysetColumn, err error) {
+	if tiebreaker == "" {
+		err = fmt.Errorf("Keyset pagination needs a tiebreaker column")
+		return
+	}
+	direction := Asc
+	hasTiebreaker := false
+	for _, orderItem := range di.Order {
+		if orderItem.ColNum < 0 || orderItem.ColNum >= len(di.Columns) {
+			err = fmt.Errorf("Datatables Request order column %v out of range %v of columns", orderItem.ColNum, len(di.Columns))
+			return
+		}
+		colData := di.Columns[orderItem.ColNum]
+		if !colData.Orderable {
+			err = fmt.Errorf("Datatables requested ordering on non-orderable column %v", colData.Data)
+			return
+		}
+		keys = append(keys, keysetColumn{Data: colData.Data, Direction: orderItem.Direction})
+		direction = orderItem.Direction
+		hasTiebreaker = hasTiebreaker || colData.Data == tiebreaker
+	}
+	if !hasTiebreaker {
+		keys = append(keys, keysetColumn{Data: tiebreaker, Direction: direction})
+	}
+	return
+}
+
+// keysetSignature identifies the ordering of a keyset
+func keysetSignature(keys []keysetColumn) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = strconv.Quote(key.Data)
+		if key.Direction == Desc {
+			parts[i] += " desc"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// BuildKeysetQuery generates the query for a page like BuildQuery does, but instead of skipping
+// Start records with an OFFSET (which gets slower the further you page on large tables) it seeks
+// past the last record of the previous page, as encoded in di.Cursor by NextCursor.
+// The rows are ordered by the ordering columns of the request plus the tiebreaker, the columns[i][data]
+// of a unique column (the primary key), which is added to the selected columns when it is not one of them.
+// When all the ordering directions are the same the seek predicate is a row value comparison
+//
+//	WHERE (`a`,`b`,`id`) > (?,?,?)
+//
+// otherwise it is expanded to (`a` > ? OR (`a` = ? AND `b` < ?) OR ...).
+// Without a Cursor the first page is returned. The ordering columns should not contain NULLs.
+func (di *DataTablesInfo) BuildKeysetQuery(dialect Dialect, tableName string, SQLFieldMap map[string]string, tiebreaker string) (query string, args []interface{}, err error) {
+	table, err := dialect.QuoteIdentifier(tableName)
+	if err != nil {
+		return
+	}
+	if len(di.Columns) == 0 {
+		err = fmt.Errorf("Datatables request has no columns")
+		return
+	}
+	if di.Length < -1 {
+		err = fmt.Errorf("Datatables request has invalid paging length %v", di.Length)
+		return
+	}
+	keys, err := di.keyset(tiebreaker)
+	if err != nil {
+		return
+	}
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		if fields[i], err = fieldName(dialect, ColData{Data: key.Data}, SQLFieldMap); err != nil {
+			return
+		}
+	}
+
+	var selectCols []string
+	for _, colData := range di.Columns {
+		var sqlName string
+		sqlName, err = fieldName(dialect, colData, SQLFieldMap)
+		if err != nil {
+			return
+		}
+		selectCols = append(selectCols, sqlName)
+	}
+	if di.column(tiebreaker) == nil {
+		selectCols = append(selectCols, fields[len(fields)-1])
+	}
+
+	q := &queryArgs{dialect: dialect}
+	where, err := di.buildFilter(q, SQLFieldMap)
+	if err != nil {
+		return
+	}
+	if di.Cursor != "" {
+		var values []interface{}
+		if values, err = decodeCursor(di.Cursor, keys); err != nil {
+			return
+		}
+		seek := seekPredicate(keys, fields, values, q.bind)
+		if where != "" {
+			where = "(" + where + ") AND " + seek
+		} else {
+			where = seek
+		}
+	}
+
+	query = "SELECT " + strings.Join(selectCols, ",") + " FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	for i, key := range keys {
+		if i == 0 {
+			query += " ORDER BY "
+		} else {
+			query += ","
+		}
+		query += fields[i]
+		if key.Direction == Desc {
+			query += " DESC"
+		}
+	}
+	if di.Length >= 0 {
+		query += dialect.Limit(0, di.Length, q.bind)
+	}
+	args = q.args
+	return
+}
+
+// seekPredicate renders the condition selecting the rows after values in the keyset order
+func seekPredicate(keys []keysetColumn, fields []string, values []interface{}, bind Binder) string {
+	uniform := true
+	for _, key := range keys {
+		uniform = uniform && key.Direction == keys[0].Direction
+	}
+	op := func(direction SortDir) string {
+		if direction == Desc {
+			return " < "
+		}
+		return " > "
+	}
+	if uniform {
+		if len(keys) == 1 {
+			return fields[0] + op(keys[0].Direction) + bind(values[0])
+		}
+		placeholders := make([]string, len(values))
+		for i, value := range values {
+			placeholders[i] = bind(value)
+		}
+		return "(" + strings.Join(fields, ",") + ")" + op(keys[0].Direction) + "(" + strings.Join(placeholders, ",") + ")"
+	}
+	var alternatives []string
+	for i := range keys {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fields[j]+" = "+bind(values[j]))
+		}
+		parts = append(parts, fields[i]+op(keys[i].Direction)+bind(values[i]))
+		if len(parts) > 1 {
+			alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+		} else {
+			alternatives = append(alternatives, parts[0])
+		}
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// NextCursor returns the opaque cursor to send back to get the page following the one that
+// ended with record, which holds the column values keyed by columns[i][data] (including the
+// tiebreaker), as returned by SQLSource. DataTables has to pass it as the cursor parameter.
+func (di *DataTablesInfo) NextCursor(record map[string]interface{}, tiebreaker string) (cursor string, err error) {
+	keys, err := di.keyset(tiebreaker)
+	if err != nil {
+		return
+	}
+	c := keysetCursor{Order: keysetSignature(keys)}
+	for _, key := range keys {
+		value, isFound := record[key.Data]
+		if !isFound {
+			err = fmt.Errorf("Keyset column %v missing from the record", key.Data)
+			return
+		}
+		c.Values = append(c.Values, newCursorValue(value))
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	cursor = base64.RawURLEncoding.EncodeToString(b)
+	return
+}
+
+// decodeCursor checks that cursor belongs to the keyset and returns its values
+func decodeCursor(cursor string, keys []keysetColumn) (values []interface{}, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		err = fmt.Errorf("Invalid cursor: %v", err)
+		return
+	}
+	var c keysetCursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		err = fmt.Errorf("Invalid cursor: %v", err)
+		return
+	}
+	if c.Order != keysetSignature(keys) || len(c.Values) != len(keys) {
+		err = fmt.Errorf("Cursor does not match the ordering of the request")
+		return
+	}
+	for _, cv := range c.Values {
+		var value interface{}
+		if value, err = cv.value(); err != nil {
+			return
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+func newCursorValue(value interface{}) cursorValue {
+	switch v := value.(type) {
+	case time.Time:
+		return cursorValue{T: "time", V: v.Format(time.RFC3339Nano)}
+	case []byte:
+		return cursorValue{V: string(v)}
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cursorValue{T: "int", V: strconv.FormatInt(rv.Int(), 10)}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cursorValue{T: "uint", V: strconv.FormatUint(rv.Uint(), 10)}
+	}
+	return cursorValue{V: value}
+}
+
+func (cv cursorValue) value() (value interface{}, err error) {
+	s, isString := cv.V.(string)
+	switch cv.T {
+	case "":
+		switch cv.V.(type) {
+		case string, float64, bool:
+			return cv.V, nil
+		}
+	case "time":
+		if isString {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	case "int":
+		if isString {
+			return strconv.ParseInt(s, 10, 64)
+		}
+	case "uint":
+		if isString {
+			return strconv.ParseUint(s, 10, 64)
+		}
+	}
+	err = fmt.Errorf("Invalid cursor value %v", cv.V)
+	return
+}
@@ -0,0 +1,159 @@
+package datatablessrv
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// keysetRequest returns a request on the first, age and city columns of the person table
+func keysetRequest(length int, order ...OrderInfo) *DataTablesInfo {
+	return &DataTablesInfo{
+		Draw:   1,
+		Length: length,
+		Order:  order,
+		Columns: []ColData{
+			{Data: "first", Searchable: true, Orderable: true},
+			{Data: "age", Searchable: true, Orderable: true},
+			{Data: "city", Searchable: true, Orderable: true},
+		},
+	}
+}
+
+func TestBuildKeysetQuery(t *testing.T) {
+	last := map[string]interface{}{"first": "John", "age": 34, "city": "Toronto", "id": int64(1)}
+	filtered := keysetRequest(2)
+	filtered.Columns[0].RawSearchval = "jo"
+	tests := []struct {
+		di       *DataTablesInfo
+		expected string
+		args     []interface{}
+	}{
+		{
+			di:       keysetRequest(2, OrderInfo{ColNum: 1, Direction: Desc}),
+			expected: "SELECT `first_name`,`age`,`city`,`id` FROM `person` WHERE (`age`,`id`) < (?,?) ORDER BY `age` DESC,`id` DESC LIMIT ?, ?",
+			args:     []interface{}{int64(34), int64(1), 0, 2},
+		},
+		{
+			di: keysetRequest(2, OrderInfo{ColNum: 2, Direction: Asc}, OrderInfo{ColNum: 1, Direction: Desc}),
+			expected: "SELECT `first_name`,`age`,`city`,`id` FROM `person` WHERE (`city` > ? OR (`city` = ? AND `age` < ?)" +
+				" OR (`city` = ? AND `age` = ? AND `id` < ?)) ORDER BY `city`,`age` DESC,`id` DESC LIMIT ?, ?",
+			args: []interface{}{"Toronto", "Toronto", int64(34), "Toronto", int64(34), int64(1), 0, 2},
+		},
+		{
+			// with a search the seek predicate is ANDed to the filter
+			di:       filtered,
+			expected: "SELECT `first_name`,`age`,`city`,`id` FROM `person` WHERE (`first_name` LIKE ?) AND `id` > ? ORDER BY `id` LIMIT ?, ?",
+			args:     []interface{}{"%jo%", int64(1), 0, 2},
+		},
+	}
+	for _, test := range tests {
+		cursor, err := test.di.NextCursor(last, "id")
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		test.di.Cursor = cursor
+		query, args, err := test.di.BuildKeysetQuery(MySQL, "person", personFieldMap, "id")
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if query != test.expected {
+			t.Errorf("unexpected query:\n got: %s\nwant: %s", query, test.expected)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("unexpected args %#v", args)
+		}
+	}
+}
+
+func TestBuildKeysetQueryErrors(t *testing.T) {
+	di := keysetRequest(2, OrderInfo{ColNum: 1, Direction: Desc})
+	cursor, err := di.NextCursor(map[string]interface{}{"age": 34, "id": 1}, "id")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	for _, test := range []struct {
+		cursor     string
+		order      []OrderInfo
+		tiebreaker string
+	}{
+		{cursor: "not a cursor!", tiebreaker: "id"},
+		{cursor: "e30", tiebreaker: "id"},
+		// a cursor of another ordering
+		{cursor: cursor, order: []OrderInfo{{ColNum: 1, Direction: Asc}}, tiebreaker: "id"},
+		{cursor: cursor, tiebreaker: "last"},
+		{tiebreaker: ""},
+		{tiebreaker: "nope"},
+	} {
+		di := keysetRequest(2, OrderInfo{ColNum: 1, Direction: Desc})
+		if test.order != nil {
+			di.Order = test.order
+		}
+		di.Cursor = test.cursor
+		if _, _, err := di.BuildKeysetQuery(MySQL, "person", personFieldMap, test.tiebreaker); err == nil {
+			t.Errorf("expected an error for %+v", test)
+		}
+	}
+	if _, err := di.NextCursor(map[string]interface{}{"age": 34}, "id"); err == nil {
+		t.Error("expected an error for a record without the tiebreaker")
+	}
+}
+
+func TestSQLiteKeysetPaging(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+	src := &SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap}
+	keysetSrc := &SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap, Tiebreaker: "id"}
+
+	for _, order := range []string{
+		// joined is a date stored as text, id the tiebreaker
+		"order[0][column]=3&order[0][dir]=desc",
+		"order[0][column]=2&order[0][dir]=asc&order[1][column]=1&order[1][dir]=desc",
+		"order[0][column]=0&order[0][dir]=asc",
+	} {
+		base := "draw=1&length=2&columns[0][data]=id&columns[0][orderable]=true&columns[1][data]=first&columns[1][orderable]=true" +
+			"&columns[2][data]=city&columns[2][orderable]=true&columns[3][data]=joined&columns[3][orderable]=true" +
+			"&columns[4][data]=age&columns[4][orderable]=true&" + order
+
+		// all the rows with one offset query
+		allRequest, _ := url.ParseQuery(base)
+		allRequest.Set("length", "-1")
+		di, err := ParseDatatablesRequest(httptest.NewRequest("GET", "/data?"+allRequest.Encode(), nil))
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		all, err := src.Page(di)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		var paged []interface{}
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			w := httptest.NewRecorder()
+			Handler(keysetSrc).ServeHTTP(w, httptest.NewRequest("GET", "/data?"+base+"&cursor="+cursor, nil))
+			var res struct {
+				Data       []interface{} `json:"data"`
+				NextCursor string        `json:"nextCursor"`
+				Error      string        `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Error != "" {
+				t.Fatalf("unexpected response %s", w.Body.String())
+			}
+			paged = append(paged, res.Data...)
+			if res.NextCursor == "" {
+				break
+			}
+			cursor = res.NextCursor
+		}
+		// compare as JSON, the way DataTables gets the records
+		b, _ := json.Marshal(all)
+		var expected []interface{}
+		json.Unmarshal(b, &expected)
+		if !reflect.DeepEqual(paged, expected) {
+			t.Errorf("%s: keyset pages differ from the offset query:\n got: %v\nwant: %v", order, paged, expected)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package datatablessrv
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+)
+
+// DefaultMaxColumns is the number of columns (and orderings) accepted when ParseOptions.MaxColumns is not set
+const DefaultMaxColumns = 201
+
+// The sentinel errors of the request validation, test for them with errors.Is
+var (
+	// ErrInvalidRequest matches every validation failure of ParseDatatablesRequestWithOptions
+	ErrInvalidRequest = errors.New("Invalid DataTables request")
+	// ErrTooManyColumns is returned for more columns or orderings than ParseOptions.MaxColumns
+	ErrTooManyColumns = errors.New("Too many columns in DataTables request")
+	// ErrInvalidPaging is returned for a negative start or a length that is not allowed
+	ErrInvalidPaging = errors.New("Invalid paging in DataTables request")
+	// ErrRegexNotAllowed is returned for a regular expression search on a column that doesn't allow it
+	ErrRegexNotAllowed = errors.New("Regular expression search not allowed")
+	// ErrRegexTooComplex is returned for a regular expression that is invalid or over ParseOptions.MaxRegexComplexity
+	ErrRegexTooComplex = errors.New("Regular expression search too complex")
+)
+
+// RequestError is a validation failure of a DataTables request.
+// It wraps one of the sentinel errors and also matches ErrInvalidRequest.
+type RequestError struct {
+	// Err is the sentinel error, ErrTooManyColumns, ErrInvalidPaging, ErrRegexNotAllowed or ErrRegexTooComplex
+	Err error
+	// Field is the request parameter at fault
+	Field string
+	// Detail says what is wrong with it
+	Detail string
+}
+
+func (e *RequestError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error() + ": " + e.Field
+	}
+	return e.Err.Error() + ": " + e.Field + " " + e.Detail
+}
+
+// Unwrap returns the sentinel error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every RequestError match ErrInvalidRequest
+func (e *RequestError) Is(target error) bool {
+	return target == ErrInvalidRequest
+}
+
+// ParseOptions limits what ParseDatatablesRequestWithOptions accepts, so that an endpoint
+// exposed to the public can't be made to run arbitrarily expensive queries.
+// The zero value uses DefaultMaxColumns, doesn't limit the page length or the regular expressions
+// but refuses a length of -1 (everything).
+type ParseOptions struct {
+	// MaxColumns is the maximum number of columns, and of orderings (DefaultMaxColumns when 0)
+	MaxColumns int
+	// MaxLength is the maximum page length, 0 for no limit
+	MaxLength int
+	// AllowAll allows a length of -1, which returns all the records in a single page
+	AllowAll bool
+	// RegexColumns, when not nil, holds the columns[i][data] of the columns that allow regular
+	// expression searches, "" being the global search. Other regular expression searches are refused.
+	RegexColumns map[string]bool
+	// MaxRegexComplexity limits the size of regular expressions, counted as the number of nodes of
+	// the parsed expression with the repetitions expanded (a{10} counts 20), 0 for no limit.
+	// When it is set, regular expressions have to be valid RE2 (Go regexp) syntax.
+	MaxRegexComplexity int
+}
+
+// DefaultParseOptions are the options of ParseDatatablesRequest
+var DefaultParseOptions = ParseOptions{MaxColumns: DefaultMaxColumns, AllowAll: true}
+
+// maxColumns returns the effective column limit
+func (opts *ParseOptions) maxColumns() int {
+	if opts.MaxColumns <= 0 {
+		return DefaultMaxColumns
+	}
+	return opts.MaxColumns
+}
+
+// validate checks a parsed request against the options
+func (opts *ParseOptions) validate(di *DataTablesInfo) (err error) {
+	if len(di.Columns) > opts.maxColumns() {
+		return &RequestError{Err: ErrTooManyColumns, Field: "columns", Detail: fmt.Sprintf("%v over %v", len(di.Columns), opts.maxColumns())}
+	}
+	if len(di.Order) > opts.maxColumns() {
+		return &RequestError{Err: ErrTooManyColumns, Field: "order", Detail: fmt.Sprintf("%v over %v", len(di.Order), opts.maxColumns())}
+	}
+	if di.Start < 0 {
+		return &RequestError{Err: ErrInvalidPaging, Field: "start", Detail: fmt.Sprint(di.Start)}
+	}
+	switch {
+	case di.Length == -1 && !opts.AllowAll:
+		return &RequestError{Err: ErrInvalidPaging, Field: "length", Detail: "-1 (all records) not allowed"}
+	case di.Length < -1:
+		return &RequestError{Err: ErrInvalidPaging, Field: "length", Detail: fmt.Sprint(di.Length)}
+	case opts.MaxLength > 0 && di.Length > opts.MaxLength:
+		return &RequestError{Err: ErrInvalidPaging, Field: "length", Detail: fmt.Sprintf("%v over %v", di.Length, opts.MaxLength)}
+	}
+	if di.UseRegex && di.RawSearchval != "" {
+		if err = opts.checkRegex("search[value]", "", di.RawSearchval); err != nil {
+			return
+		}
+	}
+	for i, colData := range di.Columns {
+		if colData.UseRegex && colData.RawSearchval != "" {
+			if err = opts.checkRegex(fmt.Sprintf("columns[%d][search][value]", i), colData.Data, colData.RawSearchval); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// checkRegex checks a regular expression search on the column data
+func (opts *ParseOptions) checkRegex(field string, data string, expr string) (err error) {
+	if opts.RegexColumns != nil && !opts.RegexColumns[data] {
+		return &RequestError{Err: ErrRegexNotAllowed, Field: field}
+	}
+	if opts.MaxRegexComplexity <= 0 {
+		return
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return &RequestError{Err: ErrRegexTooComplex, Field: field, Detail: err.Error()}
+	}
+	if complexity := regexComplexity(re, opts.MaxRegexComplexity); complexity > opts.MaxRegexComplexity {
+		return &RequestError{Err: ErrRegexTooComplex, Field: field, Detail: fmt.Sprintf("complexity over %v", opts.MaxRegexComplexity)}
+	}
+	return
+}
+
+// regexComplexity counts the nodes of re with the repetitions expanded. It stops counting
+// once over limit, so that nested repetitions can't overflow.
+func regexComplexity(re *syntax.Regexp, limit int) int {
+	n := 1
+	for _, sub := range re.Sub {
+		n += regexComplexity(sub, limit)
+		if n > limit {
+			return n
+		}
+	}
+	if re.Op == syntax.OpRepeat {
+		times := re.Max
+		if times < 0 {
+			times = re.Min + 1
+		}
+		if times > 1 && n > limit/times {
+			return limit + 1
+		}
+		n *= times
+	}
+	return n
+}
@@ -0,0 +1,111 @@
+package datatablessrv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	strict := ParseOptions{
+		MaxColumns:         3,
+		MaxLength:          100,
+		RegexColumns:       map[string]bool{"first": true},
+		MaxRegexComplexity: 50,
+	}
+	columns := url.Values{
+		"draw":             {"1"},
+		"length":           {"10"},
+		"columns[0][data]": {"first"},
+		"columns[1][data]": {"last"},
+	}
+	with := func(extra url.Values) url.Values {
+		form := url.Values{}
+		for k, v := range columns {
+			form[k] = v
+		}
+		for k, v := range extra {
+			form[k] = v
+		}
+		return form
+	}
+	tests := []struct {
+		name     string
+		form     url.Values
+		opts     ParseOptions
+		expected error
+	}{
+		{"defaults", with(url.Values{"length": {"-1"}, "search[value]": {"(a+)+$"}, "search[regex]": {"true"}}), DefaultParseOptions, nil},
+		{"strict", with(url.Values{"columns[0][search][value]": {"^Jo(h|a)n$"}, "columns[0][search][regex]": {"true"}}), strict, nil},
+		{"column index", with(url.Values{"columns[201][data]": {"x"}}), DefaultParseOptions, ErrTooManyColumns},
+		{"column limit", with(url.Values{"columns[3][data]": {"x"}}), strict, ErrTooManyColumns},
+		{"order limit", with(url.Values{"order[3][column]": {"0"}}), strict, ErrTooManyColumns},
+		{"all records", with(url.Values{"length": {"-1"}}), ParseOptions{}, ErrInvalidPaging},
+		{"length", with(url.Values{"length": {"101"}}), strict, ErrInvalidPaging},
+		{"negative length", with(url.Values{"length": {"-2"}}), DefaultParseOptions, ErrInvalidPaging},
+		{"negative start", with(url.Values{"start": {"-10"}}), DefaultParseOptions, ErrInvalidPaging},
+		{"global regex", with(url.Values{"search[value]": {"^Jo"}, "search[regex]": {"true"}}), strict, ErrRegexNotAllowed},
+		{"column regex", with(url.Values{"columns[1][search][value]": {"^S"}, "columns[1][search][regex]": {"true"}}), strict, ErrRegexNotAllowed},
+		{"nested repeat", with(url.Values{"columns[0][search][value]": {"((a{10}){10}){10}"}, "columns[0][search][regex]": {"true"}}), strict, ErrRegexTooComplex},
+		{"long regex", with(url.Values{"columns[0][search][value]": {strings.Repeat("(a|bc)", 20)}, "columns[0][search][regex]": {"true"}}), strict, ErrRegexTooComplex},
+		{"invalid regex", with(url.Values{"columns[0][search][value]": {"(?<=x)a"}, "columns[0][search][regex]": {"true"}}), strict, ErrRegexTooComplex},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/data?"+test.form.Encode(), nil)
+		di, err := ParseDatatablesRequestWithOptions(r, test.opts)
+		if test.expected == nil {
+			if err != nil || di == nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.expected) || !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: expected %v got %v", test.name, test.expected, err)
+		}
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) || reqErr.Field == "" {
+			t.Errorf("%s: expected a RequestError got %#v", test.name, err)
+		}
+	}
+
+	// not a DataTables request is not a validation failure
+	r, _ := http.NewRequest("GET", "/data?length=-1", nil)
+	if _, err := ParseDatatablesRequestWithOptions(r, ParseOptions{}); err != ErrNotDataTablesReq {
+		t.Errorf("expected ErrNotDataTablesReq got %v", err)
+	}
+}
+
+func TestParseOptionsJSON(t *testing.T) {
+	columns := make([]string, 4)
+	for i := range columns {
+		columns[i] = fmt.Sprintf(`{"data": "c%d"}`, i)
+	}
+	body := `{"draw": 1, "length": 10, "columns": [` + strings.Join(columns, ",") + `]}`
+	r, _ := http.NewRequest("POST", "/data", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if _, err := ParseDatatablesRequestWithOptions(r, ParseOptions{MaxColumns: 3}); !errors.Is(err, ErrTooManyColumns) {
+		t.Errorf("expected ErrTooManyColumns got %v", err)
+	}
+}
+
+func TestHandlerWithOptions(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+	src := &SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap}
+
+	// the recorded request asks for a page of 3
+	w := httptest.NewRecorder()
+	HandlerWithOptions(src, ParseOptions{MaxLength: 2}).ServeHTTP(w, recordedRequest(t, "request_page.txt"))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrInvalidPaging.Error()) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	HandlerWithOptions(src, ParseOptions{MaxLength: 3}).ServeHTTP(w, recordedRequest(t, "request_page.txt"))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,238 @@
+package datatablessrv
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// identRegex is the only shape of SQL identifier we will ever put in a generated query.
+// It allows a plain column name or a table qualified one (t1.dateadded).
+var identRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// likeEscaper escapes the LIKE wildcards so that the search value is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// QuoteMySQLIdentifier validates that name is a plain (optionally table qualified) identifier
+// and returns it quoted with backticks, ie. t1.dateadded becomes `t1`.`dateadded`
+func QuoteMySQLIdentifier(name string) (quoted string, err error) {
+	return quoteIdentifier(name, "`")
+}
+
+// queryArgs collects the arguments of a query while it is being generated
+type queryArgs struct {
+	dialect Dialect
+	args    []interface{}
+}
+
+// bind is the Binder handed to the Dialect
+func (q *queryArgs) bind(value interface{}) string {
+	q.args = append(q.args, value)
+	return q.dialect.Placeholder(len(q.args))
+}
+
+// fieldName looks up the column in the allow-list and returns the quoted SQL identifier
+func fieldName(dialect Dialect, colData ColData, SQLFieldMap map[string]string) (sqlName string, err error) {
+	name, isFound := SQLFieldMap[colData.Data]
+	if !isFound {
+		err = fmt.Errorf("Column Data Name %v not found in SQL FieldMap", colData.Data)
+		return
+	}
+	return dialect.QuoteIdentifier(name)
+}
+
+// BuildFilter is the parameterized version of MySQLFilter for any Dialect. Instead of splicing the search
+// values into the string, every value is replaced by a placeholder and returned in args in the
+// order that the placeholders appear, so that the result can be handed straight to database/sql.
+// Only columns present in SQLFieldMap (keyed by ColData.Data) can ever make it into the query and
+// the mapped names must be valid identifiers, which are quoted.
+// For MySQL the generated filter has the form
+//
+//	(MATCH(`f1`) AGAINST(?) OR MATCH(`f2`) AGAINST(?)) AND `f1` LIKE ? AND `f3` REGEXP ?
+//
+// where the first group is the global search and the rest are the individual column searches.
+// Columns with a Type other than ColText are compared with the conditions parsed by ParseColumnFilter
+// (`f4` >= ? AND `f4` <= ?, `f5` IN (?, ?)) instead. The nested Filter of the SearchBuilder and
+// SearchPanes extensions is AND'ed to all of that.
+// The values are taken from RawSearchval, so that the quoting done by ParseDatatablesRequest
+// for MySQLFilter does not end up in the database.
+// NOTE: as with MySQLFilter it is the responsibility of the caller to put the " WHERE " in front
+// of the string when it is non-null. Numbered placeholders (PostgreSQL) start at $1.
+func (di *DataTablesInfo) BuildFilter(dialect Dialect, SQLFieldMap map[string]string) (res string, args []interface{}, err error) {
+	q := &queryArgs{dialect: dialect}
+	res, err = di.buildFilter(q, SQLFieldMap)
+	args = q.args
+	return
+}
+
+func (di *DataTablesInfo) buildFilter(q *queryArgs, SQLFieldMap map[string]string) (res string, err error) {
+	var global, parts []string
+	// The global search goes first, each searchable column may match it
+	if di.RawSearchval != "" {
+		for _, colData := range di.Columns {
+			if !colData.Searchable {
+				continue
+			}
+			var sqlName string
+			sqlName, err = fieldName(q.dialect, colData, SQLFieldMap)
+			if err != nil {
+				return
+			}
+			global = append(global, q.dialect.GlobalSearch(sqlName, di.RawSearchval, di.UseRegex, q.bind))
+		}
+	}
+	if len(global) > 0 {
+		parts = append(parts, "("+strings.Join(global, " OR ")+")")
+	}
+	// Then every individual column search has to match as well
+	for _, colData := range di.Columns {
+		if !colData.Searchable || colData.RawSearchval == "" {
+			continue
+		}
+		var sqlName string
+		sqlName, err = fieldName(q.dialect, colData, SQLFieldMap)
+		if err != nil {
+			return
+		}
+		if colData.Type != ColText {
+			var conds []Condition
+			conds, err = ParseColumnFilter(colData.Type, colData.RawSearchval)
+			if err != nil {
+				return
+			}
+			parts = append(parts, typedSearch(sqlName, conds, q.bind))
+			continue
+		}
+		parts = append(parts, q.dialect.ColumnSearch(sqlName, colData.RawSearchval, colData.UseRegex, q.bind))
+	}
+	// And finally the SearchBuilder/SearchPanes filter
+	if di.Filter != nil {
+		var part string
+		if part, err = di.renderFilter(q, di.Filter, SQLFieldMap); err != nil {
+			return
+		}
+		parts = append(parts, part)
+	}
+	res = strings.Join(parts, " AND ")
+	return
+}
+
+// BuildOrderby is the identifier checked version of MySQLOrderby for any Dialect. The column names come from
+// SQLFieldMap and are validated and quoted. The string IS prefixed by a space so that you can just append it.
+func (di *DataTablesInfo) BuildOrderby(dialect Dialect, SQLFieldMap map[string]string) (res string, err error) {
+	extra := " ORDER BY "
+	for _, orderItem := range di.Order {
+		// Make sure that the column is in range
+		if orderItem.ColNum < 0 || orderItem.ColNum >= len(di.Columns) {
+			err = fmt.Errorf("Datatables Request order column %v out of range %v of columns", orderItem.ColNum, len(di.Columns))
+			return
+		}
+		colData := di.Columns[orderItem.ColNum]
+		if !colData.Orderable {
+			err = fmt.Errorf("Datatables requested ordering on non-orderable column %v", colData.Data)
+			return
+		}
+		var sqlName string
+		sqlName, err = fieldName(dialect, colData, SQLFieldMap)
+		if err != nil {
+			return
+		}
+		res += extra + sqlName
+		if orderItem.Direction == Desc {
+			res += " DESC"
+		}
+		extra = ","
+	}
+	// If for some reason we got to the end with no columns, then we give them the order by the first item
+	if res == "" {
+		res = extra + "1"
+	}
+	return
+}
+
+// BuildQuery is the parameterized replacement for MySQLGenerateQueryFromColNames for any Dialect.
+// It selects every column of the request (mapped through SQLFieldMap) from tableName, applies
+// BuildFilter and BuildOrderby and pages the result with the paging clause of the Dialect
+// (none is generated when Length is -1). The returned args line up with the placeholders.
+func (di *DataTablesInfo) BuildQuery(dialect Dialect, tableName string, SQLFieldMap map[string]string) (query string, args []interface{}, err error) {
+	table, err := dialect.QuoteIdentifier(tableName)
+	if err != nil {
+		return
+	}
+	if len(di.Columns) == 0 {
+		err = fmt.Errorf("Datatables request has no columns")
+		return
+	}
+	if di.Start < 0 || di.Length < -1 {
+		err = fmt.Errorf("Datatables request has invalid paging start %v length %v", di.Start, di.Length)
+		return
+	}
+	var selectCols []string
+	for _, colData := range di.Columns {
+		var sqlName string
+		sqlName, err = fieldName(dialect, colData, SQLFieldMap)
+		if err != nil {
+			return
+		}
+		selectCols = append(selectCols, sqlName)
+	}
+	q := &queryArgs{dialect: dialect}
+	where, err := di.buildFilter(q, SQLFieldMap)
+	if err != nil {
+		return
+	}
+	orderBy, err := di.BuildOrderby(dialect, SQLFieldMap)
+	if err != nil {
+		return
+	}
+
+	query = "SELECT " + strings.Join(selectCols, ",") + " FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += orderBy
+	if di.Length >= 0 {
+		query += dialect.Limit(di.Start, di.Length, q.bind)
+	}
+	args = q.args
+	return
+}
+
+// MySQLBuildFilter is BuildFilter for the MySQL dialect
+func (di *DataTablesInfo) MySQLBuildFilter(SQLFieldMap map[string]string) (res string, args []interface{}, err error) {
+	return di.BuildFilter(MySQL, SQLFieldMap)
+}
+
+// MySQLBuildOrderby is BuildOrderby for the MySQL dialect
+func (di *DataTablesInfo) MySQLBuildOrderby(SQLFieldMap map[string]string) (res string, err error) {
+	return di.BuildOrderby(MySQL, SQLFieldMap)
+}
+
+// MySQLBuildQuery is BuildQuery for the MySQL dialect
+func (di *DataTablesInfo) MySQLBuildQuery(tableName string, SQLFieldMap map[string]string) (query string, args []interface{}, err error) {
+	return di.BuildQuery(MySQL, tableName, SQLFieldMap)
+}
+
+// BuildCountQuery generates the query counting the records of tableName for the response to DataTables.
+// When filtered is true the filter of the request is applied (recordsFiltered), otherwise every record
+// is counted (recordsTotal).
+func (di *DataTablesInfo) BuildCountQuery(dialect Dialect, tableName string, SQLFieldMap map[string]string, filtered bool) (query string, args []interface{}, err error) {
+	table, err := dialect.QuoteIdentifier(tableName)
+	if err != nil {
+		return
+	}
+	query = "SELECT COUNT(*) FROM " + table
+	if !filtered {
+		return
+	}
+	q := &queryArgs{dialect: dialect}
+	where, err := di.buildFilter(q, SQLFieldMap)
+	if err != nil {
+		return
+	}
+	if where != "" {
+		query += " WHERE " + where
+	}
+	args = q.args
+	return
+}
@@ -0,0 +1,128 @@
+package datatablessrv
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFieldMap = map[string]string{
+	"first":  "first_name",
+	"last":   "last_name",
+	"age":    "p.age",
+	"hidden": "secret",
+}
+
+func TestMySQLBuildQuery(t *testing.T) {
+	testData := DataTablesInfo{
+		Draw:         1,
+		HasFilter:    true,
+		Start:        20,
+		Length:       10,
+		RawSearchval: "geo'rge",
+		Columns: []ColData{
+			{Data: "first", Orderable: true, Searchable: true, RawSearchval: "50%_off"},
+			{Data: "last", Orderable: true, Searchable: true, RawSearchval: "^bo.*", UseRegex: true},
+			{Data: "age", Orderable: true, Searchable: false, RawSearchval: "ignored"},
+		},
+		Order: []OrderInfo{
+			{ColNum: 0, Direction: Asc},
+			{ColNum: 2, Direction: Desc},
+		},
+	}
+
+	query, args, err := testData.MySQLBuildQuery("person", testFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	expectedQuery := "SELECT `first_name`,`last_name`,`p`.`age` FROM `person`" +
+		" WHERE (MATCH(`first_name`) AGAINST(?) OR MATCH(`last_name`) AGAINST(?))" +
+		" AND `first_name` LIKE ? AND `last_name` REGEXP ?" +
+		" ORDER BY `first_name`,`p`.`age` DESC LIMIT ?, ?"
+	if query != expectedQuery {
+		t.Errorf("unexpected query:\n got: %s\nwant: %s", query, expectedQuery)
+	}
+	expectedArgs := []interface{}{"geo'rge", "geo'rge", `%50\%\_off%`, "^bo.*", 20, 10}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected args: got %#v want %#v", args, expectedArgs)
+	}
+	if strings.Count(query, "?") != len(args) {
+		t.Errorf("placeholder count %d does not match args %d", strings.Count(query, "?"), len(args))
+	}
+}
+
+func TestMySQLBuildQueryRejectsInjection(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    string
+		fieldMap map[string]string
+		columns  []ColData
+	}{
+		{
+			name:     "column not in allow-list",
+			table:    "person",
+			fieldMap: testFieldMap,
+			columns:  []ColData{{Data: "first_name FROM test'; DROP TABLE test; SELECT first_name"}},
+		},
+		{
+			name:     "bad identifier in field map",
+			table:    "person",
+			fieldMap: map[string]string{"first": "first_name`; DROP TABLE test; --"},
+			columns:  []ColData{{Data: "first"}},
+		},
+		{
+			name:     "bad table name",
+			table:    "person; DROP TABLE test",
+			fieldMap: testFieldMap,
+			columns:  []ColData{{Data: "first"}},
+		},
+	}
+	for _, tt := range tests {
+		testData := DataTablesInfo{Length: 10, Columns: tt.columns}
+		if query, _, err := testData.MySQLBuildQuery(tt.table, tt.fieldMap); err == nil {
+			t.Errorf("%s: expected an error, got query %s", tt.name, query)
+		}
+	}
+}
+
+func TestMySQLBuildFilterFromRequest(t *testing.T) {
+	form := url.Values{
+		"draw":                      {"3"},
+		"start":                     {"0"},
+		"length":                    {"-1"},
+		"search[value]":             {`x' OR '1'='1`},
+		"search[regex]":             {"false"},
+		"columns[0][data]":          {"first"},
+		"columns[0][searchable]":    {"true"},
+		"columns[0][orderable]":     {"true"},
+		"columns[0][search][value]": {""},
+		"columns[0][search][regex]": {"false"},
+		"columns[1][data]":          {"hidden"},
+		"columns[1][searchable]":    {"false"},
+		"columns[1][orderable]":     {"false"},
+		"columns[1][search][value]": {""},
+		"columns[1][search][regex]": {"false"},
+	}
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	di, err := ParseDatatablesRequest(r)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	query, args, err := di.MySQLBuildQuery("person", testFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	expectedQuery := "SELECT `first_name`,`secret` FROM `person` WHERE (MATCH(`first_name`) AGAINST(?)) ORDER BY 1"
+	if query != expectedQuery {
+		t.Errorf("unexpected query:\n got: %s\nwant: %s", query, expectedQuery)
+	}
+	expectedArgs := []interface{}{`x' OR '1'='1`}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected args: got %#v want %#v", args, expectedArgs)
+	}
+}
@@ -0,0 +1,269 @@
+package datatablessrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxJSONBody limits how much of a JSON request body we are willing to read
+	maxJSONBody = 1 << 20
+	// maxFormDepth limits the nesting of the searchBuilder[...][...] form fields
+	maxFormDepth = 16
+	// maxFormIndex is the highest array index we accept in nested form fields
+	maxFormIndex = 200
+)
+
+// formSegmentRegex matches the [segment] parts of a nested form field name
+var formSegmentRegex = regexp.MustCompile(`^\[([^\[\]]*)\]`)
+
+// flexString accepts a JSON string, number, boolean or null. DataTables sends numbers
+// as strings in a form but as numbers in JSON and columns[i][data] may be either.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) (err error) {
+	switch {
+	case string(b) == "null":
+		*f = ""
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		err = json.Unmarshal(b, &s)
+		*f = flexString(s)
+	case len(b) > 0 && (b[0] == '{' || b[0] == '['):
+		err = fmt.Errorf("expected a string, got %s", b)
+	default:
+		*f = flexString(b)
+	}
+	return
+}
+
+// atoi converts the value to an int, an empty value is 0
+func (f flexString) atoi() (int, error) {
+	if f == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(string(f))
+}
+
+// jsonSearch is the search object of the request, both global and per column
+type jsonSearch struct {
+	Value flexString `json:"value"`
+	Regex flexString `json:"regex"`
+}
+
+// jsonRequest is the request DataTables sends when ajax.data returns JSON.stringify(d)
+type jsonRequest struct {
+	Draw   *flexString `json:"draw"`
+	Start  flexString  `json:"start"`
+	Length flexString  `json:"length"`
+	Search jsonSearch  `json:"search"`
+	Order  []struct {
+		Column flexString `json:"column"`
+		Dir    flexString `json:"dir"`
+	} `json:"order"`
+	Columns []struct {
+		Data       flexString `json:"data"`
+		Name       flexString `json:"name"`
+		Searchable flexString `json:"searchable"`
+		Orderable  flexString `json:"orderable"`
+		Search     jsonSearch `json:"search"`
+	} `json:"columns"`
+	SearchBuilder *searchBuilderGroup     `json:"searchBuilder"`
+	SearchPanes   map[string][]flexString `json:"searchPanes"`
+	Cursor        flexString              `json:"cursor"`
+}
+
+// isJSONRequest checks the Content-Type of the request
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// parseJSON parses a DataTables request sent as a JSON body into res
+func parseJSON(r *http.Request, res *DataTablesInfo, opts *ParseOptions) (foundDraw bool, err error) {
+	var req jsonRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxJSONBody))
+	if err = dec.Decode(&req); err != nil {
+		err = fmt.Errorf("Invalid DataTables JSON request: %v", err)
+		return
+	}
+	if req.Draw == nil {
+		return
+	}
+	foundDraw = true
+	if res.Draw, err = req.Draw.atoi(); err != nil {
+		return
+	}
+	if res.Start, err = req.Start.atoi(); err != nil {
+		return
+	}
+	if res.Length, err = req.Length.atoi(); err != nil {
+		return
+	}
+	res.Searchval = string(req.Search.Value)
+	res.RawSearchval = res.Searchval
+	res.UseRegex = req.Search.Regex == "true"
+	res.Cursor = string(req.Cursor)
+	// Same sanity check as parseParts does for the form
+	if len(req.Columns) > opts.maxColumns() || len(req.Order) > opts.maxColumns() {
+		err = &RequestError{Err: ErrTooManyColumns, Field: "columns", Detail: fmt.Sprintf("over %v", opts.maxColumns())}
+		return
+	}
+	for _, order := range req.Order {
+		info := OrderInfo{Direction: Asc}
+		if info.ColNum, err = order.Column.atoi(); err != nil {
+			return
+		}
+		if order.Dir == "desc" {
+			info.Direction = Desc
+		}
+		res.Order = append(res.Order, info)
+	}
+	for _, col := range req.Columns {
+		res.Columns = append(res.Columns, ColData{
+			Name:         string(col.Name),
+			Data:         string(col.Data),
+			Searchable:   col.Searchable != "false",
+			Orderable:    col.Orderable != "false",
+			Searchval:    string(col.Search.Value),
+			RawSearchval: string(col.Search.Value),
+			UseRegex:     col.Search.Regex != "false" && col.Search.Regex != "",
+		})
+	}
+	res.Filter, err = newFilter(req.SearchBuilder, req.SearchPanes)
+	return
+}
+
+// parseFormFilter parses the nested searchBuilder[...] and searchPanes[...] form fields
+// and builds the Filter from them
+func parseFormFilter(form url.Values) (filter *FilterNode, err error) {
+	var searchBuilder *searchBuilderGroup
+	var searchPanes map[string][]flexString
+	if err = decodeFormTree(form, "searchBuilder", &searchBuilder); err != nil {
+		return
+	}
+	if err = decodeFormTree(form, "searchPanes", &searchPanes); err != nil {
+		return
+	}
+	return newFilter(searchBuilder, searchPanes)
+}
+
+// decodeFormTree decodes the form fields starting with prefix[ into v the same way
+// encoding/json would decode the object that jQuery.param encoded into them.
+// v is left untouched when there are no such fields.
+func decodeFormTree(form url.Values, prefix string, v interface{}) (err error) {
+	tree, err := formTree(form, prefix)
+	if err != nil || tree == nil {
+		return
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		err = fmt.Errorf("Invalid %s[] elements: %v", prefix, err)
+	}
+	return
+}
+
+// formTree turns the fields named like prefix[a][0][b] and prefix[a][] into nested
+// maps and slices, as in {"a": [{"b": ...}]}
+func formTree(form url.Values, prefix string) (tree interface{}, err error) {
+	var root map[string]interface{}
+	for field, values := range form {
+		if !strings.HasPrefix(field, prefix+"[") {
+			continue
+		}
+		var segments []string
+		rest := field[len(prefix):]
+		for rest != "" {
+			m := formSegmentRegex.FindStringSubmatch(rest)
+			if m == nil || len(segments) >= maxFormDepth {
+				err = fmt.Errorf("Invalid %s[] element %v", prefix, field)
+				return
+			}
+			segments = append(segments, m[1])
+			rest = rest[len(m[0]):]
+		}
+		if root == nil {
+			root = map[string]interface{}{}
+		}
+		node := root
+		for i, segment := range segments {
+			if segment == "" {
+				err = fmt.Errorf("Invalid %s[] element %v", prefix, field)
+				return
+			}
+			// name[] is an array of plain values
+			if i == len(segments)-2 && segments[i+1] == "" {
+				node[segment] = values
+				break
+			}
+			if i == len(segments)-1 {
+				node[segment] = values[0]
+				break
+			}
+			child, isMap := node[segment].(map[string]interface{})
+			if !isMap {
+				child = map[string]interface{}{}
+				node[segment] = child
+			}
+			node = child
+		}
+	}
+	if root == nil {
+		return
+	}
+	// The top level keys are always names, searchPanes[0][] is the pane of column 0
+	for key, value := range root {
+		if root[key], err = normalizeFormTree(value); err != nil {
+			return
+		}
+	}
+	tree = root
+	return
+}
+
+// normalizeFormTree turns the maps that only have numeric keys into slices, ordered by index
+func normalizeFormTree(node interface{}) (res interface{}, err error) {
+	m, isMap := node.(map[string]interface{})
+	if !isMap {
+		return node, nil
+	}
+	type item struct {
+		index int
+		value interface{}
+	}
+	items := make([]item, 0, len(m))
+	numeric := len(m) > 0
+	for key, value := range m {
+		if m[key], err = normalizeFormTree(value); err != nil {
+			return
+		}
+		index, convErr := strconv.Atoi(key)
+		if convErr != nil || index < 0 {
+			numeric = false
+		} else if index > maxFormIndex {
+			err = fmt.Errorf("Form array index %v out of range", index)
+			return
+		}
+		items = append(items, item{index, m[key]})
+	}
+	if !numeric {
+		return m, nil
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].index < items[j].index })
+	list := make([]interface{}, 0, len(items))
+	for _, it := range items {
+		list = append(list, it.value)
+	}
+	return list, nil
+}
@@ -0,0 +1,180 @@
+package datatablessrv
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testJSONRequest = `{
+  "draw": 7,
+  "columns": [
+    {"data": "id", "name": "", "searchable": false, "orderable": true, "search": {"value": "", "regex": false}},
+    {"data": "first", "name": "", "searchable": true, "orderable": true, "search": {"value": "", "regex": false}},
+    {"data": "age", "name": "", "searchable": true, "orderable": true, "search": {"value": "", "regex": false}},
+    {"data": "city", "name": "", "searchable": true, "orderable": true, "search": {"value": "", "regex": false}}
+  ],
+  "order": [{"column": 2, "dir": "desc"}],
+  "start": 0,
+  "length": 10,
+  "search": {"value": "", "regex": false},
+  "searchBuilder": {
+    "criteria": [
+      {"condition": "starts", "data": "First name", "origData": "first", "type": "string", "value": ["Jo"]},
+      {
+        "criteria": [
+          {"condition": ">", "data": "Age", "origData": "age", "type": "num", "value": ["50"]},
+          {"condition": "between", "data": "Age", "origData": "age", "type": "num", "value": ["30", "40"]},
+          {"condition": "=", "data": "Age", "origData": "age", "type": "num", "value": []}
+        ],
+        "logic": "OR"
+      }
+    ],
+    "logic": "AND"
+  },
+  "searchPanes": {"city": ["Toronto", "Ottawa"]}
+}`
+
+func TestParseJSONRequest(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/data", strings.NewReader(testJSONRequest))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	di, err := ParseDatatablesRequest(r)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if di.Draw != 7 || di.Length != 10 || len(di.Columns) != 4 || di.Columns[0].Searchable || !di.HasFilter {
+		t.Errorf("unexpected request %+v", di)
+	}
+	if !reflect.DeepEqual(di.Order, []OrderInfo{{ColNum: 2, Direction: Desc}}) {
+		t.Errorf("unexpected order %+v", di.Order)
+	}
+
+	query, args, err := di.MySQLBuildQuery("person", personFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	expected := "SELECT `id`,`first_name`,`age`,`city` FROM `person` WHERE ((`first_name` LIKE ?" +
+		" AND (`age` > ? OR (`age` >= ? AND `age` <= ?))) AND `city` IN (?, ?)) ORDER BY `age` DESC LIMIT ?, ?"
+	if query != expected {
+		t.Errorf("unexpected query:\n got: %s\nwant: %s", query, expected)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"Jo%", 50.0, 30.0, 40.0, "Toronto", "Ottawa", 0, 10}) {
+		t.Errorf("unexpected args %#v", args)
+	}
+
+	// and run it for real: Jo* aged over 50 or between 30 and 40 in Toronto or Ottawa
+	db := openPersonDB(t)
+	defer db.Close()
+	query, args, err = di.BuildQuery(SQLite, "person", personFieldMap)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ids := queryIDs(t, db, query, args); !reflect.DeepEqual(ids, []int{6, 1}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+}
+
+func TestParseFormSearchBuilder(t *testing.T) {
+	form := url.Values{
+		"draw":                                               {"1"},
+		"length":                                             {"-1"},
+		"columns[0][data]":                                   {"status"},
+		"columns[1][data]":                                   {"id"},
+		"searchBuilder[logic]":                               {"OR"},
+		"searchBuilder[criteria][0][condition]":              {"!contains"},
+		"searchBuilder[criteria][0][data]":                   {"Status"},
+		"searchBuilder[criteria][0][origData]":               {"status"},
+		"searchBuilder[criteria][0][type]":                   {"string"},
+		"searchBuilder[criteria][0][value][]":                {"ail"},
+		"searchBuilder[criteria][1][logic]":                  {"AND"},
+		"searchBuilder[criteria][1][criteria][0][condition]": {"null"},
+		"searchBuilder[criteria][1][criteria][0][origData]":  {"Contact.Email"},
+		"searchBuilder[criteria][1][criteria][1][condition]": {"!="},
+		"searchBuilder[criteria][1][criteria][1][origData]":  {"id"},
+		"searchBuilder[criteria][1][criteria][1][type]":      {"num"},
+		"searchBuilder[criteria][1][criteria][1][value][0]":  {"4"},
+		"searchPanes[id][]":                                  {"1", "2", "3"},
+	}
+	r, _ := http.NewRequest("POST", "/data", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	di, err := ParseDatatablesRequest(r)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	expected := &FilterNode{Logic: "AND", Children: []*FilterNode{
+		{Logic: "OR", Children: []*FilterNode{
+			{Data: "status", Condition: "!contains", Values: []string{"ail"}},
+			{Logic: "AND", Children: []*FilterNode{
+				{Data: "Contact.Email", Condition: "null"},
+				{Data: "id", Condition: "!=", Type: ColFloat, Values: []string{"4"}},
+			}},
+		}},
+		{Data: "id", Condition: "in", Values: []string{"1", "2", "3"}},
+	}}
+	if !reflect.DeepEqual(di.Filter, expected) {
+		t.Errorf("unexpected filter %+v", di.Filter)
+	}
+
+	filter, args, err := di.MySQLBuildFilter(map[string]string{"status": "status", "id": "id", "Contact.Email": "email"})
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	expectedSQL := "((NOT (`status` LIKE ?) OR ((`email` IS NULL OR `email` = '') AND NOT (`id` = ?))) AND `id` IN (?, ?, ?))"
+	if filter != expectedSQL {
+		t.Errorf("unexpected filter:\n got: %s\nwant: %s", filter, expectedSQL)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"%ail%", 4.0, "1", "2", "3"}) {
+		t.Errorf("unexpected args %#v", args)
+	}
+
+	// the same filter over the in-memory records: not failed, or no contact, within ids 1 to 3
+	di.Columns[1].Type = ColInt
+	page, _, _, err := di.ApplyToSlice(testRecords, nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ids := recordIDs(page); !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+}
+
+func TestParseFormFilterInvalid(t *testing.T) {
+	for _, form := range []url.Values{
+		{"draw": {"1"}, "searchBuilder[criteria][0][condition]": {"drop"}, "searchBuilder[criteria][0][value][]": {"x"}},
+		{"draw": {"1"}, "searchBuilder[logic]": {"XOR"}, "searchBuilder[criteria][0][condition]": {"null"}},
+		{"draw": {"1"}, "searchBuilder[criteria][5000][condition]": {"null"}},
+		{"draw": {"1"}, "searchPanes[city][x": {"Toronto"}},
+		{"draw": {"1"}, "searchBuilder" + strings.Repeat("[criteria][0]", 20) + "[condition]": {"null"}},
+	} {
+		r, _ := http.NewRequest("GET", "/data?"+form.Encode(), nil)
+		if _, err := ParseDatatablesRequest(r); err == nil {
+			t.Errorf("expected an error for %v", form)
+		}
+	}
+}
+
+func TestSearchPanesOrder(t *testing.T) {
+	panes := map[string][]flexString{
+		"status": {"sent"},
+		"city":   {"Toronto", "Ottawa"},
+		"id":     {"1"},
+		"age":    {},
+	}
+	expected := &FilterNode{Logic: "AND", Children: []*FilterNode{
+		{Data: "city", Condition: "in", Values: []string{"Toronto", "Ottawa"}},
+		{Data: "id", Condition: "in", Values: []string{"1"}},
+		{Data: "status", Condition: "in", Values: []string{"sent"}},
+	}}
+	// map iteration order varies between runs, the filter doesn't
+	for i := 0; i < 20; i++ {
+		filter, err := newFilter(nil, panes)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if !reflect.DeepEqual(filter, expected) {
+			t.Fatalf("unexpected filter %+v", filter)
+		}
+	}
+}
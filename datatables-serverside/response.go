@@ -0,0 +1,90 @@
+package datatablessrv
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Response is the reply to a DataTables server side processing request
+// as described in https://datatables.net/manual/server-side#Returned-data
+type Response struct {
+	// Draw is the draw counter of the request this response is for (DataTablesInfo.Draw)
+	Draw int `json:"draw"`
+	// RecordsTotal is the total number of records, before filtering
+	RecordsTotal int `json:"recordsTotal"`
+	// RecordsFiltered is the number of records after filtering (not just the ones in this page)
+	RecordsFiltered int `json:"recordsFiltered"`
+	// Data holds the records of the page to display, typically a slice of structs or maps
+	Data interface{} `json:"data"`
+	// Error is the message to display to the user when something went wrong
+	Error string `json:"error,omitempty"`
+	// NextCursor is the keyset pagination cursor of the next page, when the DataSource is a CursorSource
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// DataSource provides the records for a DataTables request
+type DataSource interface {
+	// Count returns the total number of records and the number of records matching the filter of the request
+	Count(di *DataTablesInfo) (total int, filtered int, err error)
+	// Page returns the records of the page requested, filtered and ordered
+	Page(di *DataTablesInfo) (data interface{}, err error)
+}
+
+// CursorSource is a DataSource that uses keyset pagination and hands out a cursor for the next page
+type CursorSource interface {
+	DataSource
+	// NextCursor returns the cursor for the page after data (as returned by Page), empty when it was the last page
+	NextCursor(di *DataTablesInfo, data interface{}) (cursor string, err error)
+}
+
+// NewResponse runs the count and page queries of src for the request and fills in the Response.
+// On failure the error is returned as well as set in the Response, so that it can be sent to DataTables.
+func NewResponse(di *DataTablesInfo, src DataSource) (res Response, err error) {
+	res.Draw = di.Draw
+	res.Data = []interface{}{}
+	res.RecordsTotal, res.RecordsFiltered, err = src.Count(di)
+	if err == nil {
+		var data interface{}
+		data, err = src.Page(di)
+		if err == nil && data != nil {
+			res.Data = data
+		}
+		if cursorSrc, isCursorSrc := src.(CursorSource); isCursorSrc && err == nil {
+			res.NextCursor, err = cursorSrc.NextCursor(di, data)
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return
+}
+
+// WriteResponse writes res as JSON with the given status code
+func WriteResponse(w http.ResponseWriter, status int, res Response) error {
+	if res.Data == nil {
+		res.Data = []interface{}{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(res)
+}
+
+// Handler returns an http.Handler serving DataTables server side processing requests from src.
+// A request that can't be parsed gets a 400 Bad Request, failures of src are reported to
+// DataTables in the error field of the Response (with a 200 so that DataTables displays it).
+func Handler(src DataSource) http.Handler {
+	return HandlerWithOptions(src, DefaultParseOptions)
+}
+
+// HandlerWithOptions is Handler with the request limits of opts, see ParseDatatablesRequestWithOptions
+func HandlerWithOptions(src DataSource, opts ParseOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		di, err := ParseDatatablesRequestWithOptions(r, opts)
+		if err != nil {
+			WriteResponse(w, http.StatusBadRequest, Response{Error: err.Error()})
+			return
+		}
+		res, _ := NewResponse(di, src)
+		WriteResponse(w, http.StatusOK, res)
+	})
+}
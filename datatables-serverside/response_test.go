@@ -0,0 +1,66 @@
+package datatablessrv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+	handler := Handler(&SQLSource{DB: db, Dialect: SQLite, Table: "person", FieldMap: personFieldMap})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, recordedRequest(t, "request_global_search.txt"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", w.Code)
+	}
+	var res struct {
+		Draw            int                      `json:"draw"`
+		RecordsTotal    int                      `json:"recordsTotal"`
+		RecordsFiltered int                      `json:"recordsFiltered"`
+		Data            []map[string]interface{} `json:"data"`
+		Error           *string                  `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if res.Draw != 2 || res.RecordsTotal != 6 || res.RecordsFiltered != 5 || res.Error != nil {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+	if len(res.Data) != 5 {
+		t.Fatalf("expected 5 records got %d", len(res.Data))
+	}
+	expected := map[string]interface{}{"id": 2.0, "first": "Anna", "last": "Jones", "age": 28.0, "city": "Ottawa"}
+	if !reflect.DeepEqual(res.Data[0], expected) {
+		t.Errorf("unexpected first record %v", res.Data[0])
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+
+	// a failing query is reported in the error field, with the draw counter echoed
+	handler := Handler(&SQLSource{DB: db, Dialect: SQLite, Table: "missing", FieldMap: personFieldMap})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, recordedRequest(t, "request_page.txt"))
+	var res map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if w.Code != http.StatusOK || res["draw"] != 1.0 || res["error"] == nil || !reflect.DeepEqual(res["data"], []interface{}{}) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	// not a DataTables request at all
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/data?start=0", nil)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 got %d", w.Code)
+	}
+}
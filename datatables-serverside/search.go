@@ -0,0 +1,387 @@
+package datatablessrv
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxFilterDepth limits the nesting of SearchBuilder groups
+const maxFilterDepth = 8
+
+// FilterNode is a node of the nested AND/OR filter built from the SearchBuilder and SearchPanes
+// extensions (https://datatables.net/extensions/searchbuilder/, https://datatables.net/extensions/searchpanes/).
+// A node is either a group of Children combined with Logic, or a criterion on a single column.
+type FilterNode struct {
+	// Logic combines the Children of a group, AND or OR
+	Logic string
+	// Children of a group
+	Children []*FilterNode
+	// Data is the columns[i][data] of the column the criterion applies to, it is mapped
+	// through the SQLFieldMap like any other column
+	Data string
+	// Condition of the criterion, one of the SearchBuilder conditions =, !=, <, <=, >, >=,
+	// between, !between, null, !null, contains, !contains, starts, !starts, ends and !ends
+	// or in for the values selected in a SearchPane
+	Condition string
+	// Type of the values, taken from the SearchBuilder column type. The Type of the
+	// column in DataTablesInfo.Columns takes precedence when it is not ColText
+	Type ColType
+	// Values are the values to compare to, two for between
+	Values []string
+}
+
+// searchBuilderGroup is searchBuilder, or a nested group of criteria, as sent by SearchBuilder
+type searchBuilderGroup struct {
+	Criteria []searchBuilderCriterion `json:"criteria"`
+	Logic    flexString               `json:"logic"`
+}
+
+// searchBuilderCriterion is a single criterion, or a nested group when it has Criteria.
+// Older versions of SearchBuilder send value1 and value2 instead of value.
+type searchBuilderCriterion struct {
+	Criteria  []searchBuilderCriterion `json:"criteria"`
+	Logic     flexString               `json:"logic"`
+	Condition flexString               `json:"condition"`
+	Data      flexString               `json:"data"`
+	OrigData  flexString               `json:"origData"`
+	Type      flexString               `json:"type"`
+	Value     []flexString             `json:"value"`
+	Value1    flexString               `json:"value1"`
+	Value2    flexString               `json:"value2"`
+}
+
+// conditionValues is the number of values each condition needs
+var conditionValues = map[string]int{
+	"=": 1, "!=": 1, "<": 1, "<=": 1, ">": 1, ">=": 1,
+	"between": 2, "!between": 2,
+	"null": 0, "!null": 0,
+	"contains": 1, "!contains": 1, "starts": 1, "!starts": 1, "ends": 1, "!ends": 1,
+}
+
+// newFilter combines the SearchBuilder criteria and the SearchPanes selections (AND) into a single
+// filter, nil if there is nothing to filter on
+func newFilter(searchBuilder *searchBuilderGroup, searchPanes map[string][]flexString) (filter *FilterNode, err error) {
+	var nodes []*FilterNode
+	if searchBuilder != nil {
+		var node *FilterNode
+		node, err = searchBuilderNode(searchBuilder.Criteria, searchBuilder.Logic, 0)
+		if err != nil {
+			return
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	// Values selected within a pane are OR'ed, the panes are AND'ed. The panes are sorted so
+	// the same request always gives the same query.
+	panes := make([]string, 0, len(searchPanes))
+	for data := range searchPanes {
+		panes = append(panes, data)
+	}
+	sort.Strings(panes)
+	for _, data := range panes {
+		selected := searchPanes[data]
+		if len(selected) == 0 {
+			continue
+		}
+		node := &FilterNode{Data: data, Condition: "in"}
+		for _, value := range selected {
+			node.Values = append(node.Values, string(value))
+		}
+		nodes = append(nodes, node)
+	}
+	switch len(nodes) {
+	case 0:
+	case 1:
+		filter = nodes[0]
+	default:
+		filter = &FilterNode{Logic: "AND", Children: nodes}
+	}
+	return
+}
+
+// searchBuilderNode converts a group of criteria, skipping the ones that are not complete
+// yet (SearchBuilder sends them while the user is still filling them in)
+func searchBuilderNode(criteria []searchBuilderCriterion, logic flexString, depth int) (node *FilterNode, err error) {
+	if depth > maxFilterDepth {
+		err = fmt.Errorf("SearchBuilder criteria nested too deep")
+		return
+	}
+	group := &FilterNode{Logic: strings.ToUpper(string(logic))}
+	if group.Logic == "" {
+		group.Logic = "AND"
+	}
+	if group.Logic != "AND" && group.Logic != "OR" {
+		err = fmt.Errorf("Invalid SearchBuilder logic %v", logic)
+		return
+	}
+	for _, criterion := range criteria {
+		var child *FilterNode
+		if len(criterion.Criteria) > 0 {
+			if child, err = searchBuilderNode(criterion.Criteria, criterion.Logic, depth+1); err != nil {
+				return
+			}
+		} else if child, err = searchBuilderLeaf(criterion); err != nil {
+			return
+		}
+		if child != nil {
+			group.Children = append(group.Children, child)
+		}
+	}
+	switch len(group.Children) {
+	case 0:
+	case 1:
+		node = group.Children[0]
+	default:
+		node = group
+	}
+	return
+}
+
+// searchBuilderLeaf converts a single criterion, nil when it is incomplete
+func searchBuilderLeaf(criterion searchBuilderCriterion) (node *FilterNode, err error) {
+	if criterion.Condition == "" {
+		return
+	}
+	condition := string(criterion.Condition)
+	numValues, isFound := conditionValues[condition]
+	if !isFound {
+		err = fmt.Errorf("Invalid SearchBuilder condition %v", condition)
+		return
+	}
+	values := criterion.Value
+	if len(values) == 0 && criterion.Value1 != "" {
+		values = []flexString{criterion.Value1, criterion.Value2}
+	}
+	if len(values) < numValues {
+		return
+	}
+	node = &FilterNode{Condition: condition, Type: searchBuilderType(string(criterion.Type))}
+	// data is the title of the column, origData is the columns[i][data] that we want
+	node.Data = string(criterion.OrigData)
+	if node.Data == "" {
+		node.Data = string(criterion.Data)
+	}
+	for _, value := range values[:numValues] {
+		if value == "" {
+			return nil, nil
+		}
+		node.Values = append(node.Values, string(value))
+	}
+	return
+}
+
+// searchBuilderType maps the SearchBuilder column types to ours
+func searchBuilderType(sbType string) ColType {
+	switch {
+	case strings.Contains(sbType, "num"):
+		return ColFloat
+	case sbType == "date", sbType == "datetime", strings.HasPrefix(sbType, "moment"), strings.HasPrefix(sbType, "luxon"):
+		return ColDate
+	}
+	return ColText
+}
+
+// criterionConditions turns a criterion into the conditions that all have to match. Next to the
+// operators of ParseColumnFilter this uses LIKE (the value being the pattern) and NULL (empty).
+// When negate is true the result of the conditions has to be inverted.
+func (node *FilterNode) criterionConditions(colType ColType) (conds []Condition, negate bool, err error) {
+	condition := node.Condition
+	if strings.HasPrefix(condition, "!") && condition != "!=" {
+		negate = true
+		condition = condition[1:]
+	}
+	if condition == "!=" {
+		negate = true
+		condition = "="
+	}
+	if node.Condition != "in" && len(node.Values) < conditionValues[node.Condition] {
+		err = fmt.Errorf("Missing values for condition %v on %v", node.Condition, node.Data)
+		return
+	}
+	switch condition {
+	case "null":
+		conds = []Condition{{Op: "NULL"}}
+	case "contains":
+		conds = []Condition{{Op: "LIKE", Values: []interface{}{"%" + likeEscaper.Replace(node.Values[0]) + "%"}}}
+	case "starts":
+		conds = []Condition{{Op: "LIKE", Values: []interface{}{likeEscaper.Replace(node.Values[0]) + "%"}}}
+	case "ends":
+		conds = []Condition{{Op: "LIKE", Values: []interface{}{"%" + likeEscaper.Replace(node.Values[0])}}}
+	case "=", "<", "<=", ">", ">=":
+		conds, err = criterionCompare(colType, condition, node.Values[0])
+	case "between":
+		var c []Condition
+		if conds, err = criterionCompare(colType, ">=", node.Values[0]); err != nil {
+			return
+		}
+		if c, err = criterionCompare(colType, "<=", node.Values[1]); err != nil {
+			return
+		}
+		conds = append(conds, c...)
+	case "in":
+		if len(node.Values) == 0 {
+			err = fmt.Errorf("Missing values for condition in on %v", node.Data)
+			return
+		}
+		cond := Condition{Op: "IN"}
+		if colType != ColText {
+			if cond, err = listCondition(colType, node.Values); err != nil {
+				return
+			}
+		} else {
+			for _, value := range node.Values {
+				cond.Values = append(cond.Values, value)
+			}
+		}
+		conds = []Condition{cond}
+	default:
+		err = fmt.Errorf("Invalid filter condition %v", node.Condition)
+	}
+	return
+}
+
+// criterionCompare is compareCondition for text columns as well
+func criterionCompare(colType ColType, op string, value string) ([]Condition, error) {
+	if colType == ColText {
+		return []Condition{{Op: op, Values: []interface{}{value}}}, nil
+	}
+	return compareCondition(colType, op, value)
+}
+
+// column returns the column of the request with the given data, nil if there is none
+func (di *DataTablesInfo) column(data string) *ColData {
+	for i := range di.Columns {
+		if di.Columns[i].Data == data {
+			return &di.Columns[i]
+		}
+	}
+	return nil
+}
+
+// nodeType is the type used for the values of a criterion
+func (di *DataTablesInfo) nodeType(node *FilterNode) ColType {
+	if colData := di.column(node.Data); colData != nil && colData.Type != ColText {
+		return colData.Type
+	}
+	return node.Type
+}
+
+// renderFilter renders the filter tree as SQL
+func (di *DataTablesInfo) renderFilter(q *queryArgs, node *FilterNode, SQLFieldMap map[string]string) (res string, err error) {
+	if len(node.Children) > 0 {
+		var parts []string
+		for _, child := range node.Children {
+			var part string
+			if part, err = di.renderFilter(q, child, SQLFieldMap); err != nil {
+				return
+			}
+			parts = append(parts, part)
+		}
+		if node.Logic != "AND" && node.Logic != "OR" {
+			err = fmt.Errorf("Invalid filter logic %v", node.Logic)
+			return
+		}
+		res = "(" + strings.Join(parts, " "+node.Logic+" ") + ")"
+		return
+	}
+	sqlName, err := fieldName(q.dialect, ColData{Data: node.Data}, SQLFieldMap)
+	if err != nil {
+		return
+	}
+	colType := di.nodeType(node)
+	conds, negate, err := node.criterionConditions(colType)
+	if err != nil {
+		return
+	}
+	var parts []string
+	for _, cond := range conds {
+		switch cond.Op {
+		case "NULL":
+			if colType == ColText {
+				parts = append(parts, "("+sqlName+" IS NULL OR "+sqlName+" = '')")
+			} else {
+				parts = append(parts, sqlName+" IS NULL")
+			}
+		case "LIKE":
+			parts = append(parts, q.dialect.Like(sqlName, cond.Values[0].(string), q.bind))
+		default:
+			parts = append(parts, typedSearch(sqlName, []Condition{cond}, q.bind))
+		}
+	}
+	res = strings.Join(parts, " AND ")
+	if negate {
+		res = "NOT (" + res + ")"
+	} else if len(parts) > 1 {
+		res = "(" + res + ")"
+	}
+	return
+}
+
+// matchFilter is the in-memory version of renderFilter
+func (di *DataTablesInfo) matchFilter(node *FilterNode, record interface{}, accessors map[string]Accessor) (matched bool, err error) {
+	if len(node.Children) > 0 {
+		for _, child := range node.Children {
+			if matched, err = di.matchFilter(child, record, accessors); err != nil {
+				return
+			}
+			if node.Logic == "OR" && matched {
+				return
+			}
+			if node.Logic != "OR" && !matched {
+				return
+			}
+		}
+		return
+	}
+	value, err := columnValue(record, node.Data, accessors)
+	if err != nil {
+		return
+	}
+	conds, negate, err := node.criterionConditions(di.nodeType(node))
+	if err != nil {
+		return
+	}
+	matched = true
+	for _, cond := range conds {
+		switch cond.Op {
+		case "NULL":
+			matched = matchString(value) == ""
+		case "LIKE":
+			matched = value != nil && likeRegexp(cond.Values[0].(string)).MatchString(matchString(value))
+		default:
+			matched = matchConditions(value, []Condition{cond})
+		}
+		if !matched {
+			break
+		}
+	}
+	matched = matched != negate
+	return
+}
+
+// likeRegexp converts a LIKE pattern (with \ as escape) to a case insensitive regular expression
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,337 @@
+package datatablessrv
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Accessor returns the value of a column for a record of an in-memory slice
+type Accessor func(record interface{}) interface{}
+
+// SliceSource is a DataSource over an in-memory slice of structs or maps,
+// see DataTablesInfo.ApplyToSlice for how the request is applied.
+// Note that both Count and Page go through the whole slice.
+type SliceSource struct {
+	// Records is the slice to serve
+	Records interface{}
+	// Accessors optionally maps columns[i][data] to a function returning the value of that column
+	Accessors map[string]Accessor
+}
+
+// Count returns the length of the slice and the number of records matching the filter
+func (s *SliceSource) Count(di *DataTablesInfo) (total int, filtered int, err error) {
+	_, total, filtered, err = di.ApplyToSlice(s.Records, s.Accessors)
+	return
+}
+
+// Page returns the records of the requested page
+func (s *SliceSource) Page(di *DataTablesInfo) (data interface{}, err error) {
+	data, _, _, err = di.ApplyToSlice(s.Records, s.Accessors)
+	return
+}
+
+// ApplyToSlice applies the request to records, which has to be a slice, the same way the SQL
+// generators do: the global search has to match one of the searchable columns and every column
+// search has to match its column, then the result is ordered by the requested columns and paged
+// with Start and Length. Searches are case insensitive "contains" matches, or regular expressions
+// when UseRegex is set, and typed columns (see ParseColumnFilter) are compared by value.
+// The Filter of the SearchBuilder and SearchPanes extensions has to match as well.
+// The values are compared as numbers, times or strings when ordering.
+//
+// The value of a column comes from accessors[columns[i][data]] when there is one, otherwise data is
+// looked up in the record: a dotted path (Email.Name) of struct fields (by name or json tag) or map keys.
+//
+// page is a slice of the same type as records, total is the length of records and filtered the
+// number of records matching the searches.
+func (di *DataTablesInfo) ApplyToSlice(records interface{}, accessors map[string]Accessor) (page interface{}, total int, filtered int, err error) {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		err = fmt.Errorf("Datatables records must be a slice, got %T", records)
+		return
+	}
+	total = v.Len()
+
+	// Prepare the matchers once instead of for every record
+	globalMatch, err := newMatcher(di.RawSearchval, di.UseRegex)
+	if err != nil {
+		return
+	}
+	colMatch := make([]func(interface{}) bool, len(di.Columns))
+	for i, colData := range di.Columns {
+		if !colData.Searchable || colData.RawSearchval == "" {
+			continue
+		}
+		if colData.Type != ColText {
+			var conds []Condition
+			if conds, err = ParseColumnFilter(colData.Type, colData.RawSearchval); err != nil {
+				return
+			}
+			colMatch[i] = func(value interface{}) bool {
+				return matchConditions(value, conds)
+			}
+			continue
+		}
+		var match func(string) bool
+		if match, err = newMatcher(colData.RawSearchval, colData.UseRegex); err != nil {
+			return
+		}
+		colMatch[i] = func(value interface{}) bool {
+			return match(matchString(value))
+		}
+	}
+	for _, orderItem := range di.Order {
+		if orderItem.ColNum < 0 || orderItem.ColNum >= len(di.Columns) {
+			err = fmt.Errorf("Datatables Request order column %v out of range %v of columns", orderItem.ColNum, len(di.Columns))
+			return
+		}
+		if !di.Columns[orderItem.ColNum].Orderable {
+			err = fmt.Errorf("Datatables requested ordering on non-orderable column %v", di.Columns[orderItem.ColNum].Data)
+			return
+		}
+	}
+
+	// Filter, keeping the column values around for ordering
+	type row struct {
+		index  int
+		values []interface{}
+	}
+	var rows []row
+	for i := 0; i < total; i++ {
+		record := v.Index(i).Interface()
+		values := make([]interface{}, len(di.Columns))
+		for c, colData := range di.Columns {
+			if values[c], err = columnValue(record, colData.Data, accessors); err != nil {
+				return
+			}
+		}
+		globalFound := globalMatch == nil
+		matches := true
+		for c, colData := range di.Columns {
+			if !colData.Searchable {
+				continue
+			}
+			if !globalFound && globalMatch(matchString(values[c])) {
+				globalFound = true
+			}
+			if colMatch[c] != nil && !colMatch[c](values[c]) {
+				matches = false
+				break
+			}
+		}
+		if globalFound && matches && di.Filter != nil {
+			if matches, err = di.matchFilter(di.Filter, record, accessors); err != nil {
+				return
+			}
+		}
+		if globalFound && matches {
+			rows = append(rows, row{index: i, values: values})
+		}
+	}
+	filtered = len(rows)
+
+	sort.SliceStable(rows, func(a, b int) bool {
+		for _, orderItem := range di.Order {
+			cmp := compareValues(rows[a].values[orderItem.ColNum], rows[b].values[orderItem.ColNum])
+			if cmp == 0 {
+				continue
+			}
+			if orderItem.Direction == Desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+
+	// Page
+	start := di.Start
+	if start < 0 {
+		start = 0
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+	end := len(rows)
+	if di.Length >= 0 && start+di.Length < end {
+		end = start + di.Length
+	}
+	result := reflect.MakeSlice(v.Type(), 0, end-start)
+	for _, r := range rows[start:end] {
+		result = reflect.Append(result, v.Index(r.index))
+	}
+	page = result.Interface()
+	return
+}
+
+// newMatcher returns nil when there is nothing to search for
+func newMatcher(searchval string, useRegex bool) (match func(string) bool, err error) {
+	if searchval == "" {
+		return
+	}
+	if useRegex {
+		var re *regexp.Regexp
+		re, err = regexp.Compile("(?i)" + searchval)
+		if err != nil {
+			err = fmt.Errorf("Invalid regular expression %q: %v", searchval, err)
+			return
+		}
+		match = re.MatchString
+		return
+	}
+	lower := strings.ToLower(searchval)
+	match = func(s string) bool {
+		return strings.Contains(strings.ToLower(s), lower)
+	}
+	return
+}
+
+// columnValue returns the value of the column named data in record
+func columnValue(record interface{}, data string, accessors map[string]Accessor) (value interface{}, err error) {
+	if accessor, isFound := accessors[data]; isFound {
+		value = accessor(record)
+		return
+	}
+	v := reflect.ValueOf(record)
+	for _, name := range strings.Split(data, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				// a nil somewhere along the path is just an empty value
+				return
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			field, isFound := structField(v, name)
+			if !isFound {
+				err = fmt.Errorf("Column Data Name %v not found in %v", data, v.Type())
+				return
+			}
+			v = field
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				err = fmt.Errorf("Column Data Name %v can't be looked up in %v", data, v.Type())
+				return
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return
+			}
+		default:
+			err = fmt.Errorf("Column Data Name %v can't be looked up in %v", data, v.Type())
+			return
+		}
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.CanInterface() {
+		value = v.Interface()
+	}
+	return
+}
+
+// matchString returns the text searched for in a value, nil values and nil pointers are
+// empty rather than "<nil>"
+func matchString(value interface{}) string {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || !v.CanInterface() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// structField finds the exported field of v by name or by its json tag
+func structField(v reflect.Value, name string) (field reflect.Value, isFound bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Name == name || (tag != "" && tag == name) {
+			return v.Field(i), true
+		}
+	}
+	return
+}
+
+// compareValues orders nil first, then numbers, times and bools by value and anything else as a string
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return compareFloat(fa, fb)
+		}
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+	if ba, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok {
+			switch {
+			case ba == bb:
+				return 0
+			case !ba:
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// toFloat converts any of the numeric kinds to a float64
+func toFloat(value interface{}) (f float64, ok bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return
+}
@@ -0,0 +1,148 @@
+package datatablessrv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testContact struct {
+	Name  string
+	Email string
+}
+
+type testRecord struct {
+	ID      int       `json:"id"`
+	Status  string    `json:"status"`
+	Added   time.Time `json:"added"`
+	Contact *testContact
+}
+
+var testRecords = []testRecord{
+	{ID: 1, Status: "sent", Added: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), Contact: &testContact{"John", "john@example.com"}},
+	{ID: 2, Status: "queued", Added: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), Contact: &testContact{"Anna", "anna@example.org"}},
+	{ID: 3, Status: "failed", Added: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), Contact: nil},
+	{ID: 10, Status: "sent", Added: time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC), Contact: &testContact{"Brian", "brian@example.com"}},
+}
+
+func recordIDs(page interface{}) (ids []int) {
+	for _, record := range page.([]testRecord) {
+		ids = append(ids, record.ID)
+	}
+	return
+}
+
+func TestApplyToSlice(t *testing.T) {
+	columns := []ColData{
+		{Data: "id", Orderable: true, Searchable: false},
+		{Data: "status", Orderable: true, Searchable: true},
+		{Data: "added", Orderable: true, Searchable: false},
+		{Data: "Contact.Email", Orderable: true, Searchable: true},
+	}
+	tests := []struct {
+		name             string
+		di               DataTablesInfo
+		regexCol         int
+		regex            string
+		expectedIDs      []int
+		expectedFiltered int
+	}{
+		{
+			name:             "numeric order desc",
+			di:               DataTablesInfo{Length: 10, Order: []OrderInfo{{ColNum: 0, Direction: Desc}}},
+			expectedIDs:      []int{10, 3, 2, 1},
+			expectedFiltered: 4,
+		},
+		{
+			name:             "global search over nested field",
+			di:               DataTablesInfo{Length: 10, RawSearchval: "EXAMPLE.COM", Order: []OrderInfo{{ColNum: 2, Direction: Asc}}},
+			expectedIDs:      []int{1, 10},
+			expectedFiltered: 2,
+		},
+		{
+			name:             "column regex and multi column order with paging",
+			di:               DataTablesInfo{Start: 1, Length: 1, Order: []OrderInfo{{ColNum: 1}, {ColNum: 0, Direction: Desc}}},
+			regexCol:         1,
+			regex:            "^(sent|queued)$",
+			expectedIDs:      []int{10},
+			expectedFiltered: 3,
+		},
+		{
+			name:             "global search doesn't match nil as text",
+			di:               DataTablesInfo{Length: 10, RawSearchval: "nil"},
+			expectedFiltered: 0,
+		},
+		{
+			name:             "nil field is empty",
+			di:               DataTablesInfo{Length: 10},
+			regexCol:         3,
+			regex:            "^$",
+			expectedIDs:      []int{3},
+			expectedFiltered: 1,
+		},
+		{
+			name:             "all records",
+			di:               DataTablesInfo{Length: -1, Order: []OrderInfo{{ColNum: 3, Direction: Asc}}},
+			expectedIDs:      []int{3, 2, 10, 1},
+			expectedFiltered: 4,
+		},
+	}
+	for _, tt := range tests {
+		tt.di.Columns = append([]ColData{}, columns...)
+		if tt.regex != "" {
+			tt.di.Columns[tt.regexCol].RawSearchval = tt.regex
+			tt.di.Columns[tt.regexCol].UseRegex = true
+		}
+		page, total, filtered, err := tt.di.ApplyToSlice(testRecords, nil)
+		if err != nil {
+			t.Fatalf("%s: Error: %v", tt.name, err)
+		}
+		if total != len(testRecords) || filtered != tt.expectedFiltered {
+			t.Errorf("%s: expected total %d filtered %d got %d %d", tt.name, len(testRecords), tt.expectedFiltered, total, filtered)
+		}
+		if ids := recordIDs(page); !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("%s: expected ids %v got %v", tt.name, tt.expectedIDs, ids)
+		}
+	}
+}
+
+func TestApplyToSliceAccessors(t *testing.T) {
+	di := DataTablesInfo{
+		Length:  10,
+		Columns: []ColData{{Data: "domain", Searchable: true, Orderable: true, RawSearchval: "org"}},
+	}
+	accessors := map[string]Accessor{
+		"domain": func(record interface{}) interface{} {
+			if c := record.(testRecord).Contact; c != nil {
+				return c.Email[strings.Index(c.Email, "@")+1:]
+			}
+			return nil
+		},
+	}
+	page, _, filtered, err := di.ApplyToSlice(testRecords, accessors)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ids := recordIDs(page); filtered != 1 || !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("unexpected result %v filtered %d", ids, filtered)
+	}
+
+	// a nil pointer is empty too
+	di.Columns[0].RawSearchval = "nil"
+	accessors["domain"] = func(record interface{}) interface{} {
+		var domain *string
+		return domain
+	}
+	if page, _, filtered, err = di.ApplyToSlice(testRecords, accessors); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if ids := recordIDs(page); filtered != 0 {
+		t.Errorf("unexpected result %v filtered %d", ids, filtered)
+	}
+
+	di.Columns[0].Data = "unknown"
+	if _, _, _, err = di.ApplyToSlice(testRecords, nil); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
@@ -0,0 +1,110 @@
+package datatablessrv
+
+import (
+	"database/sql"
+)
+
+// SQLSource is a DataSource for a single table of a database/sql database.
+// The records are returned as maps keyed by the columns[i][data] of the request,
+// which is what DataTables expects when columns.data is set.
+type SQLSource struct {
+	// DB is the database to run the queries against
+	DB *sql.DB
+	// Dialect of the database
+	Dialect Dialect
+	// Table to select from
+	Table string
+	// FieldMap maps columns[i][data] to the column names of Table, only these can be queried
+	FieldMap map[string]string
+	// Tiebreaker is the columns[i][data] (in FieldMap) of a unique column. When it is set the pages are
+	// fetched with BuildKeysetQuery instead of BuildQuery and the records also hold the tiebreaker.
+	Tiebreaker string
+}
+
+// Count runs the recordsTotal and recordsFiltered count queries
+func (s *SQLSource) Count(di *DataTablesInfo) (total int, filtered int, err error) {
+	query, args, err := di.BuildCountQuery(s.Dialect, s.Table, s.FieldMap, false)
+	if err != nil {
+		return
+	}
+	if err = s.DB.QueryRow(query, args...).Scan(&total); err != nil {
+		return
+	}
+	query, args, err = di.BuildCountQuery(s.Dialect, s.Table, s.FieldMap, true)
+	if err != nil {
+		return
+	}
+	err = s.DB.QueryRow(query, args...).Scan(&filtered)
+	return
+}
+
+// Page runs the query generated by BuildQuery (or BuildKeysetQuery) and returns the rows as a []map[string]interface{}
+func (s *SQLSource) Page(di *DataTablesInfo) (data interface{}, err error) {
+	keys := make([]string, 0, len(di.Columns)+1)
+	for _, colData := range di.Columns {
+		keys = append(keys, colData.Data)
+	}
+	var query string
+	var args []interface{}
+	if s.Tiebreaker != "" {
+		query, args, err = di.BuildKeysetQuery(s.Dialect, s.Table, s.FieldMap, s.Tiebreaker)
+		if di.column(s.Tiebreaker) == nil {
+			keys = append(keys, s.Tiebreaker)
+		}
+	} else {
+		query, args, err = di.BuildQuery(s.Dialect, s.Table, s.FieldMap)
+	}
+	if err != nil {
+		return
+	}
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	records := []map[string]interface{}{}
+	for rows.Next() {
+		var record map[string]interface{}
+		record, err = scanRecord(rows, keys)
+		if err != nil {
+			return
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	data = records
+	return
+}
+
+// scanRecord scans the current row, which has one value per key
+func scanRecord(rows *sql.Rows, keys []string) (record map[string]interface{}, err error) {
+	values := make([]interface{}, len(keys))
+	dest := make([]interface{}, len(keys))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return
+	}
+	record = make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		// some drivers (mySQL) return text as []byte, which would end up base64 encoded in the JSON
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		record[key] = values[i]
+	}
+	return
+}
+
+// NextCursor returns the keyset cursor for the page after data, which has to be a full page.
+// It is always empty when Tiebreaker is not set.
+func (s *SQLSource) NextCursor(di *DataTablesInfo, data interface{}) (cursor string, err error) {
+	records, _ := data.([]map[string]interface{})
+	if s.Tiebreaker == "" || len(records) == 0 || len(records) < di.Length || di.Length < 0 {
+		return
+	}
+	return di.NextCursor(records[len(records)-1], s.Tiebreaker)
+}
@@ -0,0 +1,156 @@
+package datatablessrv
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// personFieldMap maps the columns[i][data] of the recorded requests to the person table
+var personFieldMap = map[string]string{
+	"id":     "id",
+	"first":  "first_name",
+	"last":   "last_name",
+	"age":    "age",
+	"city":   "city",
+	"joined": "joined",
+}
+
+// openPersonDB returns an in-memory SQLite database with a small person table
+func openPersonDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	// every connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	stmts := []string{
+		`CREATE TABLE person (id INTEGER PRIMARY KEY, first_name TEXT, last_name TEXT, age INTEGER, city TEXT, joined DATETIME)`,
+		`INSERT INTO person VALUES (1, 'John', 'Smith', 34, 'Toronto', '2018-01-15 00:00:00+00:00')`,
+		`INSERT INTO person VALUES (2, 'Anna', 'Jones', 28, 'Ottawa', '2018-02-01 00:00:00+00:00')`,
+		`INSERT INTO person VALUES (3, 'Joanne', 'Tran', 45, 'Toronto', '2018-02-28 00:00:00+00:00')`,
+		`INSERT INTO person VALUES (4, 'Brian', 'Kane', 51, 'Montreal', '2018-03-01 00:00:00+00:00')`,
+		`INSERT INTO person VALUES (5, 'Stefan', 'Olsen', 19, 'Vancouver', '2018-03-31 00:00:00+00:00')`,
+		`INSERT INTO person VALUES (6, 'Johan', 'Meyer', 62, 'Toronto', '2018-05-20 00:00:00+00:00')`,
+	}
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal("Error:", err)
+		}
+	}
+	return db
+}
+
+// recordedRequest replays a DataTables AJAX request that was captured from the browser
+func recordedRequest(t *testing.T, name string) *http.Request {
+	raw, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	r, err := http.NewRequest("GET", "/data?"+strings.TrimSpace(string(raw)), nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	return r
+}
+
+// queryIDs runs the query and returns the id (first column) of every row
+func queryIDs(t *testing.T, db *sql.DB, query string, args []interface{}) (ids []int) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("Error running %s: %v", query, err)
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	for rows.Next() {
+		var id int
+		dest := make([]interface{}, len(cols))
+		dest[0] = &id
+		for i := 1; i < len(dest); i++ {
+			dest[i] = new(interface{})
+		}
+		if err = rows.Scan(dest...); err != nil {
+			t.Fatal("Error:", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal("Error:", err)
+	}
+	return
+}
+
+// TestSQLiteRecordedRequests goes all the way from the HTTP request to the rows in the database.
+// Apart from the search operators, the structure of the generated query (global search OR'ed over the
+// columns, AND'ed with the column searches, ordering and paging) is the same for every Dialect.
+func TestSQLiteRecordedRequests(t *testing.T) {
+	db := openPersonDB(t)
+	defer db.Close()
+
+	tests := []struct {
+		file        string
+		draw        int
+		expectedIDs []int
+	}{
+		// order by age desc, first page of 3
+		{"request_page.txt", 1, []int{6, 4, 3}},
+		// global search "an" on every searchable column, order by last, first
+		{"request_global_search.txt", 2, []int{2, 4, 6, 5, 3}},
+		// first name regex ^Jo (GLOB) and city containing Tor, order by first
+		{"request_column_regex.txt", 3, []int{3, 6, 1}},
+		// length -1, order by city then id desc
+		{"request_all.txt", 4, []int{4, 2, 6, 3, 1, 5}},
+		// search values trying to break out of the quotes match nothing
+		{"request_injection.txt", 5, nil},
+	}
+	for _, tt := range tests {
+		di, err := ParseDatatablesRequest(recordedRequest(t, tt.file))
+		if err != nil {
+			t.Fatalf("%s: Error: %v", tt.file, err)
+		}
+		if di.Draw != tt.draw {
+			t.Errorf("%s: expected draw %d got %d", tt.file, tt.draw, di.Draw)
+		}
+		query, args, err := di.BuildQuery(SQLite, "person", personFieldMap)
+		if err != nil {
+			t.Fatalf("%s: Error: %v", tt.file, err)
+		}
+		ids := queryIDs(t, db, query, args)
+		if !reflect.DeepEqual(ids, tt.expectedIDs) {
+			t.Errorf("%s: expected ids %v got %v\nquery: %s\nargs: %#v", tt.file, tt.expectedIDs, ids, query, args)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM person`).Scan(&count); err != nil || count != 6 {
+		t.Errorf("person table was modified: count %d err %v", count, err)
+	}
+}
+
+func TestSQLiteGlob(t *testing.T) {
+	tests := []struct {
+		regex string
+		glob  string
+		ok    bool
+	}{
+		{"^Jo", "Jo*", true},
+		{"son$", "*son", true},
+		{"^J.n.*$", "J?n*", true},
+		{"[a-c]x\\.", "*[a-c]x.*", true},
+		{"a\\*b", "*a[*]b*", true},
+		{"^(John|Anna)$", "", false},
+		{"ab+", "", false},
+		{"[abc", "", false},
+	}
+	for _, tt := range tests {
+		glob, ok := SQLiteGlob(tt.regex)
+		if ok != tt.ok || glob != tt.glob {
+			t.Errorf("SQLiteGlob(%q) = %q, %v want %q, %v", tt.regex, glob, ok, tt.glob, tt.ok)
+		}
+	}
+}
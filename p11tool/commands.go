@@ -0,0 +1,1583 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+
+	pw "github.com/gbolo/go-util/p11tool/pkcs11wrapper"
+)
+
+// builds a search template from the object selection flags
+func objectTemplate(class string, label string, id string) (template []*pkcs11.Attribute, err error) {
+
+	switch class {
+	case "":
+	case "private":
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY))
+	case "public":
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY))
+	case "secret":
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY))
+	case "cert":
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE))
+	case "data":
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA))
+	default:
+		err = fmt.Errorf("unsupported object class: %s (private,public,secret,cert,data)", class)
+		return
+	}
+
+	if label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+
+	if id != "" {
+		idBytes, errHex := hex.DecodeString(id)
+		if errHex != nil {
+			err = fmt.Errorf("invalid hex id %s: %v", id, errHex)
+			return
+		}
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, idBytes))
+	}
+
+	return
+}
+
+// finds the single object of class matching label and/or id
+func findKey(class string, label string, id string) (object pkcs11.ObjectHandle, err error) {
+
+	if label == "" && id == "" {
+		err = errors.New("a label or an id is required to select the object")
+		return
+	}
+
+	template, err := objectTemplate(class, label, id)
+	if err != nil {
+		return
+	}
+
+	objects, _, err := p11w.FindObjects(template, 2)
+	if err != nil {
+		return
+	}
+
+	switch len(objects) {
+	case 0:
+		err = fmt.Errorf("no %s object found with label '%s' and id '%s'", class, label, id)
+	case 1:
+		object = objects[0]
+	default:
+		err = fmt.Errorf("more than one %s object found with label '%s' and id '%s'", class, label, id)
+	}
+
+	return
+}
+
+// reads the message to sign or verify from the flags
+func readMessage(message string, in string) (data []byte, err error) {
+
+	if in != "" {
+		data, err = ioutil.ReadFile(in)
+		return
+	}
+
+	data = []byte(message)
+	return
+}
+
+func cmdList(args []string) (err error) {
+
+	fs, output := newFlagSet("list")
+	hsm := addHSMFlags(fs)
+	sel := addSelectFlags(fs, "list")
+	max := fs.Int("max", 50, "Maximum number of objects to list")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	template, err := objectTemplate(*sel.class, *sel.label, *sel.id)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	objects, err := p11w.GetObjects(template, *max)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputText {
+		pw.PrintObjects(objects, *max)
+		return
+	}
+
+	if objects == nil {
+		objects = []pw.Pkcs11Object{}
+	}
+	printJSON(objects)
+
+	return
+}
+
+// environment variable holding the passphrase of encrypted key files, when -passFile is not set
+const passphraseEnv = "P11TOOL_KEY_PASSPHRASE"
+
+// loads a key file of any supported encoding, keyType (EC,RSA,Ed25519) is detected when empty
+func loadKey(keyFile string, keyType string, passFile string) (key pw.Key, err error) {
+
+	passphrase := []byte(os.Getenv(passphraseEnv))
+	if passFile != "" {
+		if passphrase, err = ioutil.ReadFile(passFile); err != nil {
+			return
+		}
+		passphrase = []byte(strings.TrimRight(string(passphrase), "\r\n"))
+	}
+
+	key, err = pw.LoadKeyFile(keyFile, passphrase)
+	if err != nil {
+		return
+	}
+
+	if keyType != "" && keyType != key.KeyType() {
+		err = fmt.Errorf("%s holds an %s key, not %s", keyFile, key.KeyType(), keyType)
+	}
+
+	return
+}
+
+// imports a key pair, or a public key, with opts, returns its CKA_ID
+func importKey(key pw.Key, opts pw.ImportOptions) (id []byte, err error) {
+
+	// only the public key object, to verify or wrap for another HSM
+	if key.Private == nil {
+		id, err = p11w.ImportPublicKey(key.Public, opts)
+		return
+	}
+
+	switch key.KeyType() {
+
+	case "RSA":
+		rsaKey, errKey := key.RsaKey()
+		if errKey != nil {
+			err = errKey
+			return
+		}
+		id, err = p11w.ImportRSAKeyWithOptions(rsaKey, opts)
+
+	case "EC":
+		ecKey, errKey := key.EcdsaKey()
+		if errKey != nil {
+			err = errKey
+			return
+		}
+		id, err = p11w.ImportECKeyWithOptions(ecKey, opts)
+
+	case "Ed25519":
+		edKey, errKey := key.Ed25519Key()
+		if errKey != nil {
+			err = errKey
+			return
+		}
+		id, err = p11w.ImportEd25519KeyWithOptions(edKey, opts)
+
+	default:
+		err = fmt.Errorf("unsupported key type: %s (EC,RSA,Ed25519)", key.KeyType())
+	}
+
+	return
+}
+
+func cmdImport(args []string) (err error) {
+
+	fs, output := newFlagSet("import")
+	hsm := addHSMFlags(fs)
+	imp := addImportFlags(fs, pw.DefaultECImportOptions.Extractable, pw.DefaultECImportOptions.Sensitive)
+	keyFile := fs.String("keyFile", "/some/dir/key.pem", "path to key (PKCS#8, SEC1 or PKCS#1, PEM or DER) you want to import, a public key or certificate imports only the public key")
+	keyType := fs.String("keyType", "", "Type of key (EC,RSA,Ed25519), detected when not set")
+	passFile := fs.String("passFile", "", "File holding the passphrase of an encrypted key, default $"+passphraseEnv)
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	key, err := loadKey(*keyFile, *keyType, *passFile)
+	if err != nil {
+		return
+	}
+
+	opts, err := importOptions(fs, imp, key.KeyType())
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	id, err := importKey(key, opts)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"keyType": key.KeyType(), "keyFile": *keyFile, "label": opts.Label, "id": hex.EncodeToString(id)})
+	} else {
+		fmt.Printf("Key was imported with CKA_ID:%x\n", id)
+	}
+
+	return
+}
+
+func cmdGenerate(args []string) (err error) {
+
+	fs, output := newFlagSet("generate")
+	hsm := addHSMFlags(fs)
+	generated := pw.DefaultGenerateOptions(pw.ImportOptions{})
+	imp := addImportFlags(fs, generated.Extractable, generated.Sensitive)
+	keyType := fs.String("keyType", "EC", "Type of key (EC,RSA,Ed25519,AES,GENERIC)")
+	curve := fs.String("curve", "P-256", "Curve of EC key (P-224,P-256,P-384,P-521,secp256k1)")
+	bits := fs.Int("bits", 2048, "Size of RSA key, AES and GENERIC secret keys are 256 bits unless set")
+	software := fs.Bool("software", false, "Generate the key in memory and import it, for tokens which can't generate it")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	bitsSet := false
+	fs.Visit(func(f *flag.Flag) { bitsSet = bitsSet || f.Name == "bits" })
+	if !bitsSet && (*keyType == "AES" || *keyType == "GENERIC") {
+		*bits = 256
+	}
+
+	if !*software {
+		err = generateOnDevice(fs, hsm, imp, *keyType, *curve, *bits)
+		return
+	}
+
+	// sensitive and not extractable like the keys generated in the HSM
+	opts, err := generateOptions(fs, imp, *keyType)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	var id []byte
+
+	switch *keyType {
+
+	case "RSA":
+		key := pw.RsaKey{}
+		if err = key.Generate(*bits); err != nil {
+			return
+		}
+		id, err = p11w.ImportRSAKeyWithOptions(key, opts)
+
+	case "EC":
+		key := pw.EcdsaKey{}
+		if err = key.Generate(*curve); err != nil {
+			return
+		}
+		id, err = p11w.ImportECKeyWithOptions(key, opts)
+
+	case "Ed25519":
+		key := pw.Ed25519Key{}
+		if err = key.Generate(); err != nil {
+			return
+		}
+		id, err = p11w.ImportEd25519KeyWithOptions(key, opts)
+
+	case "AES", "GENERIC":
+		secretType, _ := pw.ParseSecretKeyType(*keyType)
+		value := make([]byte, *bits/8)
+		if _, err = rand.Read(value); err != nil {
+			return
+		}
+		id, err = p11w.ImportSecretKey(secretType, value, opts)
+	}
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"keyType": *keyType, "label": opts.Label, "id": hex.EncodeToString(id)})
+	} else {
+		fmt.Printf("Key was generated and imported with CKA_ID:%x\n", id)
+	}
+
+	return
+}
+
+// generates the key pair in the HSM, with the CKA_ID computed from its public key
+func generateOnDevice(fs *flag.FlagSet, hsm hsmFlags, imp importFlags, keyType string, curve string, bits int) (err error) {
+
+	opts, err := generateOptions(fs, imp, keyType)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	var id []byte
+
+	switch keyType {
+	case "RSA":
+		id, err = p11w.GenerateRSAKeyPair(bits, opts)
+	case "EC":
+		id, err = p11w.GenerateECKeyPair(curve, opts)
+	case "Ed25519":
+		id, err = p11w.GenerateEd25519KeyPair(opts)
+	case "AES", "GENERIC":
+		secretType, _ := pw.ParseSecretKeyType(keyType)
+		id, err = p11w.GenerateSecretKey(secretType, bits, opts)
+	}
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"keyType": keyType, "label": opts.Label, "id": hex.EncodeToString(id)})
+	} else {
+		fmt.Printf("Key was generated in the HSM with CKA_ID:%x\n", id)
+	}
+
+	return
+}
+
+func cmdSKI(args []string) (err error) {
+
+	fs, output := newFlagSet("ski")
+	keyFile := fs.String("keyFile", "/some/dir/key.pem", "path to key, public key or certificate you want the SKI of")
+	keyType := fs.String("keyType", "", "Type of key (EC,RSA,Ed25519), detected when not set")
+	passFile := fs.String("passFile", "", "File holding the passphrase of an encrypted key, default $"+passphraseEnv)
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	key, err := loadKey(*keyFile, *keyType, *passFile)
+	if err != nil {
+		return
+	}
+
+	ski, err := pw.GenSKIFromPublicKey(key.Public)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"keyType": key.KeyType(), "format": key.Format, "sha256": ski.Sha256, "sha1": ski.Sha1})
+	} else {
+		fmt.Printf("SKI(sha256): %s\n", ski.Sha256)
+	}
+
+	return
+}
+
+func cmdSign(args []string) (err error) {
+
+	fs, output := newFlagSet("sign")
+	hsm := addHSMFlags(fs)
+	label := fs.String("label", "", "CKA_LABEL of the private key")
+	id := fs.String("id", "", "CKA_ID of the private key (hex)")
+	message := fs.String("message", "", "Message to sign")
+	in := fs.String("in", "", "File to sign, instead of -message")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	data, err := readMessage(*message, *in)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	key, err := findKey("private", *label, *id)
+	if err != nil {
+		return
+	}
+
+	keyType, err := p11w.GetKeyType(key)
+	if err != nil {
+		return
+	}
+
+	var signature string
+
+	switch keyType {
+	case pkcs11.CKK_EC:
+		signature, err = p11w.SignMessage(string(data), key)
+	case pkcs11.CKK_RSA:
+		signature, err = p11w.SignMessageAdvanced(data, key, pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil))
+	case pw.CKK_EC_EDWARDS:
+		signature, err = p11w.SignMessageAdvanced(data, key, pkcs11.NewMechanism(pw.CKM_EDDSA, nil))
+	default:
+		err = fmt.Errorf("unsupported key type: %d", keyType)
+	}
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"signature": signature})
+	} else {
+		fmt.Println(signature)
+	}
+
+	return
+}
+
+func cmdVerify(args []string) (err error) {
+
+	fs, output := newFlagSet("verify")
+	hsm := addHSMFlags(fs)
+	label := fs.String("label", "", "CKA_LABEL of the public key")
+	id := fs.String("id", "", "CKA_ID of the public key (hex)")
+	message := fs.String("message", "", "Message that was signed")
+	in := fs.String("in", "", "File that was signed, instead of -message")
+	signature := fs.String("signature", "", "Signature to verify (hex), as output by sign")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	data, err := readMessage(*message, *in)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	key, err := findKey("public", *label, *id)
+	if err != nil {
+		return
+	}
+
+	keyType, err := p11w.GetKeyType(key)
+	if err != nil {
+		return
+	}
+
+	var verified bool
+
+	switch keyType {
+	case pkcs11.CKK_EC:
+		verified, err = p11w.VerifySignature(string(data), *signature, key)
+	case pkcs11.CKK_RSA:
+		verified, err = p11w.VerifySignatureAdvanced(data, *signature, key, pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil))
+	case pw.CKK_EC_EDWARDS:
+		verified, err = p11w.VerifySignatureAdvanced(data, *signature, key, pkcs11.NewMechanism(pw.CKM_EDDSA, nil))
+	default:
+		err = fmt.Errorf("unsupported key type: %d", keyType)
+	}
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]bool{"verified": verified})
+	} else {
+		fmt.Println("Verified:", verified)
+	}
+
+	if !verified {
+		exitCode = 1
+	}
+
+	return
+}
+
+// returns the template of the objects selected by a command that changes them,
+// one of label or id is required so a command never acts on the whole slot
+func selectedTemplate(sel selectFlags) (template []*pkcs11.Attribute, err error) {
+
+	if *sel.label == "" && *sel.id == "" {
+		err = errors.New("a label or an id is required to select the objects")
+		return
+	}
+
+	template, err = objectTemplate(*sel.class, *sel.label, *sel.id)
+	return
+}
+
+// returns the attributes setting the new CKA_LABEL and/or CKA_ID
+func newAttributes(label string, id string) (attrs []*pkcs11.Attribute, err error) {
+
+	if label != "" {
+		attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+
+	if id != "" {
+		idBytes, errHex := hex.DecodeString(id)
+		if errHex != nil {
+			err = fmt.Errorf("invalid hex id %s: %v", id, errHex)
+			return
+		}
+		attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_ID, idBytes))
+	}
+
+	return
+}
+
+// prints the objects affected by a command, or the ones which would be with dryRun
+func printAffected(verb string, dryRun bool, objects []pw.Pkcs11Object) {
+
+	if outputFormat == outputJSON {
+		if objects == nil {
+			objects = []pw.Pkcs11Object{}
+		}
+		printJSON(map[string]interface{}{"dryRun": dryRun, verb: objects})
+		return
+	}
+
+	if len(objects) == 0 {
+		fmt.Println("No objects found")
+		return
+	}
+
+	prefix := strings.ToUpper(verb[:1]) + verb[1:]
+	if dryRun {
+		prefix = "Would be " + verb
+	}
+	for _, o := range objects {
+		fmt.Printf("%s %s CKA_LABEL:%s CKA_ID:%s\n", prefix, o.CKA_CLASS, o.CKA_LABEL, o.CKA_ID)
+	}
+}
+
+func cmdDelete(args []string) (err error) {
+
+	fs, output := newFlagSet("delete")
+	hsm := addHSMFlags(fs)
+	sel := addSelectFlags(fs, "delete")
+	dryRun := fs.Bool("dryRun", false, "Only list the objects which would be deleted")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	template, err := selectedTemplate(sel)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	var objects []pw.Pkcs11Object
+	if *dryRun {
+		objects, err = p11w.GetObjects(template, pw.MaxObjects)
+	} else {
+		objects, err = p11w.DestroyObjects(template)
+	}
+	if err != nil {
+		// the objects destroyed before the failure are gone all the same
+		if len(objects) > 0 {
+			printAffected("deleted", *dryRun, objects)
+		}
+		return
+	}
+
+	printAffected("deleted", *dryRun, objects)
+
+	return
+}
+
+func cmdRename(args []string) (err error) {
+
+	fs, output := newFlagSet("rename")
+	hsm := addHSMFlags(fs)
+	sel := addSelectFlags(fs, "rename")
+	newLabel := fs.String("newLabel", "", "New CKA_LABEL of the objects")
+	newID := fs.String("newId", "", "New CKA_ID of the objects (hex)")
+	dryRun := fs.Bool("dryRun", false, "Only list the objects which would be renamed")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	template, err := selectedTemplate(sel)
+	if err != nil {
+		return
+	}
+
+	attrs, err := newAttributes(*newLabel, *newID)
+	if err != nil {
+		return
+	}
+	if len(attrs) == 0 {
+		err = errors.New("-newLabel and/or -newId is required")
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	objects, err := p11w.GetObjects(template, pw.MaxObjects)
+	if err != nil {
+		return
+	}
+
+	if !*dryRun {
+		var done []pw.Pkcs11Object
+		for _, o := range objects {
+			if err = p11w.SetAttributes(o.ObjectHandle, attrs); err != nil {
+				// the objects renamed before the failure stay so
+				if len(done) > 0 {
+					printAffected("renamed", false, done)
+				}
+				return
+			}
+			done = append(done, o)
+		}
+	}
+
+	printAffected("renamed", *dryRun, objects)
+
+	return
+}
+
+func cmdCopy(args []string) (err error) {
+
+	fs, output := newFlagSet("copy")
+	hsm := addHSMFlags(fs)
+	sel := addSelectFlags(fs, "copy")
+	newLabel := fs.String("newLabel", "", "CKA_LABEL of the copies")
+	newID := fs.String("newId", "", "CKA_ID of the copies (hex)")
+	session := fs.Bool("session", false, "Create the copies as session objects")
+	dryRun := fs.Bool("dryRun", false, "Only list the objects which would be copied")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	template, err := selectedTemplate(sel)
+	if err != nil {
+		return
+	}
+
+	attrs, err := newAttributes(*newLabel, *newID)
+	if err != nil {
+		return
+	}
+	if *session {
+		attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false))
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	objects, err := p11w.GetObjects(template, pw.MaxObjects)
+	if err != nil {
+		return
+	}
+
+	if !*dryRun {
+		var done []pw.Pkcs11Object
+		for _, o := range objects {
+			if _, err = p11w.CopyObject(o.ObjectHandle, attrs); err != nil {
+				// the objects copied before the failure stay so
+				if len(done) > 0 {
+					printAffected("copied", false, done)
+				}
+				return
+			}
+			done = append(done, o)
+		}
+	}
+
+	printAffected("copied", *dryRun, objects)
+
+	return
+}
+
+// writes an exported key or certificate to file, or stdout when file is empty
+func writeExport(exported []byte, format pw.KeyFormat, file string) (err error) {
+
+	if file != "" {
+		if err = ioutil.WriteFile(file, exported, 0644); err != nil {
+			return
+		}
+	}
+
+	switch {
+	case outputFormat == outputJSON:
+		printJSON(map[string]string{"format": string(format), "data": string(exported), "file": file})
+	case file == "":
+		os.Stdout.Write(exported)
+	default:
+		fmt.Printf("Exported to %s\n", file)
+	}
+
+	return
+}
+
+func cmdExportPub(args []string) (err error) {
+
+	fs, output := newFlagSet("export-pub")
+	hsm := addHSMFlags(fs)
+	label := fs.String("label", "", "CKA_LABEL of the public key")
+	id := fs.String("id", "", "CKA_ID of the public key (hex)")
+	format := fs.String("format", "pem", "Export format (pem,jwk,ssh)")
+	out := fs.String("out", "", "Write the key to this file instead of stdout")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	keyFormat, err := pw.ParseKeyFormat(*format)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	key, err := findKey("public", *label, *id)
+	if err != nil {
+		return
+	}
+
+	exported, err := p11w.ExportPublicKey(key, keyFormat)
+	if err != nil {
+		return
+	}
+
+	err = writeExport(exported, keyFormat, *out)
+
+	return
+}
+
+func cmdExportCert(args []string) (err error) {
+
+	fs, output := newFlagSet("export-cert")
+	hsm := addHSMFlags(fs)
+	label := fs.String("label", "", "CKA_LABEL of the certificate")
+	id := fs.String("id", "", "CKA_ID of the certificate (hex)")
+	format := fs.String("format", "pem", "Export format (pem,jwk,ssh), jwk and ssh export the public key of the certificate")
+	out := fs.String("out", "", "Write the certificate to this file instead of stdout")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	keyFormat, err := pw.ParseKeyFormat(*format)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	cert, err := findKey("cert", *label, *id)
+	if err != nil {
+		return
+	}
+
+	exported, err := p11w.ExportCertificate(cert, keyFormat)
+	if err != nil {
+		return
+	}
+
+	err = writeExport(exported, keyFormat, *out)
+
+	return
+}
+
+// parses a subject like "CN=peer0.org1.example.com,OU=peer,O=Org1,C=US", a multi-valued RDN
+// joins its attributes with + and a backslash escapes the next character, like "O=Org1\, Inc."
+func parseSubject(subject string) (name pkix.Name, err error) {
+
+	attributes, err := splitSubject(subject)
+	if err != nil {
+		return
+	}
+
+	for _, kv := range attributes {
+		value := kv[1]
+		switch strings.ToUpper(strings.TrimSpace(kv[0])) {
+		case "CN":
+			name.CommonName = value
+		case "SERIALNUMBER":
+			name.SerialNumber = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		case "POSTALCODE":
+			name.PostalCode = append(name.PostalCode, value)
+		default:
+			err = fmt.Errorf("unsupported subject attribute: %s (CN,O,OU,C,ST,L,STREET,POSTALCODE,SERIALNUMBER)", kv[0])
+			return
+		}
+	}
+
+	return
+}
+
+// splits a subject into its type and value pairs at the unescaped , and +
+func splitSubject(subject string) (attributes [][2]string, err error) {
+
+	var current strings.Builder
+	var typ string
+	hasType := false
+
+	flush := func() error {
+		value := strings.TrimSpace(current.String())
+		current.Reset()
+		if !hasType {
+			if value != "" {
+				return fmt.Errorf("invalid subject attribute: %s", value)
+			}
+			return nil
+		}
+		attributes = append(attributes, [2]string{typ, value})
+		hasType = false
+		return nil
+	}
+
+	for i := 0; i < len(subject); i++ {
+		switch c := subject[i]; {
+		case c == '\\':
+			if i++; i == len(subject) {
+				err = fmt.Errorf("invalid subject, trailing backslash: %s", subject)
+				return
+			}
+			current.WriteByte(subject[i])
+		case c == '=' && !hasType:
+			typ = strings.TrimSpace(current.String())
+			current.Reset()
+			hasType = true
+		case c == ',' || c == '+':
+			if err = flush(); err != nil {
+				return
+			}
+		default:
+			current.WriteByte(c)
+		}
+	}
+	err = flush()
+
+	return
+}
+
+// adds comma separated SANs to template, IPs, emails and URIs are recognized, the rest are DNS names
+func addSANs(template *x509.CertificateRequest, sans string) (err error) {
+
+	for _, san := range strings.Split(sans, ",") {
+		san = strings.TrimSpace(san)
+		switch {
+		case san == "":
+		case net.ParseIP(san) != nil:
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(san))
+		case strings.Contains(san, "://"):
+			uri, errParse := url.Parse(san)
+			if errParse != nil {
+				err = fmt.Errorf("invalid URI SAN %s: %v", san, errParse)
+				return
+			}
+			template.URIs = append(template.URIs, uri)
+		case strings.Contains(san, "@"):
+			template.EmailAddresses = append(template.EmailAddresses, san)
+		default:
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+
+	return
+}
+
+func cmdCSR(args []string) (err error) {
+
+	fs, output := newFlagSet("csr")
+	hsm := addHSMFlags(fs)
+	label := fs.String("label", "", "CKA_LABEL of the private key")
+	id := fs.String("id", "", "CKA_ID of the private key (hex)")
+	subject := fs.String("subject", "", "Subject of the CSR, like CN=peer0.org1.example.com,OU=peer,O=Org1,C=US")
+	sans := fs.String("san", "", "Comma separated SANs (DNS names, IPs, emails and URIs)")
+	out := fs.String("out", "", "Write the CSR to this file instead of stdout")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	name, err := parseSubject(*subject)
+	if err != nil {
+		return
+	}
+	template := &x509.CertificateRequest{Subject: name}
+	if err = addSANs(template, *sans); err != nil {
+		return
+	}
+
+	idBytes, err := hex.DecodeString(*id)
+	if err != nil {
+		err = fmt.Errorf("invalid hex id %s: %v", *id, err)
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	signer, err := p11w.NewSigner(*label, idBytes)
+	if err != nil {
+		return
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
+	if err != nil {
+		return
+	}
+	csrPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	if *out != "" {
+		if err = ioutil.WriteFile(*out, csrPem, 0644); err != nil {
+			return
+		}
+	}
+
+	switch {
+	case outputFormat == outputJSON:
+		printJSON(map[string]string{"csr": string(csrPem), "subject": name.String(), "file": *out})
+	case *out == "":
+		os.Stdout.Write(csrPem)
+	default:
+		fmt.Printf("CSR for %s written to %s\n", name.String(), *out)
+	}
+
+	return
+}
+
+func cmdImportCert(args []string) (err error) {
+
+	fs, output := newFlagSet("import-cert")
+	hsm := addHSMFlags(fs)
+	certFile := fs.String("certFile", "/some/dir/cert.pem", "path to the certificate (PEM or DER) you want to import")
+	label := fs.String("label", "", "CKA_LABEL of the certificate (default the subject CN)")
+	idType := fs.String("idType", "sha256", "How CKA_ID is set (sha256,sha1,explicit), sha256 and sha1 match the key imported with the same -idType")
+	id := fs.String("id", "", "CKA_ID (hex) when -idType is explicit")
+	session := fs.Bool("session", false, "Import as a session object instead of a token object")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	cert, err := pw.ReadCertificateFile(*certFile)
+	if err != nil {
+		return
+	}
+
+	opts := pw.ImportOptions{Label: *label, Session: *session}
+	if opts.Label == "" {
+		opts.Label = cert.Subject.CommonName
+	}
+	if err = setIDOptions(&opts, *idType, *id); err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	ckaID, err := p11w.ImportCertificate(cert, opts)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"subject": cert.Subject.String(), "label": opts.Label, "id": hex.EncodeToString(ckaID)})
+	} else {
+		fmt.Printf("Certificate %s was imported with CKA_ID:%x\n", cert.Subject.String(), ckaID)
+	}
+
+	return
+}
+
+// returns the name of class for findKey
+func className(class uint) string {
+	switch class {
+	case pkcs11.CKO_PRIVATE_KEY:
+		return "private"
+	case pkcs11.CKO_PUBLIC_KEY:
+		return "public"
+	case pkcs11.CKO_SECRET_KEY:
+		return "secret"
+	}
+	return fmt.Sprintf("%d", class)
+}
+
+func cmdWrap(args []string) (err error) {
+
+	fs, output := newFlagSet("wrap")
+	hsm := addHSMFlags(fs)
+	class := fs.String("class", "private", "Class of the key to wrap (private,secret)")
+	label := fs.String("label", "", "CKA_LABEL of the key to wrap")
+	id := fs.String("id", "", "CKA_ID of the key to wrap (hex)")
+	wrappingLabel := fs.String("wrappingLabel", "", "CKA_LABEL of the wrapping key, an AES key or an RSA public key")
+	wrappingID := fs.String("wrappingId", "", "CKA_ID of the wrapping key (hex)")
+	mechanism := fs.String("mechanism", pw.WrapAESKeyWrapPad, "Wrapping mechanism ("+pw.WrapAESKeyWrapPad+","+pw.WrapRSAOAEP+","+pw.WrapRSAOAEPSHA256+")")
+	out := fs.String("out", "", "Write the wrapped key to this file instead of stdout")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	_, wrappingClass, err := pw.WrapMechanism(*mechanism)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	key, err := findKey(*class, *label, *id)
+	if err != nil {
+		return
+	}
+
+	wrappingKey, err := findKey(className(wrappingClass), *wrappingLabel, *wrappingID)
+	if err != nil {
+		return
+	}
+
+	wrapped, err := p11w.WrapKeyObject(*mechanism, wrappingKey, key)
+	if err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(wrapped, "", "  ")
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	// the wrapped key is JSON already, -output only changes what is printed with -out
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err = ioutil.WriteFile(*out, data, 0600); err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"file": *out, "label": wrapped.Label, "id": hex.EncodeToString(wrapped.ID)})
+	} else {
+		fmt.Printf("Key CKA_LABEL:%s CKA_ID:%x was wrapped to %s\n", wrapped.Label, wrapped.ID, *out)
+	}
+
+	return
+}
+
+func cmdUnwrap(args []string) (err error) {
+
+	fs, output := newFlagSet("unwrap")
+	hsm := addHSMFlags(fs)
+	imp := addImportFlags(fs, true, true)
+	in := fs.String("in", "", "File holding the key written by wrap")
+	unwrappingLabel := fs.String("unwrappingLabel", "", "CKA_LABEL of the unwrapping key, an AES key or an RSA private key")
+	unwrappingID := fs.String("unwrappingId", "", "CKA_ID of the unwrapping key (hex)")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		return
+	}
+	var wrapped pw.WrappedKey
+	if err = json.Unmarshal(data, &wrapped); err != nil {
+		err = fmt.Errorf("%s is not a wrapped key: %v", *in, err)
+		return
+	}
+
+	_, wrappingClass, err := pw.WrapMechanism(wrapped.Mechanism)
+	if err != nil {
+		return
+	}
+	unwrappingClass := "secret"
+	if wrappingClass == pkcs11.CKO_PUBLIC_KEY {
+		unwrappingClass = "private"
+	}
+
+	// the unwrapped key stays sensitive, -label and -idType explicit -id override the ones of the wrapped key
+	opts := pw.ImportOptions{
+		Extractable: true,
+		Sensitive:   true,
+		Sign:        true,
+		Derive:      wrapped.KeyType == pkcs11.CKK_EC,
+		Decrypt:     wrapped.Class == pkcs11.CKO_SECRET_KEY,
+	}
+	if err = applyImportFlags(fs, imp, &opts); err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	unwrappingKey, err := findKey(unwrappingClass, *unwrappingLabel, *unwrappingID)
+	if err != nil {
+		return
+	}
+
+	if _, err = p11w.UnwrapKeyObject(wrapped, unwrappingKey, opts); err != nil {
+		return
+	}
+
+	label := opts.Label
+	if label == "" {
+		label = wrapped.Label
+	}
+	id := wrapped.ID
+	if opts.IDStrategy == pw.IDExplicit {
+		id = opts.ID
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"class": className(wrapped.Class), "label": label, "id": hex.EncodeToString(id)})
+	} else {
+		fmt.Printf("Key was unwrapped with CKA_LABEL:%s CKA_ID:%x\n", label, id)
+	}
+
+	return
+}
+
+// returns slotID when set, otherwise the slot of the token labeled like the -slot flag
+func selectSlot(hsm hsmFlags, slotID int) (slot uint, err error) {
+
+	if slotID >= 0 {
+		slot = uint(slotID)
+		return
+	}
+
+	slot, _, err = pw.FindSlotByLabel(p11w.Context, *hsm.slot)
+	return
+}
+
+func cmdSlots(args []string) (err error) {
+
+	fs, output := newFlagSet("slots")
+	hsm := addHSMFlags(fs)
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if err = openLib(hsm); err != nil {
+		return
+	}
+
+	if outputFormat == outputText {
+		err = p11w.ListSlots()
+		return
+	}
+
+	slots, err := p11w.GetSlots()
+	if err != nil {
+		return
+	}
+	if slots == nil {
+		slots = []pw.Slot{}
+	}
+	printJSON(slots)
+
+	return
+}
+
+func cmdMechanisms(args []string) (err error) {
+
+	fs, output := newFlagSet("mechanisms")
+	hsm := addHSMFlags(fs)
+	slotID := fs.Int("slotId", -1, "Slot ID, instead of the slot label")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if err = openLib(hsm); err != nil {
+		return
+	}
+
+	slot, err := selectSlot(hsm, *slotID)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputText {
+		err = p11w.ListMechanisms(slot)
+		return
+	}
+
+	mechanisms, err := p11w.GetMechanisms(slot)
+	if err != nil {
+		return
+	}
+	if mechanisms == nil {
+		mechanisms = []pw.Mechanism{}
+	}
+	printJSON(mechanisms)
+
+	return
+}
+
+func cmdInitToken(args []string) (err error) {
+
+	fs, output := newFlagSet("init-token")
+	hsm := addHSMFlags(fs)
+	slotID := fs.Int("slotId", -1, "Slot ID of the token to initialize")
+	free := fs.Bool("free", false, "Initialize the first token which is not initialized")
+	soPin := fs.String("soPin", "", "SO PIN of the token")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if *soPin == "" {
+		err = errors.New("init-token requires -soPin")
+		return
+	}
+	if (*slotID >= 0) == *free {
+		err = errors.New("init-token requires either -slotId or -free")
+		return
+	}
+
+	if err = openLib(hsm); err != nil {
+		return
+	}
+
+	slot := uint(*slotID)
+	if *free {
+		if slot, err = p11w.FindFreeSlot(); err != nil {
+			return
+		}
+	}
+
+	if err = p11w.InitToken(slot, *soPin, *hsm.slot); err != nil {
+		return
+	}
+
+	// the library can move the initialized token to a new slot
+	newSlot, _, err := pw.FindSlotByLabel(p11w.Context, *hsm.slot)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]interface{}{"label": *hsm.slot, "slot": newSlot})
+	} else {
+		fmt.Printf("Token was initialized with label %s in slot %d\n", *hsm.slot, newSlot)
+	}
+
+	return
+}
+
+func cmdInitPIN(args []string) (err error) {
+
+	fs, output := newFlagSet("init-pin")
+	hsm := addHSMFlags(fs)
+	slotID := fs.Int("slotId", -1, "Slot ID, instead of the slot label")
+	soPin := fs.String("soPin", "", "SO PIN of the token")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if *soPin == "" {
+		err = errors.New("init-pin requires -soPin")
+		return
+	}
+
+	if err = openLib(hsm); err != nil {
+		return
+	}
+
+	slot, err := selectSlot(hsm, *slotID)
+	if err != nil {
+		return
+	}
+
+	if err = p11w.InitPIN(slot, *soPin, *hsm.pin); err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]interface{}{"slot": slot, "userPinInitialized": true})
+	} else {
+		fmt.Printf("User PIN of the token in slot %d was initialized\n", slot)
+	}
+
+	return
+}
+
+func cmdSetPIN(args []string) (err error) {
+
+	fs, output := newFlagSet("set-pin")
+	hsm := addHSMFlags(fs)
+	slotID := fs.Int("slotId", -1, "Slot ID, instead of the slot label")
+	newPin := fs.String("newPin", "", "New PIN")
+	so := fs.Bool("so", false, "Change the SO PIN, -pin is the current SO PIN")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if *newPin == "" {
+		err = errors.New("set-pin requires -newPin")
+		return
+	}
+
+	if err = openLib(hsm); err != nil {
+		return
+	}
+
+	slot, err := selectSlot(hsm, *slotID)
+	if err != nil {
+		return
+	}
+
+	if err = p11w.SetPIN(slot, *so, *hsm.pin, *newPin); err != nil {
+		return
+	}
+
+	user := "User"
+	if *so {
+		user = "SO"
+	}
+	if outputFormat == outputJSON {
+		printJSON(map[string]interface{}{"slot": slot, "changed": user})
+	} else {
+		fmt.Printf("%s PIN of the token in slot %d was changed\n", user, slot)
+	}
+
+	return
+}
+
+func cmdImportSecret(args []string) (err error) {
+
+	fs, output := newFlagSet("import-secret")
+	hsm := addHSMFlags(fs)
+	imp := addImportFlags(fs, pw.DefaultAESImportOptions.Extractable, pw.DefaultAESImportOptions.Sensitive)
+	keyFile := fs.String("keyFile", "", "File holding the raw bytes of the secret key")
+	keyType := fs.String("keyType", "AES", "Type of secret key (AES,GENERIC)")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	secretType, err := pw.ParseSecretKeyType(*keyType)
+	if err != nil {
+		return
+	}
+
+	opts, err := importOptions(fs, imp, strings.ToUpper(*keyType))
+	if err != nil {
+		return
+	}
+
+	value, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	id, err := p11w.ImportSecretKey(secretType, value, opts)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"keyType": strings.ToUpper(*keyType), "keyFile": *keyFile, "label": opts.Label, "id": hex.EncodeToString(id)})
+	} else {
+		fmt.Printf("Key was imported with CKA_ID:%x\n", id)
+	}
+
+	return
+}
+
+// the flags of the encrypt and decrypt commands
+type cipherFlags struct {
+	label *string
+	id    *string
+	mode  *string
+	aad   *string
+	in    *string
+	out   *string
+}
+
+func addCipherFlags(fs *flag.FlagSet) cipherFlags {
+	return cipherFlags{
+		label: fs.String("label", "", "CKA_LABEL of the secret key"),
+		id:    fs.String("id", "", "CKA_ID of the secret key (hex)"),
+		mode:  fs.String("mode", pw.CipherAESGCM, "Cipher mode ("+pw.CipherAESGCM+","+pw.CipherAESCBCPad+")"),
+		aad:   fs.String("aad", "", "Additional authenticated data of "+pw.CipherAESGCM),
+		in:    fs.String("in", "", "Input file"),
+		out:   fs.String("out", "", "Output file"),
+	}
+}
+
+// encrypts or decrypts the -in file to the -out file with the secret key of the flags
+func runCipher(name string, args []string, run func(mode string, key pkcs11.ObjectHandle, aad []byte, r io.Reader, w io.Writer) error) (err error) {
+
+	fs, output := newFlagSet(name)
+	hsm := addHSMFlags(fs)
+	cf := addCipherFlags(fs)
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if *cf.in == "" || *cf.out == "" {
+		err = fmt.Errorf("%s requires -in and -out", name)
+		return
+	}
+	if _, err = pw.CipherIVSize(*cf.mode); err != nil {
+		return
+	}
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	key, err := findKey("secret", *cf.label, *cf.id)
+	if err != nil {
+		return
+	}
+
+	in, err := os.Open(*cf.in)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(*cf.out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+
+	err = run(*cf.mode, key, []byte(*cf.aad), in, out)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		// don't leave a partial or unauthenticated output behind
+		os.Remove(*cf.out)
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]string{"mode": *cf.mode, "in": *cf.in, "out": *cf.out})
+	} else {
+		fmt.Printf("%s was written to %s\n", *cf.in, *cf.out)
+	}
+
+	return
+}
+
+func cmdEncrypt(args []string) (err error) {
+	err = runCipher("encrypt", args, p11w.Seal)
+	return
+}
+
+func cmdDecrypt(args []string) (err error) {
+	err = runCipher("decrypt", args, p11w.Open)
+	return
+}
+
+func cmdMAC(args []string) (err error) {
+
+	fs, output := newFlagSet("mac")
+	hsm := addHSMFlags(fs)
+	label := fs.String("label", "", "CKA_LABEL of the secret key")
+	id := fs.String("id", "", "CKA_ID of the secret key (hex)")
+	mechanism := fs.String("mechanism", pw.MACHMACSHA256, "MAC mechanism ("+pw.MACAESCMAC+","+pw.MACHMACSHA256+","+pw.MACHMACSHA384+","+pw.MACHMACSHA512+")")
+	in := fs.String("in", "", "File to compute the MAC of")
+	verify := fs.String("verify", "", "MAC to verify (hex) instead of computing it")
+	if err = parseFlags(fs, output, args); err != nil {
+		return
+	}
+
+	if _, err = pw.MACMechanism(*mechanism); err != nil {
+		return
+	}
+
+	var expected []byte
+	if *verify != "" {
+		if expected, err = hex.DecodeString(*verify); err != nil {
+			err = fmt.Errorf("invalid hex MAC %s: %v", *verify, err)
+			return
+		}
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if err = openHSM(hsm); err != nil {
+		return
+	}
+
+	key, err := findKey("secret", *label, *id)
+	if err != nil {
+		return
+	}
+
+	if expected == nil {
+		mac, errMac := p11w.MAC(*mechanism, key, f)
+		if errMac != nil {
+			err = errMac
+			return
+		}
+		if outputFormat == outputJSON {
+			printJSON(map[string]string{"mechanism": *mechanism, "mac": hex.EncodeToString(mac)})
+		} else {
+			fmt.Println(hex.EncodeToString(mac))
+		}
+		return
+	}
+
+	verified, err := p11w.VerifyMAC(*mechanism, key, f, expected)
+	if err != nil {
+		return
+	}
+
+	if outputFormat == outputJSON {
+		printJSON(map[string]bool{"verified": verified})
+	} else {
+		fmt.Println("Verified:", verified)
+	}
+
+	if !verified {
+		exitCode = 1
+	}
+
+	return
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		subject  string
+		expected pkix.Name
+		invalid  bool
+	}{
+		{
+			subject: "CN=peer0.org1.example.com,OU=peer,O=Org1,C=US",
+			expected: pkix.Name{
+				CommonName:         "peer0.org1.example.com",
+				OrganizationalUnit: []string{"peer"},
+				Organization:       []string{"Org1"},
+				Country:            []string{"US"},
+			},
+		},
+		{
+			subject: " cn = admin , st=California, l=San Francisco, street=1 Main St, postalcode=94105, serialNumber=42 ,",
+			expected: pkix.Name{
+				CommonName:    "admin",
+				Province:      []string{"California"},
+				Locality:      []string{"San Francisco"},
+				StreetAddress: []string{"1 Main St"},
+				PostalCode:    []string{"94105"},
+				SerialNumber:  "42",
+			},
+		},
+		// multi-valued attributes and RDNs
+		{
+			subject: "CN=peer0+OU=peer,OU=client,O=Org1,O=Org2",
+			expected: pkix.Name{
+				CommonName:         "peer0",
+				OrganizationalUnit: []string{"peer", "client"},
+				Organization:       []string{"Org1", "Org2"},
+			},
+		},
+		// escaped separators
+		{
+			subject: `CN=a\=b,O=Org1\, Inc.,OU=R\+D,L=C:\\temp`,
+			expected: pkix.Name{
+				CommonName:         "a=b",
+				Organization:       []string{"Org1, Inc."},
+				OrganizationalUnit: []string{"R+D"},
+				Locality:           []string{`C:\temp`},
+			},
+		},
+		{subject: "CN=x=y", expected: pkix.Name{CommonName: "x=y"}},
+		{subject: "", expected: pkix.Name{}},
+		{subject: "DC=example", invalid: true},
+		{subject: "EMAIL=admin@example.com", invalid: true},
+		{subject: "CN=admin,peer", invalid: true},
+		{subject: `CN=admin\`, invalid: true},
+	}
+	for _, tt := range tests {
+		name, err := parseSubject(tt.subject)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.subject)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: Error: %v", tt.subject, err)
+			continue
+		}
+		if !reflect.DeepEqual(name, tt.expected) {
+			t.Errorf("%q: expected %+v got %+v", tt.subject, tt.expected, name)
+		}
+	}
+}
+
+func TestAddSANs(t *testing.T) {
+	var template x509.CertificateRequest
+	sans := "peer0.org1.example.com, 10.0.0.1,::1,admin@example.com, spiffe://example.com/peer0,,localhost"
+	if err := addSANs(&template, sans); err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	if expected := []string{"peer0.org1.example.com", "localhost"}; !reflect.DeepEqual(template.DNSNames, expected) {
+		t.Errorf("expected DNS names %v got %v", expected, template.DNSNames)
+	}
+	if len(template.IPAddresses) != 2 || !template.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) || !template.IPAddresses[1].Equal(net.IPv6loopback) {
+		t.Errorf("unexpected IP addresses %v", template.IPAddresses)
+	}
+	if expected := []string{"admin@example.com"}; !reflect.DeepEqual(template.EmailAddresses, expected) {
+		t.Errorf("expected email addresses %v got %v", expected, template.EmailAddresses)
+	}
+	if len(template.URIs) != 1 || template.URIs[0].String() != "spiffe://example.com/peer0" {
+		t.Errorf("unexpected URIs %v", template.URIs)
+	}
+
+	if err := addSANs(&template, "http://[::1"); err == nil {
+		t.Error("expected an error for an invalid URI")
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/miekg/pkcs11"
 	"os"
+	"sort"
 	"strings"
 
 	pw "github.com/gbolo/go-util/p11tool/pkcs11wrapper"
@@ -20,17 +22,97 @@ const (
 /usr/lib/s390x-linux-gnu/softhsm/libsofthsm2.so,
 /usr/lib/powerpc64le-linux-gnu/softhsm/libsofthsm2.so,
 /usr/local/Cellar/softhsm/2.1.0/lib/softhsm/libsofthsm2.so`
+
+	outputText = "text"
+	outputJSON = "json"
 )
 
 var (
 	// shared pkcs11 wrapper struct
 	p11w pw.Pkcs11Wrapper
+
+	// output format of the command being run (text or json)
+	outputFormat = outputText
+
+	// exit code when the command did not fail but has a negative result (verify)
+	exitCode = 0
 )
 
+// a subcommand of p11tool
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// available subcommands, set in init to avoid an initialization loop with newFlagSet
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"list":          {"list objects in the slot", cmdList},
+		"import":        {"import a key from a PEM file", cmdImport},
+		"generate":      {"generate a key pair or a secret key in the HSM", cmdGenerate},
+		"ski":           {"print the SKI (CKA_ID) of a key file, no HSM required", cmdSKI},
+		"sign":          {"sign a message with a private key in the slot", cmdSign},
+		"verify":        {"verify a signature with a public key in the slot", cmdVerify},
+		"delete":        {"delete objects from the slot", cmdDelete},
+		"rename":        {"change the CKA_LABEL and/or CKA_ID of objects in the slot", cmdRename},
+		"copy":          {"copy objects in the slot with a new CKA_LABEL and/or CKA_ID", cmdCopy},
+		"export-pub":    {"export a public key in the slot as PEM, JWK or SSH", cmdExportPub},
+		"export-cert":   {"export a certificate in the slot as PEM", cmdExportCert},
+		"csr":           {"create a PKCS#10 CSR signed by a private key in the slot", cmdCSR},
+		"import-cert":   {"import an X.509 certificate with the CKA_ID of its key", cmdImportCert},
+		"wrap":          {"wrap a private or secret key in the slot with a wrapping key", cmdWrap},
+		"unwrap":        {"unwrap a key wrapped by the wrap command into the slot", cmdUnwrap},
+		"slots":         {"list the slots and the info of their tokens", cmdSlots},
+		"mechanisms":    {"list the mechanisms supported by a token", cmdMechanisms},
+		"init-token":    {"initialize a token with a label and an SO PIN", cmdInitToken},
+		"init-pin":      {"set the user PIN of a token with the SO PIN", cmdInitPIN},
+		"set-pin":       {"change the user PIN, or the SO PIN, of a token", cmdSetPIN},
+		"import-secret": {"import a raw AES or generic secret key", cmdImportSecret},
+		"encrypt":       {"encrypt a file with an AES key in the slot", cmdEncrypt},
+		"decrypt":       {"decrypt a file encrypted by the encrypt command", cmdDecrypt},
+		"mac":           {"compute or verify the CMAC or HMAC of a file", cmdMAC},
+	}
+}
+
+// flags shared by the commands which require HSM
+type hsmFlags struct {
+	lib  *string
+	slot *string
+	pin  *string
+}
+
+// flags selecting the objects a command acts on
+type selectFlags struct {
+	class *string
+	label *string
+	id    *string
+}
+
+// flags shared by the commands which import keys
+type importFlags struct {
+	label       *string
+	pubLabel    *string
+	idType      *string
+	id          *string
+	session     *bool
+	extractable *bool
+	sensitive   *bool
+	derive      *bool
+	sign        *bool
+	decrypt     *bool
+	wrap        *bool
+}
+
 // exit cleanly when error is no nil
 func exitWhenError(err error) {
 	if err != nil {
-		fmt.Println("Error:", err)
+		if outputFormat == outputJSON {
+			printJSON(map[string]string{"error": err.Error()})
+		} else {
+			fmt.Println("Error:", err)
+		}
 		os.Exit(1)
 	}
 }
@@ -53,206 +135,270 @@ func searchForLib(paths string) (firstFound string, err error) {
 	return
 }
 
+// returns a flag set for a command with the --output flag
+func newFlagSet(name string) (fs *flag.FlagSet, output *string) {
+	fs = flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s: %s\n", os.Args[0], name, commands[name].usage)
+		fs.PrintDefaults()
+	}
+	output = fs.String("output", outputText, "Output format (text,json)")
+	return
+}
 
-func main() {
-
-	// get flags
-	pkcs11Library := flag.String("lib", "", "Location of pkcs11 library")
-	slotLabel := flag.String("slot", "ForFabric", "Slot Label")
-	slotPin := flag.String("pin", "98765432", "Slot PIN")
-	action := flag.String("action", "list", "list,import,generateAndImport,getSKI")
-	keyFile := flag.String("keyFile", "/some/dir/key.pem", "path to key you want to import or getSKI")
-	keyType := flag.String("keyType", "EC", "Type of key (EC,RSA)")
-
-	flag.Parse()
-
-	var err error
-
-	// complete actions which do not require HSM
-	switch *action {
-
-	case "getSKI":
-		if *keyType == "RSA" {
-			key := pw.RsaKey{}
-			err = key.ImportPrivKeyFromFile(*keyFile)
-			exitWhenError(err)
-			key.GenSKI()
-			fmt.Printf("SKI(sha256): %s\n", key.SKI.Sha256)
-			os.Exit(0)
-		} else {
-			key := pw.EcdsaKey{}
-			err = key.ImportPrivKeyFromFile(*keyFile)
-			exitWhenError(err)
-			key.GenSKI()
-			fmt.Printf("SKI(sha256): %s\n", key.SKI.Sha256)
-			os.Exit(0)
-		}
+// adds the flags needed to open the HSM
+func addHSMFlags(fs *flag.FlagSet) hsmFlags {
+	return hsmFlags{
+		lib:  fs.String("lib", "", "Location of pkcs11 library"),
+		slot: fs.String("slot", "ForFabric", "Slot Label"),
+		pin:  fs.String("pin", "98765432", "Slot PIN"),
+	}
+}
 
+// adds the flags selecting objects, verb describes what the command does with them
+func addSelectFlags(fs *flag.FlagSet, verb string) selectFlags {
+	return selectFlags{
+		class: fs.String("class", "", "Only "+verb+" objects of this class (private,public,secret,cert,data)"),
+		label: fs.String("label", "", "CKA_LABEL of the objects to "+verb),
+		id:    fs.String("id", "", "CKA_ID of the objects to "+verb+" (hex)"),
 	}
+}
 
-	// complete actions which require HSM
+// adds the flags mapping onto pkcs11wrapper.ImportOptions, extractable and sensitive are the
+// defaults of the keys the command creates
+func addImportFlags(fs *flag.FlagSet, extractable bool, sensitive bool) importFlags {
+	return importFlags{
+		label:       fs.String("label", "", "CKA_LABEL of the private key (default BCPRV1 for EC, TLSPRVKEY for RSA)"),
+		pubLabel:    fs.String("pubLabel", "", "CKA_LABEL of the public key (default BCPUB1 for EC, TLSPUBKEY for RSA, -label when set)"),
+		idType:      fs.String("idType", "sha256", "How CKA_ID is set (sha256,sha1,explicit)"),
+		id:          fs.String("id", "", "CKA_ID (hex) when -idType is explicit"),
+		session:     fs.Bool("session", false, "Import as session objects instead of token objects"),
+		extractable: fs.Bool("extractable", extractable, "CKA_EXTRACTABLE of the private or secret key"),
+		sensitive:   fs.Bool("sensitive", sensitive, "CKA_SENSITIVE of the private or secret key"),
+		derive:      fs.Bool("derive", false, "Allow derive with the private key, only an explicit -derive overrides the default of the key type (true for EC, false for others)"),
+		sign:        fs.Bool("sign", true, "Allow sign/verify with the key pair"),
+		decrypt:     fs.Bool("decrypt", false, "Allow decrypt/encrypt with the key pair"),
+		wrap:        fs.Bool("wrap", false, "Allow unwrap/wrap with the key pair"),
+	}
+}
 
-	// initialize pkcs11
-	var p11Lib string
+// returns the import options of keyType overridden by the flags set on the command line
+func importOptions(fs *flag.FlagSet, flags importFlags, keyType string) (opts pw.ImportOptions, err error) {
 
-	if *pkcs11Library == "" {
-		p11Lib, err = searchForLib(defaultLibPaths)
-		exitWhenError(err)
-	} else {
-		p11Lib, err = searchForLib(*pkcs11Library)
-		exitWhenError(err)
+	if opts, err = defaultImportOptions(keyType); err != nil {
+		return
 	}
 
-	p11w = pw.Pkcs11Wrapper{
-		Library: pw.Pkcs11Library{
-			Path: p11Lib,
-		},
-		SlotLabel: *slotLabel,
-		SlotPin:   *slotPin,
+	err = applyImportFlags(fs, flags, &opts)
+
+	return
+}
+
+// the import options of a generated key: not extractable and sensitive unless the flags
+// say otherwise
+func generateOptions(fs *flag.FlagSet, flags importFlags, keyType string) (opts pw.ImportOptions, err error) {
+
+	if opts, err = defaultImportOptions(keyType); err != nil {
+		return
 	}
+	opts = pw.DefaultGenerateOptions(opts)
 
-	err = p11w.InitContext()
-	exitWhenError(err)
+	err = applyImportFlags(fs, flags, &opts)
 
-	err = p11w.InitSession()
-	exitWhenError(err)
+	return
+}
 
-	err = p11w.Login()
-	exitWhenError(err)
+func defaultImportOptions(keyType string) (opts pw.ImportOptions, err error) {
+
+	switch keyType {
+	case "EC":
+		opts = pw.DefaultECImportOptions
+	case "RSA":
+		opts = pw.DefaultRSAImportOptions
+	case "Ed25519":
+		opts = pw.DefaultEd25519ImportOptions
+	case "AES":
+		opts = pw.DefaultAESImportOptions
+	case "GENERIC":
+		opts = pw.DefaultGenericSecretImportOptions
+	default:
+		err = fmt.Errorf("unsupported key type: %s (EC,RSA,Ed25519,AES,GENERIC)", keyType)
+	}
+
+	return
+}
 
-	// defer cleanup
-	defer p11w.Context.Destroy()
-	defer p11w.Context.Finalize()
-	defer p11w.Context.CloseSession(p11w.Session)
-	defer p11w.Context.Logout(p11w.Session)
+// overrides opts with the import flags set on the command line
+func applyImportFlags(fs *flag.FlagSet, flags importFlags, opts *pw.ImportOptions) (err error) {
 
-	switch *action {
+	if *flags.label != "" {
+		opts.Label = *flags.label
+		opts.PubLabel = *flags.pubLabel
+	} else if *flags.pubLabel != "" {
+		opts.PubLabel = *flags.pubLabel
+	}
 
-	case "import":
-		if *keyType == "RSA" {
-			err = p11w.ImportRSAKeyFromFile(*keyFile)
-			exitWhenError(err)
-		} else {
-			err = p11w.ImportECKeyFromFile(*keyFile)
-			exitWhenError(err)
+	if err = setIDOptions(opts, *flags.idType, *flags.id); err != nil {
+		return
+	}
+
+	// only the flags given override the defaults
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "session":
+			opts.Session = *flags.session
+		case "extractable":
+			opts.Extractable = *flags.extractable
+		case "sensitive":
+			opts.Sensitive = *flags.sensitive
+		case "derive":
+			opts.Derive = *flags.derive
+		case "sign":
+			opts.Sign = *flags.sign
+		case "decrypt":
+			opts.Decrypt = *flags.decrypt
+		case "wrap":
+			opts.Wrap = *flags.wrap
 		}
+	})
 
-	case "generateAndImport":
-		if *keyType == "RSA" {
-			rsa := pw.RsaKey{}
-			rsa.Generate(2048)
-			p11w.ImportRSAKey(rsa)
-		} else {
-			ec := pw.EcdsaKey{}
-			// TODO: fix non working curves (P-521)
-			ec.Generate("P-256")
-			p11w.ImportECKey(ec)
+	return
+}
+
+// sets the CKA_ID strategy of opts from the -idType and -id flags
+func setIDOptions(opts *pw.ImportOptions, idType string, id string) (err error) {
+
+	if opts.IDStrategy, err = pw.ParseIDStrategy(idType); err != nil {
+		return
+	}
+
+	if id != "" {
+		if opts.IDStrategy != pw.IDExplicit {
+			err = errors.New("-id requires -idType explicit")
+			return
 		}
+		if opts.ID, err = hex.DecodeString(id); err != nil {
+			err = fmt.Errorf("invalid hex id %s: %v", id, err)
+			return
+		}
+	}
 
-	case "testEc":
-
-		message := "Some Test Message"
-
-		// test SW ecdsa sign and verify
-		ec := pw.EcdsaKey{}
-		ec.ImportPrivKeyFromFile("contrib/testfiles/key.pem")
-		sig, err := ec.SignMessage(message)
-		exitWhenError(err)
-		fmt.Println("Signature:", sig)
-		verified := ec.VerifySignature(message, sig)
-		fmt.Println("Verified:", verified)
-
-		// test PKCS11 ecdsa sign and verify
-		// Find object
-		id, err := hex.DecodeString("018f389d200e48536367f05b99122f355ba33572009bd2b8b521cdbbb717a5b5")
-		exitWhenError(err)
-
-		o, _, err := p11w.FindObjects([]*pkcs11.Attribute{
-			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
-			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "BCPRV1"),
-			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
-		},
-			2,
-		)
-
-		exitWhenError(err)
-
-		sig, err = p11w.SignMessage(message, o[0])
-		exitWhenError(err)
-		fmt.Println("pkcs11 Signature:", sig)
-		verified = ec.VerifySignature(message, sig)
-		fmt.Println("Verified:", verified)
-
-		// test pkcs11 verify
-		o, _, err = p11w.FindObjects([]*pkcs11.Attribute{
-			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
-			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "BCPUB1"),
-			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
-			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
-		},
-			2,
-		)
+	return
+}
 
-		verified, err = p11w.VerifySignature(message, sig, o[0])
-		exitWhenError(err)
-		fmt.Println("pkcs11 Verified:", verified)
+// parses the flags of a command and sets the output format
+func parseFlags(fs *flag.FlagSet, output *string, args []string) (err error) {
 
-		// derive test
-		ec2 := pw.EcdsaKey{}
-		ec2.Generate("P-256")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	switch *output {
+	case outputText, outputJSON:
+		outputFormat = *output
+	default:
+		err = fmt.Errorf("unsupported output format: %s", *output)
+	}
 
-		secret, err := ec.DeriveSharedSecret(ec2.PubKey)
-		exitWhenError(err)
-		fmt.Printf("shared secret: %x\n", secret)
+	return
+}
 
-		secret, err = ec2.DeriveSharedSecret(ec.PubKey)
-		exitWhenError(err)
-		fmt.Printf("shared secret: %x\n", secret)
+// initialize pkcs11 and login to the slot
+func openHSM(flags hsmFlags) (err error) {
 
-	case "testRsa":
-		message := "Some Test Message"
+	if err = openLib(flags); err != nil {
+		return
+	}
 
-		rsa := pw.RsaKey{}
-		//rsa.Generate(2048)
-		err = rsa.ImportPrivKeyFromFile("contrib/testfiles/key.rsa.pem")
-		exitWhenError(err)
-		rsa.GenSKI()
+	err = p11w.InitSession()
+	if err != nil {
+		return
+	}
 
-		err = p11w.ImportRSAKey(rsa)
-		exitWhenError(err)
+	err = p11w.Login()
 
-		sig, err := rsa.SignMessage(message, 256)
-		exitWhenError(err)
+	return
+}
 
-		fmt.Println("Signature:", sig)
+// loads and initializes the library without opening a session, for the slot and token commands
+func openLib(flags hsmFlags) (err error) {
 
-		// test PKCS11 ecdsa sign and verify
-		// Find object
-		id, err := hex.DecodeString("0344ae0121e025d998f5923174e9e4d69b899144ac79bfdf01c065bd4d99d6cb")
-		exitWhenError(err)
+	var p11Lib string
 
-		o, _, err := p11w.FindObjects([]*pkcs11.Attribute{
-			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "TLSPRVKEY"),
-			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	if *flags.lib == "" {
+		p11Lib, err = searchForLib(defaultLibPaths)
+	} else {
+		p11Lib, err = searchForLib(*flags.lib)
+	}
+	if err != nil {
+		return
+	}
+
+	p11w = pw.Pkcs11Wrapper{
+		Library: pw.Pkcs11Library{
+			Path: p11Lib,
 		},
-			2,
-		)
-		exitWhenError(err)
+		SlotLabel: *flags.slot,
+		SlotPin:   *flags.pin,
+	}
 
-		sig, err = p11w.SignMessageAdvanced([]byte(message), o[0], pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil))
-		exitWhenError(err)
+	err = p11w.InitContext()
 
-		fmt.Println("pkcs11 Signature:", sig)
+	return
+}
 
-	default:
-		p11w.ListObjects(
-			[]*pkcs11.Attribute{},
-			50,
-		)
+// cleanup what openHSM did
+func closeHSM() {
+	if p11w.Context == nil {
+		return
+	}
+	p11w.Context.Logout(p11w.Session)
+	p11w.Context.CloseSession(p11w.Session)
+	p11w.Context.Finalize()
+	p11w.Context.Destroy()
+}
 
+// print v as indented JSON
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
 	}
+	fmt.Println(string(out))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -help' for the flags of a command.\n", os.Args[0])
+}
+
+func main() {
 
-}
\ No newline at end of file
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, found := commands[os.Args[1]]
+	if !found {
+		if os.Args[1] != "-h" && os.Args[1] != "-help" && os.Args[1] != "--help" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	err := cmd.run(os.Args[2:])
+	closeHSM()
+	exitWhenError(err)
+	os.Exit(exitCode)
+}
@@ -0,0 +1,107 @@
+package pkcs11wrapper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/miekg/pkcs11"
+)
+
+// GenSKIFromPublicKey returns the SKI of pub computed like GenSKI of EcdsaKey and RsaKey,
+// the CKA_ID of keys imported with the sha256 or sha1 IDStrategy
+func GenSKIFromPublicKey(pub crypto.PublicKey) (ski SubjectKeyIdentifier, err error) {
+
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		key := EcdsaKey{PubKey: k}
+		key.GenSKI()
+		ski = key.SKI
+	case *rsa.PublicKey:
+		key := RsaKey{PubKey: k}
+		key.GenSKI()
+		ski = key.SKI
+	case ed25519.PublicKey:
+		key := Ed25519Key{PubKey: k}
+		key.GenSKI()
+		ski = key.SKI
+	default:
+		err = fmt.Errorf("unsupported public key type: %T", pub)
+		return
+	}
+
+	if len(ski.Sha256Bytes) == 0 {
+		err = errors.New("could not compute the SKI of the public key")
+	}
+
+	return
+}
+
+// ReadCertificateFile reads a PEM or DER encoded X.509 certificate
+func ReadCertificateFile(file string) (cert *x509.Certificate, err error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE" {
+			err = fmt.Errorf("%s holds a %s, not a CERTIFICATE", file, block.Type)
+			return
+		}
+		data = block.Bytes
+	}
+
+	cert, err = x509.ParseCertificate(data)
+	return
+}
+
+// Imports cert as a CKO_CERTIFICATE object with the label and CKA_ID of opts, with the
+// sha256 or sha1 IDStrategy the CKA_ID is the one of the key of the certificate.
+// Only the Label, ID and Session of opts apply. Returns the CKA_ID of the object.
+func (p11w *Pkcs11Wrapper) ImportCertificate(cert *x509.Certificate, opts ImportOptions) (id []byte, err error) {
+
+	ski, err := GenSKIFromPublicKey(cert.PublicKey)
+	if err != nil && opts.IDStrategy != IDExplicit {
+		return
+	}
+
+	id, err = opts.id(ski)
+	if err != nil {
+		return
+	}
+
+	serial, err := asn1.Marshal(cert.SerialNumber)
+	if err != nil {
+		return
+	}
+
+	certTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, !opts.Session),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, opts.Label),
+		pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, cert.RawSubject),
+		pkcs11.NewAttribute(pkcs11.CKA_ISSUER, cert.RawIssuer),
+		pkcs11.NewAttribute(pkcs11.CKA_SERIAL_NUMBER, serial),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, cert.Raw),
+	}
+
+	_, err = p11w.Context.CreateObject(p11w.Session, certTemplate)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
+	}
+
+	return
+}
@@ -0,0 +1,79 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestReadCertificateFile(t *testing.T) {
+	pem, err := ReadCertificateFile("testdata/ec_cert.pem")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if pem.Subject.CommonName != "p11tool-test" {
+		t.Errorf("unexpected subject %s", pem.Subject)
+	}
+
+	der, err := ReadCertificateFile("testdata/ec_cert.der")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if !der.Equal(pem) {
+		t.Error("ec_cert.der is not the certificate of ec_cert.pem")
+	}
+
+	// a PEM block that isn't a CERTIFICATE, and a key that isn't DER either
+	for _, invalid := range []string{"ec_pkix.pem", "rsa_pkcs1.pem", "ec_pkcs8.der", "missing.pem"} {
+		if _, err = ReadCertificateFile("testdata/" + invalid); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
+
+// the CKA_ID of an imported certificate is the one of its key
+func TestGenSKIFromPublicKey(t *testing.T) {
+	tests := []struct {
+		cert string
+		key  string
+	}{
+		{cert: "ec_cert.pem", key: "ec_pkcs8.pem"},
+		{cert: "rsa_cert.pem", key: "rsa_pkcs1.pem"},
+	}
+	for _, tt := range tests {
+		cert, err := ReadCertificateFile("testdata/" + tt.cert)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		key, err := LoadKeyFile("testdata/"+tt.key, nil)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+
+		var expected SubjectKeyIdentifier
+		switch k := key.Public.(type) {
+		case *ecdsa.PublicKey:
+			ecKey := EcdsaKey{PubKey: k}
+			ecKey.GenSKI()
+			expected = ecKey.SKI
+		case *rsa.PublicKey:
+			rsaKey := RsaKey{PubKey: k}
+			rsaKey.GenSKI()
+			expected = rsaKey.SKI
+		}
+
+		ski, err := GenSKIFromPublicKey(cert.PublicKey)
+		if err != nil {
+			t.Errorf("%s: Error: %v", tt.cert, err)
+			continue
+		}
+		if len(ski.Sha256Bytes) == 0 || !bytes.Equal(ski.Sha256Bytes, expected.Sha256Bytes) || !bytes.Equal(ski.Sha1Bytes, expected.Sha1Bytes) {
+			t.Errorf("%s: SKI %s differs from the SKI %s of %s", tt.cert, ski.Sha256, expected.Sha256, tt.key)
+		}
+	}
+
+	if _, err := GenSKIFromPublicKey("not a key"); err == nil {
+		t.Error("expected an error for an unsupported public key")
+	}
+}
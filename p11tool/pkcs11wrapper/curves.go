@@ -0,0 +1,298 @@
+package pkcs11wrapper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// PKCS#11 3.0 values for Ed25519 keys, github.com/miekg/pkcs11 v1.1.1 does not define them
+const (
+	CKK_EC_EDWARDS              = 0x00000040
+	CKM_EC_EDWARDS_KEY_PAIR_GEN = 0x00001055
+	CKM_EDDSA                   = 0x00001057
+)
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	// secp256k1 OBJECT IDENTIFIER ::= {
+	//   iso(1) identified-organization(3) certicom(132) curve(0) 10 }
+	oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+	// RFC 8410 id-Ed25519
+	oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// secp256k1 is y² = x³ + 7, elliptic.CurveParams only implements the a = -3 curves
+// so the arithmetic is done here, in affine coordinates. It is not constant time.
+type secp256k1Curve struct {
+	*elliptic.CurveParams
+}
+
+var (
+	secp256k1Once sync.Once
+	secp256k1     secp256k1Curve
+)
+
+// Secp256k1 returns the curve used by Bitcoin and Ethereum, usable with crypto/ecdsa
+func Secp256k1() elliptic.Curve {
+	secp256k1Once.Do(func() {
+		params := &elliptic.CurveParams{Name: "secp256k1", BitSize: 256}
+		params.P, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+		params.N, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+		params.B = big.NewInt(7)
+		params.Gx, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+		params.Gy, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+		secp256k1 = secp256k1Curve{params}
+	})
+	return secp256k1
+}
+
+func (c secp256k1Curve) Params() *elliptic.CurveParams {
+	return c.CurveParams
+}
+
+func (c secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.Cmp(c.P) >= 0 || y.Sign() < 0 || y.Cmp(c.P) >= 0 {
+		return false
+	}
+	y2 := new(big.Int).Mul(y, y)
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.B)
+	return y2.Sub(y2, x3).Mod(y2, c.P).Sign() == 0
+}
+
+// (0, 0) is the point at infinity, like in crypto/elliptic
+func (c secp256k1Curve) Add(x1, y1, x2, y2 *big.Int) (x, y *big.Int) {
+
+	if x1.Sign() == 0 && y1.Sign() == 0 {
+		return new(big.Int).Set(x2), new(big.Int).Set(y2)
+	}
+	if x2.Sign() == 0 && y2.Sign() == 0 {
+		return new(big.Int).Set(x1), new(big.Int).Set(y1)
+	}
+	if x1.Cmp(x2) == 0 {
+		if y1.Cmp(y2) == 0 {
+			return c.Double(x1, y1)
+		}
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	num := new(big.Int).Sub(y2, y1)
+	den := new(big.Int).Sub(x2, x1)
+	den.Mod(den, c.P).ModInverse(den, c.P)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, c.P)
+
+	return c.affine(lambda, x1, y1, x2)
+}
+
+func (c secp256k1Curve) Double(x1, y1 *big.Int) (x, y *big.Int) {
+
+	if y1.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = 3x² / 2y
+	num := new(big.Int).Mul(x1, x1)
+	num.Mul(num, big.NewInt(3))
+	den := new(big.Int).Lsh(y1, 1)
+	den.Mod(den, c.P).ModInverse(den, c.P)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, c.P)
+
+	return c.affine(lambda, x1, y1, x1)
+}
+
+// returns x = λ² - x1 - x2, y = λ(x1 - x) - y1
+func (c secp256k1Curve) affine(lambda, x1, y1, x2 *big.Int) (x, y *big.Int) {
+
+	x = new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, x1).Sub(x, x2).Mod(x, c.P)
+
+	y = new(big.Int).Sub(x1, x)
+	y.Mul(y, lambda).Sub(y, y1).Mod(y, c.P)
+
+	return
+}
+
+func (c secp256k1Curve) ScalarMult(bx, by *big.Int, k []byte) (x, y *big.Int) {
+
+	x, y = new(big.Int), new(big.Int)
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			x, y = c.Double(x, y)
+			if b>>uint(bit)&1 == 1 {
+				x, y = c.Add(x, y, bx, by)
+			}
+		}
+	}
+	return
+}
+
+func (c secp256k1Curve) ScalarBaseMult(k []byte) (x, y *big.Int) {
+	return c.ScalarMult(c.Gx, c.Gy, k)
+}
+
+// returns the value of CKA_EC_POINT, the point as a DER OCTET STRING
+func encodeECPoint(point []byte) (ecPoint []byte, err error) {
+	ecPoint, err = asn1.Marshal(point)
+	return
+}
+
+// returns the point of a CKA_EC_POINT value, which should be a DER OCTET STRING but is the
+// raw point with some providers
+func decodeECPoint(ecPoint []byte) (point []byte) {
+	if rest, err := asn1.Unmarshal(ecPoint, &point); err == nil && len(rest) == 0 {
+		return
+	}
+	return ecPoint
+}
+
+// reports whether a CKA_EC_PARAMS value is Ed25519, as the RFC 8410 OID or the PKCS#11 3.0
+// printable string
+func isEd25519Params(ecParamMarshaled []byte) bool {
+
+	var oid asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(ecParamMarshaled, &oid); err == nil && len(rest) == 0 {
+		return oid.Equal(oidEd25519)
+	}
+
+	var name string
+	if rest, err := asn1.Unmarshal(ecParamMarshaled, &name); err == nil && len(rest) == 0 {
+		return name == "edwards25519"
+	}
+
+	return false
+}
+
+// crypto/x509 does not know secp256k1, these parse and marshal its keys
+
+// RFC 5915 ECPrivateKey
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// RFC 5208 PrivateKeyInfo
+type pkcs8PrivateKey struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// RFC 5280 SubjectPublicKeyInfo
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// reports whether algo is an EC key on secp256k1
+func isSecp256k1Algorithm(algo pkix.AlgorithmIdentifier) bool {
+	var curveOID asn1.ObjectIdentifier
+	if !algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		return false
+	}
+	_, err := asn1.Unmarshal(algo.Parameters.FullBytes, &curveOID)
+	return err == nil && curveOID.Equal(oidSecp256k1)
+}
+
+// parses a SEC1 secp256k1 private key, returns x509Err for other keys
+func parseSecp256k1SEC1(der []byte, x509Err error) (key crypto.PrivateKey, err error) {
+
+	var ecKey ecPrivateKey
+	if _, errAsn := asn1.Unmarshal(der, &ecKey); errAsn != nil || !ecKey.NamedCurveOID.Equal(oidSecp256k1) {
+		err = x509Err
+		return
+	}
+
+	key, err = newSecp256k1PrivateKey(ecKey.PrivateKey)
+	return
+}
+
+// parses a PKCS#8 secp256k1 private key, returns x509Err for other keys
+func parseSecp256k1PKCS8(der []byte, x509Err error) (key crypto.PrivateKey, err error) {
+
+	var info pkcs8PrivateKey
+	if _, errAsn := asn1.Unmarshal(der, &info); errAsn != nil || !isSecp256k1Algorithm(info.Algo) {
+		err = x509Err
+		return
+	}
+
+	// the curve is in the algorithm, the ECPrivateKey may omit it
+	var ecKey ecPrivateKey
+	if _, err = asn1.Unmarshal(info.PrivateKey, &ecKey); err != nil {
+		return
+	}
+
+	key, err = newSecp256k1PrivateKey(ecKey.PrivateKey)
+	return
+}
+
+// parses a PKIX secp256k1 public key, returns x509Err for other keys
+func parseSecp256k1PKIX(der []byte, x509Err error) (key crypto.PublicKey, err error) {
+
+	var info subjectPublicKeyInfo
+	if _, errAsn := asn1.Unmarshal(der, &info); errAsn != nil || !isSecp256k1Algorithm(info.Algorithm) {
+		err = x509Err
+		return
+	}
+
+	curve := Secp256k1()
+	x, y := elliptic.Unmarshal(curve, info.PublicKey.RightAlign())
+	if x == nil {
+		err = errors.New("invalid secp256k1 public key point")
+		return
+	}
+
+	key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	return
+}
+
+// returns the secp256k1 private key of the scalar d
+func newSecp256k1PrivateKey(d []byte) (key *ecdsa.PrivateKey, err error) {
+
+	curve := Secp256k1()
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		err = errors.New("invalid secp256k1 private key")
+		return
+	}
+
+	key = &ecdsa.PrivateKey{D: k}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d)
+
+	return
+}
+
+// marshals a secp256k1 public key as PKIX
+func marshalSecp256k1PKIX(pub *ecdsa.PublicKey) (der []byte, err error) {
+
+	if pub.Curve != Secp256k1() {
+		err = fmt.Errorf("not a secp256k1 key: %s", pub.Curve.Params().Name)
+		return
+	}
+
+	params, err := asn1.Marshal(oidSecp256k1)
+	if err != nil {
+		return
+	}
+
+	point := elliptic.Marshal(pub.Curve, pub.X, pub.Y)
+	der, err = asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+
+	return
+}
@@ -0,0 +1,140 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestEncodeECPoint(t *testing.T) {
+	tests := []struct {
+		curve  elliptic.Curve
+		prefix string
+	}{
+		{elliptic.P256(), "0441"},
+		{elliptic.P384(), "0461"},
+		// 133 bytes need the long form length
+		{elliptic.P521(), "048185"},
+	}
+	for _, tt := range tests {
+		key, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		point := elliptic.Marshal(tt.curve, key.X, key.Y)
+		ecPoint, err := encodeECPoint(point)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		prefix, _ := hex.DecodeString(tt.prefix)
+		if !bytes.HasPrefix(ecPoint, prefix) || len(ecPoint) != len(prefix)+len(point) {
+			t.Errorf("%s: unexpected CKA_EC_POINT %x", tt.curve.Params().Name, ecPoint)
+		}
+		if !bytes.Equal(decodeECPoint(ecPoint), point) {
+			t.Errorf("%s: CKA_EC_POINT not decoded", tt.curve.Params().Name)
+		}
+		// some providers return the raw point
+		if !bytes.Equal(decodeECPoint(point), point) {
+			t.Errorf("%s: raw point not returned as is", tt.curve.Params().Name)
+		}
+	}
+}
+
+func TestSecp256k1(t *testing.T) {
+	curve := Secp256k1()
+	params := curve.Params()
+	if !curve.IsOnCurve(params.Gx, params.Gy) {
+		t.Fatal("G is not on the curve")
+	}
+
+	// 2G from the SEC 2 test vectors
+	x2, _ := new(big.Int).SetString("C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5", 16)
+	y2, _ := new(big.Int).SetString("1AE168FEA63DC339A3C58419466CEAEEF7F632653266D0E1236431A950CFE52A", 16)
+	if x, y := curve.Double(params.Gx, params.Gy); x.Cmp(x2) != 0 || y.Cmp(y2) != 0 {
+		t.Errorf("unexpected G+G %x %x", x, y)
+	}
+	if x, y := curve.ScalarBaseMult([]byte{2}); x.Cmp(x2) != 0 || y.Cmp(y2) != 0 {
+		t.Errorf("unexpected 2G %x %x", x, y)
+	}
+	if x, y := curve.Add(x2, y2, params.Gx, params.Gy); !curve.IsOnCurve(x, y) {
+		t.Errorf("3G %x %x is not on the curve", x, y)
+	}
+	if x, y := curve.ScalarBaseMult(params.N.Bytes()); x.Sign() != 0 || y.Sign() != 0 {
+		t.Errorf("nG %x %x is not the point at infinity", x, y)
+	}
+}
+
+func TestSecp256k1ECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(Secp256k1(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	digest := sha256.Sum256([]byte("FooBar"))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("signature not verified")
+	}
+	digest[0] ^= 1
+	if ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("signature verified for another digest")
+	}
+
+	// and through PKIX
+	der, err := marshalSecp256k1PKIX(&key.PublicKey)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	pub, err := parseSecp256k1PKIX(der, nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if !key.PublicKey.Equal(pub) {
+		t.Error("PKIX round trip changed the key")
+	}
+}
+
+func TestEcdsaRawToDER(t *testing.T) {
+	// r has its high bit set and gets a leading zero, the leading zeros of s are dropped
+	raw, _ := hex.DecodeString("80000001" + "00007fff")
+	der, err := ecdsaRawToDER(raw)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if expected := "300b0205008000000102027fff"; hex.EncodeToString(der) != expected {
+		t.Errorf("expected %s got %x", expected, der)
+	}
+
+	for _, invalid := range [][]byte{nil, {1, 2, 3}} {
+		if _, err = ecdsaRawToDER(invalid); err == nil {
+			t.Errorf("%x: expected an error", invalid)
+		}
+	}
+
+	// a CKM_ECDSA signature is r||s, each padded to the size of the order
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	digest := sha256.Sum256([]byte("FooBar"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	raw = make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+	if der, err = ecdsaRawToDER(raw); err != nil {
+		t.Fatal("Error:", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], der) {
+		t.Error("converted signature not verified")
+	}
+}
@@ -1,18 +1,16 @@
 package pkcs11wrapper
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
-	"crypto/x509"
 	"encoding/asn1"
 	"encoding/hex"
-	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"math/big"
 )
 
@@ -64,10 +62,16 @@ func (k *EcdsaKey) Generate(namedCurve string) (err error) {
 		k.PrivKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 	case "P-521":
 		k.PrivKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "secp256k1":
+		k.PrivKey, err = ecdsa.GenerateKey(Secp256k1(), rand.Reader)
 	default:
 		k.PrivKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	}
 
+	if err != nil {
+		return
+	}
+
 	// store public key
 	k.PubKey = &k.PrivKey.PublicKey
 
@@ -75,42 +79,61 @@ func (k *EcdsaKey) Generate(namedCurve string) (err error) {
 }
 
 func (k *EcdsaKey) ImportPubKeyFromPubKeyFile(file string) (err error) {
+
+	key, err := LoadKeyFile(file, nil)
+	if err != nil {
+		return
+	}
+
+	ec, err := key.EcdsaKey()
+	if err != nil {
+		return
+	}
+
+	k.PubKey = ec.PubKey
+
 	return
 }
 
 func (k *EcdsaKey) ImportPubKeyFromCertFile(file string) (err error) {
 
-	certFile, err := ioutil.ReadFile(file)
+	key, err := LoadKeyFile(file, nil)
 	if err != nil {
 		return
 	}
+	if key.Cert == nil {
+		err = fmt.Errorf("%s: not a certificate", file)
+		return
+	}
 
-	certBlock, _ := pem.Decode(certFile)
-	x509Cert, err := x509.ParseCertificate(certBlock.Bytes)
+	ec, err := key.EcdsaKey()
 	if err != nil {
 		return
 	}
 
-	k.PubKey = x509Cert.PublicKey.(*ecdsa.PublicKey)
+	k.PubKey = ec.PubKey
 
 	return
 }
 
 func (k *EcdsaKey) ImportPrivKeyFromFile(file string) (err error) {
 
-	keyFile, err := ioutil.ReadFile(file)
+	key, err := LoadKeyFile(file, nil)
 	if err != nil {
 		return
 	}
 
-	keyBlock, _ := pem.Decode(keyFile)
-	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	ec, err := key.EcdsaKey()
 	if err != nil {
 		return
 	}
+	if ec.PrivKey == nil {
+		err = fmt.Errorf("%s: not a private key", file)
+		return
+	}
 
-	k.PrivKey = key.(*ecdsa.PrivateKey)
-	k.PubKey = &k.PrivKey.PublicKey
+	k.PrivKey = ec.PrivKey
+	k.PubKey = ec.PubKey
 
 	return
 }
@@ -134,6 +157,8 @@ func GetECParamMarshaled(namedCurve string) (ecParamMarshaled []byte, err error)
 	//   iso(1) identified-organization(3) certicom(132) curve(0) 35 }
 	//
 	// NB: secp256r1 is equivalent to prime256v1
+	//
+	// secp256k1 and, for CKK_EC_EDWARDS keys, the RFC 8410 id-Ed25519 are also supported
 
 	ecParamOID := asn1.ObjectIdentifier{}
 
@@ -146,6 +171,10 @@ func GetECParamMarshaled(namedCurve string) (ecParamMarshaled []byte, err error)
 		ecParamOID = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
 	case "P-521":
 		ecParamOID = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+	case "secp256k1":
+		ecParamOID = oidSecp256k1
+	case "Ed25519":
+		ecParamOID = oidEd25519
 	}
 
 	if len(ecParamOID) == 0 {
@@ -157,6 +186,21 @@ func GetECParamMarshaled(namedCurve string) (ecParamMarshaled []byte, err error)
 	return
 }
 
+/* returns the curve of a CKA_EC_PARAMS value */
+func GetECCurve(ecParamMarshaled []byte) (curve elliptic.Curve, err error) {
+
+	for _, c := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521(), Secp256k1()} {
+		marshaled, errMarshal := GetECParamMarshaled(c.Params().Name)
+		if errMarshal == nil && bytes.Equal(marshaled, ecParamMarshaled) {
+			curve = c
+			return
+		}
+	}
+
+	err = fmt.Errorf("Unsupported CKA_EC_PARAMS: %x", ecParamMarshaled)
+	return
+}
+
 func (k *EcdsaKey) SignMessage(message string) (signature string, err error) {
 
 	// we should always hash the message before signing it
@@ -198,17 +242,12 @@ func (k *EcdsaKey) SignMessage(message string) (signature string, err error) {
 		return
 	}
 
-	// encode the signature {R, S}
-	// big.Int.Bytes() will need padding in the case of leading zero bytes
-	//params := k.PrivKey.Curve.Params()
-	//curveOrderByteSize := params.P.BitLen() / 8
-	//rBytes, sBytes := r.Bytes(), s.Bytes()
-	//signatureBytes := make([]byte, curveOrderByteSize*2)
-	//copy(signatureBytes[curveOrderByteSize-len(rBytes):], rBytes)
-	//copy(signatureBytes[curveOrderByteSize*2-len(sBytes):], sBytes)
-
-	signatureBytes := r.Bytes()
-	signatureBytes = append(signatureBytes, s.Bytes()...)
+	// encode the signature {R, S} like CKM_ECDSA does
+	// big.Int.Bytes() needs padding in the case of leading zero bytes, P-521 is 66 bytes
+	curveOrderByteSize := (k.PrivKey.Curve.Params().BitSize + 7) / 8
+	signatureBytes := make([]byte, curveOrderByteSize*2)
+	r.FillBytes(signatureBytes[:curveOrderByteSize])
+	s.FillBytes(signatureBytes[curveOrderByteSize:])
 
 	signature = hex.EncodeToString(signatureBytes)
 
@@ -225,7 +264,7 @@ func (k *EcdsaKey) VerifySignature(message string, signature string) (verified b
 	// we should always hash the message before signing it
 	// TODO: detect what hash function to use by key length:
 	// https://www.ietf.org/rfc/rfc4754.txt
-	bs := k.PrivKey.Params().BitSize
+	bs := k.PubKey.Params().BitSize
 	var digest []byte
 
 	switch {
@@ -244,7 +283,10 @@ func (k *EcdsaKey) VerifySignature(message string, signature string) (verified b
 	}
 
 	// get curve byte size
-	curveOrderByteSize := k.PubKey.Curve.Params().P.BitLen() / 8
+	curveOrderByteSize := (k.PubKey.Curve.Params().BitSize + 7) / 8
+	if len(signatureBytes) != curveOrderByteSize*2 {
+		return
+	}
 
 	// extract r and s
 	r, s := new(big.Int), new(big.Int)
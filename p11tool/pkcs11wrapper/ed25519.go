@@ -0,0 +1,59 @@
+package pkcs11wrapper
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+type Ed25519Key struct {
+	PubKey  ed25519.PublicKey
+	PrivKey ed25519.PrivateKey
+	SKI     SubjectKeyIdentifier
+}
+
+// SKI returns the subject key identifier of this key, computed over the 32 bytes of the
+// public key like the EC one over the uncompressed point.
+func (k *Ed25519Key) GenSKI() {
+	if len(k.PubKey) == 0 {
+		return
+	}
+
+	hash := sha256.New()
+	hash.Write(k.PubKey)
+	k.SKI.Sha256Bytes = hash.Sum(nil)
+	k.SKI.Sha256 = hex.EncodeToString(k.SKI.Sha256Bytes)
+
+	hash = sha1.New()
+	hash.Write(k.PubKey)
+	k.SKI.Sha1Bytes = hash.Sum(nil)
+	k.SKI.Sha1 = hex.EncodeToString(k.SKI.Sha1Bytes)
+
+	return
+}
+
+func (k *Ed25519Key) Generate() (err error) {
+
+	k.PubKey, k.PrivKey, err = ed25519.GenerateKey(rand.Reader)
+	return
+}
+
+func (k *Ed25519Key) SignMessage(message string) (signature string) {
+
+	// Ed25519 hashes the message itself
+	signature = hex.EncodeToString(ed25519.Sign(k.PrivKey, []byte(message)))
+	return
+}
+
+func (k *Ed25519Key) VerifySignature(message string, signature string) (verified bool) {
+
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return
+	}
+
+	verified = ed25519.Verify(k.PubKey, []byte(message), signatureBytes)
+	return
+}
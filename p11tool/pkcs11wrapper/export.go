@@ -0,0 +1,311 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// formats public keys and certificates can be exported as
+type KeyFormat string
+
+const (
+	// PKIX public key or X.509 certificate PEM
+	FormatPEM KeyFormat = "pem"
+	// RFC 7517 JSON Web Key
+	FormatJWK KeyFormat = "jwk"
+	// OpenSSH authorized_keys line
+	FormatSSH KeyFormat = "ssh"
+)
+
+// ParseKeyFormat parses pem, jwk or ssh
+func ParseKeyFormat(s string) (format KeyFormat, err error) {
+	switch KeyFormat(s) {
+	case FormatPEM, FormatJWK, FormatSSH:
+		format = KeyFormat(s)
+	default:
+		err = fmt.Errorf("unsupported export format: %s (pem,jwk,ssh)", s)
+	}
+	return
+}
+
+// JWK is the JSON Web Key of an RSA, EC or, as an RFC 8037 OKP, Ed25519 public key
+type JWK struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+// NewJWK returns the JWK of pub with the key id kid
+func NewJWK(pub crypto.PublicKey, kid string) (jwk JWK, err error) {
+
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	switch k := pub.(type) {
+
+	case *rsa.PublicKey:
+		jwk = JWK{
+			Kty: "RSA",
+			Kid: kid,
+			N:   b64(k.N.Bytes()),
+			E:   b64(big.NewInt(int64(k.E)).Bytes()),
+		}
+
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve, RFC 7518 6.2.1.2
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{
+			Kty: "EC",
+			Kid: kid,
+			Crv: k.Curve.Params().Name,
+			X:   b64(padBytes(k.X.Bytes(), size)),
+			Y:   b64(padBytes(k.Y.Bytes(), size)),
+		}
+
+	case ed25519.PublicKey:
+		jwk = JWK{
+			Kty: "OKP",
+			Kid: kid,
+			Crv: "Ed25519",
+			X:   b64(k),
+		}
+
+	default:
+		err = fmt.Errorf("unsupported public key type: %T", pub)
+	}
+
+	return
+}
+
+// returns b left padded with zeros to size
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// MarshalSSHPublicKey returns the authorized_keys line of pub, P-224 and secp256k1 have no SSH key type
+func MarshalSSHPublicKey(pub crypto.PublicKey, comment string) (line []byte, err error) {
+
+	var keyType string
+	var wire bytes.Buffer
+
+	switch k := pub.(type) {
+
+	case *rsa.PublicKey:
+		keyType = "ssh-rsa"
+		writeSSHString(&wire, []byte(keyType))
+		writeSSHMpint(&wire, big.NewInt(int64(k.E)))
+		writeSSHMpint(&wire, k.N)
+
+	case *ecdsa.PublicKey:
+		var curve string
+		switch k.Curve.Params().Name {
+		case "P-256":
+			curve = "nistp256"
+		case "P-384":
+			curve = "nistp384"
+		case "P-521":
+			curve = "nistp521"
+		default:
+			err = fmt.Errorf("unsupported curve for SSH: %s", k.Curve.Params().Name)
+			return
+		}
+		keyType = "ecdsa-sha2-" + curve
+		writeSSHString(&wire, []byte(keyType))
+		writeSSHString(&wire, []byte(curve))
+		writeSSHString(&wire, elliptic.Marshal(k.Curve, k.X, k.Y))
+
+	case ed25519.PublicKey:
+		keyType = "ssh-ed25519"
+		writeSSHString(&wire, []byte(keyType))
+		writeSSHString(&wire, k)
+
+	default:
+		err = fmt.Errorf("unsupported public key type: %T", pub)
+		return
+	}
+
+	line = []byte(keyType + " " + base64.StdEncoding.EncodeToString(wire.Bytes()))
+	if comment != "" {
+		line = append(line, ' ')
+		line = append(line, comment...)
+	}
+	line = append(line, '\n')
+
+	return
+}
+
+// RFC 4251 string
+func writeSSHString(w *bytes.Buffer, s []byte) {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(s)))
+	w.Write(l[:])
+	w.Write(s)
+}
+
+// RFC 4251 mpint of a positive integer
+func writeSSHMpint(w *bytes.Buffer, n *big.Int) {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	writeSSHString(w, b)
+}
+
+// MarshalPublicKey returns pub in format, name is the kid of a JWK and the comment of an SSH line
+func MarshalPublicKey(pub crypto.PublicKey, format KeyFormat, name string) (out []byte, err error) {
+
+	switch format {
+
+	case FormatPEM:
+		der, errMarshal := marshalPKIXPublicKey(pub)
+		if errMarshal != nil {
+			err = errMarshal
+			return
+		}
+		out = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	case FormatJWK:
+		jwk, errJwk := NewJWK(pub, name)
+		if errJwk != nil {
+			err = errJwk
+			return
+		}
+		if out, err = json.MarshalIndent(jwk, "", "  "); err != nil {
+			return
+		}
+		out = append(out, '\n')
+
+	case FormatSSH:
+		out, err = MarshalSSHPublicKey(pub, name)
+
+	default:
+		err = fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	return
+}
+
+// x509.MarshalPKIXPublicKey, with secp256k1 keys
+func marshalPKIXPublicKey(pub crypto.PublicKey) (der []byte, err error) {
+	if k, ok := pub.(*ecdsa.PublicKey); ok && k.Curve == Secp256k1() {
+		return marshalSecp256k1PKIX(k)
+	}
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// MarshalCertificate returns cert in format. The JWK and SSH formats hold the public key
+// of the certificate, the JWK with the certificate in x5c.
+func MarshalCertificate(cert *x509.Certificate, format KeyFormat, name string) (out []byte, err error) {
+
+	switch format {
+
+	case FormatPEM:
+		out = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	case FormatJWK:
+		jwk, errJwk := NewJWK(cert.PublicKey, name)
+		if errJwk != nil {
+			err = errJwk
+			return
+		}
+		jwk.X5c = []string{base64.StdEncoding.EncodeToString(cert.Raw)}
+		if out, err = json.MarshalIndent(jwk, "", "  "); err != nil {
+			return
+		}
+		out = append(out, '\n')
+
+	default:
+		out, err = MarshalPublicKey(cert.PublicKey, format, name)
+	}
+
+	return
+}
+
+// Reads the X.509 certificate out of a CKO_CERTIFICATE object
+func (p11w *Pkcs11Wrapper) GetCertificate(object pkcs11.ObjectHandle) (cert *x509.Certificate, err error) {
+
+	al, err := p11w.Context.GetAttributeValue(
+		p11w.Session,
+		object,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)},
+	)
+	if err != nil {
+		return
+	}
+	if len(al) != 1 || len(al[0].Value) == 0 {
+		err = errors.New("certificate object has no CKA_VALUE")
+		return
+	}
+
+	cert, err = x509.ParseCertificate(al[0].Value)
+	return
+}
+
+// returns the CKA_LABEL of an object
+func (p11w *Pkcs11Wrapper) getLabel(object pkcs11.ObjectHandle) (label string, err error) {
+
+	al, err := p11w.Context.GetAttributeValue(
+		p11w.Session,
+		object,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil)},
+	)
+	if err == nil && len(al) == 1 {
+		label = string(al[0].Value)
+	}
+	return
+}
+
+// Exports the public key of a CKO_PUBLIC_KEY object in format, named with its CKA_LABEL
+func (p11w *Pkcs11Wrapper) ExportPublicKey(object pkcs11.ObjectHandle, format KeyFormat) (out []byte, err error) {
+
+	pub, err := p11w.GetPublicKey(object)
+	if err != nil {
+		return
+	}
+
+	label, err := p11w.getLabel(object)
+	if err != nil {
+		return
+	}
+
+	out, err = MarshalPublicKey(pub, format, label)
+	return
+}
+
+// Exports a CKO_CERTIFICATE object in format, named with its CKA_LABEL
+func (p11w *Pkcs11Wrapper) ExportCertificate(object pkcs11.ObjectHandle, format KeyFormat) (out []byte, err error) {
+
+	cert, err := p11w.GetCertificate(object)
+	if err != nil {
+		return
+	}
+
+	label, err := p11w.getLabel(object)
+	if err != nil {
+		return
+	}
+
+	out, err = MarshalCertificate(cert, format, label)
+	return
+}
@@ -0,0 +1,186 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewJWK(t *testing.T) {
+	rsaKey, err := LoadKeyFile("testdata/rsa_pkix.pem", nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	// the x of the P-521 base point is a byte short of the curve size
+	p521 := elliptic.P521().Params()
+	edPub, _ := hex.DecodeString("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+
+	tests := []struct {
+		name     string
+		pub      interface{}
+		expected JWK
+	}{
+		{
+			name:     "RSA",
+			pub:      rsaKey.Public,
+			expected: JWK{Kty: "RSA", Kid: "k", E: "AQAB"},
+		},
+		{
+			name: "P-521 short x",
+			pub:  &ecdsa.PublicKey{Curve: elliptic.P521(), X: p521.Gx, Y: p521.Gy},
+			expected: JWK{Kty: "EC", Kid: "k", Crv: "P-521",
+				X: base64.RawURLEncoding.EncodeToString(append([]byte{0}, p521.Gx.Bytes()...)),
+				Y: base64.RawURLEncoding.EncodeToString(padBytes(p521.Gy.Bytes(), 66)),
+			},
+		},
+		{
+			name:     "Ed25519",
+			pub:      ed25519.PublicKey(edPub),
+			expected: JWK{Kty: "OKP", Kid: "k", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		},
+	}
+	for _, tt := range tests {
+		jwk, err := NewJWK(tt.pub, "k")
+		if err != nil {
+			t.Errorf("%s: Error: %v", tt.name, err)
+			continue
+		}
+		if tt.expected.Kty == "RSA" {
+			// the modulus is checked through the SSH line
+			jwk.N = ""
+		}
+		if !reflect.DeepEqual(jwk, tt.expected) {
+			t.Errorf("%s: expected %+v got %+v", tt.name, tt.expected, jwk)
+		}
+	}
+	if x, _ := base64.RawURLEncoding.DecodeString(tests[1].expected.X); len(x) != 66 || len(p521.Gx.Bytes()) != 65 {
+		t.Errorf("x of %d bytes from %d", len(x), len(p521.Gx.Bytes()))
+	}
+
+	if _, err = NewJWK("not a key", "k"); err == nil {
+		t.Error("expected an error for an unsupported key")
+	}
+}
+
+func TestMarshalSSHPublicKey(t *testing.T) {
+	edPub, _ := hex.DecodeString("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+
+	tests := []struct {
+		name     string
+		file     string
+		pub      interface{}
+		expected string
+	}{
+		// the expected lines of the files were written by ssh-keygen -i -m PKCS8
+		{name: "RSA", file: "rsa_pkix"},
+		{name: "nistp256", file: "ec_pkix"},
+		{name: "Ed25519", pub: ed25519.PublicKey(edPub), expected: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINdamAGCsQq31Uv+08lkBzoO4XLz2qYjJa8CGmj3B1Ea\n"},
+	}
+	for _, tt := range tests {
+		if tt.file != "" {
+			key, err := LoadKeyFile("testdata/"+tt.file+".pem", nil)
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+			line, err := ioutil.ReadFile("testdata/" + tt.file + ".pub")
+			if err != nil {
+				t.Fatal("Error:", err)
+			}
+			tt.pub, tt.expected = key.Public, string(line)
+		}
+		line, err := MarshalSSHPublicKey(tt.pub, "")
+		if err != nil {
+			t.Errorf("%s: Error: %v", tt.name, err)
+			continue
+		}
+		if string(line) != tt.expected {
+			t.Errorf("%s: expected %s got %s", tt.name, tt.expected, line)
+		}
+		if line, _ = MarshalSSHPublicKey(tt.pub, "my key"); string(line) != strings.TrimSuffix(tt.expected, "\n")+" my key\n" {
+			t.Errorf("%s: no comment in %s", tt.name, line)
+		}
+	}
+
+	// no SSH key type for these curves
+	for _, curve := range []elliptic.Curve{elliptic.P224(), Secp256k1()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if _, err = MarshalSSHPublicKey(&key.PublicKey, ""); err == nil {
+			t.Errorf("%s: expected an error", curve.Params().Name)
+		}
+	}
+}
+
+func TestMarshalPublicKey(t *testing.T) {
+	key, err := LoadKeyFile("testdata/ec_pkix.pem", nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	expected, err := ioutil.ReadFile("testdata/ec_pkix.pem")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if out, err := MarshalPublicKey(key.Public, FormatPEM, ""); err != nil || !bytes.Equal(out, expected) {
+		t.Errorf("unexpected PEM %v:\n%s", err, out)
+	}
+
+	out, err := MarshalPublicKey(key.Public, FormatJWK, "ec-key")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	var jwk JWK
+	if err = json.Unmarshal(out, &jwk); err != nil || jwk.Kty != "EC" || jwk.Kid != "ec-key" || jwk.Crv != "P-256" {
+		t.Errorf("unexpected JWK %v:\n%s", err, out)
+	}
+
+	if _, err = MarshalPublicKey(key.Public, KeyFormat("der"), ""); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if _, err = MarshalPublicKey("not a key", FormatJWK, ""); err == nil {
+		t.Error("expected an error for an unsupported key")
+	}
+}
+
+func TestMarshalCertificate(t *testing.T) {
+	key, err := LoadKeyFile("testdata/ec_cert.pem", nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+
+	expected, err := ioutil.ReadFile("testdata/ec_cert.pem")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if out, err := MarshalCertificate(key.Cert, FormatPEM, ""); err != nil || !bytes.Equal(out, expected) {
+		t.Errorf("unexpected PEM %v:\n%s", err, out)
+	}
+
+	out, err := MarshalCertificate(key.Cert, FormatJWK, "cert")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	var jwk JWK
+	if err = json.Unmarshal(out, &jwk); err != nil || jwk.Kty != "EC" || jwk.Kid != "cert" ||
+		len(jwk.X5c) != 1 || jwk.X5c[0] != base64.StdEncoding.EncodeToString(key.Cert.Raw) {
+		t.Errorf("unexpected JWK %v:\n%s", err, out)
+	}
+
+	expected, err = ioutil.ReadFile("testdata/ec_pkix.pub")
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	if out, err = MarshalCertificate(key.Cert, FormatSSH, ""); err != nil || !bytes.Equal(out, expected) {
+		t.Errorf("unexpected SSH line %v: %s", err, out)
+	}
+}
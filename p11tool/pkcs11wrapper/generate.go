@@ -0,0 +1,141 @@
+package pkcs11wrapper
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/miekg/pkcs11"
+)
+
+// DefaultGenerateOptions returns opts for a key pair generated in the HSM, whose private key
+// is sensitive and not extractable so it never leaves the HSM
+func DefaultGenerateOptions(opts ImportOptions) ImportOptions {
+	opts.Extractable = false
+	opts.Sensitive = true
+	return opts
+}
+
+// Generates an EC key pair on curve (P-224,P-256,P-384,P-521,secp256k1) in the HSM with
+// CKM_EC_KEY_PAIR_GEN, returns the CKA_ID of the objects
+func (p11w *Pkcs11Wrapper) GenerateECKeyPair(curve string, opts ImportOptions) (id []byte, err error) {
+
+	marshaledOID, err := GetECParamMarshaled(curve)
+	if err != nil {
+		return
+	}
+
+	id, err = p11w.generateKeyPair(
+		pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil),
+		pkcs11.CKK_EC,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID)},
+		opts,
+	)
+	return
+}
+
+// Generates an RSA key pair of bits with the public exponent 65537 in the HSM with
+// CKM_RSA_PKCS_KEY_PAIR_GEN, returns the CKA_ID of the objects
+func (p11w *Pkcs11Wrapper) GenerateRSAKeyPair(bits int, opts ImportOptions) (id []byte, err error) {
+
+	id, err = p11w.generateKeyPair(
+		pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil),
+		pkcs11.CKK_RSA,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{0x01, 0x00, 0x01}),
+		},
+		opts,
+	)
+	return
+}
+
+// Generates an Ed25519 key pair in the HSM with CKM_EC_EDWARDS_KEY_PAIR_GEN, returns the
+// CKA_ID of the objects
+func (p11w *Pkcs11Wrapper) GenerateEd25519KeyPair(opts ImportOptions) (id []byte, err error) {
+
+	marshaledOID, err := GetECParamMarshaled("Ed25519")
+	if err != nil {
+		return
+	}
+
+	id, err = p11w.generateKeyPair(
+		pkcs11.NewMechanism(CKM_EC_EDWARDS_KEY_PAIR_GEN, nil),
+		CKK_EC_EDWARDS,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID)},
+		opts,
+	)
+	return
+}
+
+// Generates a key pair with C_GenerateKeyPair and the attributes of opts plus pubParams on
+// the public key. The CKA_ID is only known once the public key is read back, so unless it
+// is explicit it is set on both objects after the generation, like ImportECKeyWithOptions
+// would have set it. The objects are destroyed when that fails.
+func (p11w *Pkcs11Wrapper) generateKeyPair(mechanism *pkcs11.Mechanism, keyType uint, pubParams []*pkcs11.Attribute, opts ImportOptions) (id []byte, err error) {
+
+	if opts.IDStrategy == IDExplicit {
+		if id, err = opts.id(SubjectKeyIdentifier{}); err != nil {
+			return
+		}
+	}
+
+	pubTemplate := append(withID(opts.pubAttributes(keyType, nil), id), pubParams...)
+	privTemplate := withID(opts.privAttributes(keyType, nil), id)
+
+	pub, priv, err := p11w.Context.GenerateKeyPair(p11w.Session, []*pkcs11.Mechanism{mechanism}, pubTemplate, privTemplate)
+	if err != nil {
+		return
+	}
+
+	if opts.IDStrategy != IDExplicit {
+		if id, err = p11w.setIDFromPublicKey(pub, priv, opts); err != nil {
+			p11w.Context.DestroyObject(p11w.Session, pub)
+			p11w.Context.DestroyObject(p11w.Session, priv)
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Object was generated with CKA_LABEL:%s CKA_ID:%x\n", opts.pubLabel(), id)
+	fmt.Fprintf(os.Stderr, "Object was generated with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
+
+	return
+}
+
+// reads the public key of pub and sets the CKA_ID computed from it with opts on pub and priv
+func (p11w *Pkcs11Wrapper) setIDFromPublicKey(pub, priv pkcs11.ObjectHandle, opts ImportOptions) (id []byte, err error) {
+
+	pubKey, err := p11w.GetPublicKey(pub)
+	if err != nil {
+		return
+	}
+
+	ski, err := GenSKIFromPublicKey(pubKey)
+	if err != nil {
+		return
+	}
+
+	if id, err = opts.id(ski); err != nil {
+		return
+	}
+
+	for _, object := range []pkcs11.ObjectHandle{pub, priv} {
+		if err = p11w.SetAttributes(object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, id)}); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// returns template with its CKA_ID set to id, or without CKA_ID when id is empty
+func withID(template []*pkcs11.Attribute, id []byte) (attrs []*pkcs11.Attribute) {
+	for _, a := range template {
+		if a.Type != pkcs11.CKA_ID {
+			attrs = append(attrs, a)
+		}
+	}
+	if len(id) > 0 {
+		attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	return
+}
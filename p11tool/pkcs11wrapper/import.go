@@ -0,0 +1,280 @@
+package pkcs11wrapper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+)
+
+// how the CKA_ID of imported keys is set
+type IDStrategy int
+
+const (
+	// SHA-256 of the public key, what fabric BCCSP expects
+	IDSha256 IDStrategy = iota
+	// SHA-1 of the public key, the usual X.509 SKI
+	IDSha1
+	// the bytes of ImportOptions.ID
+	IDExplicit
+)
+
+// ParseIDStrategy parses sha256, sha1 or explicit
+func ParseIDStrategy(s string) (strategy IDStrategy, err error) {
+	switch strings.ToLower(s) {
+	case "sha256":
+		strategy = IDSha256
+	case "sha1":
+		strategy = IDSha1
+	case "explicit":
+		strategy = IDExplicit
+	default:
+		err = fmt.Errorf("unsupported id strategy: %s (sha256,sha1,explicit)", s)
+	}
+	return
+}
+
+// ImportOptions controls the attributes of the objects created when importing a key pair.
+// The usage flags apply to both objects: Sign sets CKA_SIGN on the private key and
+// CKA_VERIFY on the public key, Decrypt sets CKA_DECRYPT and CKA_ENCRYPT, Wrap sets
+// CKA_UNWRAP and CKA_WRAP.
+type ImportOptions struct {
+	// CKA_LABEL of the private key, and of the public key when PubLabel is empty
+	Label    string
+	PubLabel string
+
+	// CKA_ID of both objects
+	IDStrategy IDStrategy
+	ID         []byte
+
+	// create session objects, destroyed with the session, instead of token objects
+	Session bool
+
+	// CKA_EXTRACTABLE and CKA_SENSITIVE of the private key
+	Extractable bool
+	Sensitive   bool
+
+	// usage flags
+	Derive  bool
+	Sign    bool
+	Decrypt bool
+	Wrap    bool
+}
+
+var (
+	// what ImportECKey has always done
+	DefaultECImportOptions = ImportOptions{
+		Label:       "BCPRV1",
+		PubLabel:    "BCPUB1",
+		Extractable: true,
+		Derive:      true,
+		Sign:        true,
+	}
+
+	// what ImportRSAKey has always done
+	DefaultRSAImportOptions = ImportOptions{
+		Label:       "TLSPRVKEY",
+		PubLabel:    "TLSPUBKEY",
+		Extractable: true,
+		Sign:        true,
+	}
+
+	// Ed25519 keys only sign
+	DefaultEd25519ImportOptions = ImportOptions{
+		Label:       "EDPRVKEY",
+		PubLabel:    "EDPUBKEY",
+		Extractable: true,
+		Sign:        true,
+	}
+
+	// AES keys encrypt, decrypt and CMAC, and never leave the HSM
+	DefaultAESImportOptions = ImportOptions{
+		Label:     "AESKEY",
+		Sensitive: true,
+		Decrypt:   true,
+		Sign:      true,
+	}
+
+	// generic secret keys HMAC, and never leave the HSM
+	DefaultGenericSecretImportOptions = ImportOptions{
+		Label:     "HMACKEY",
+		Sensitive: true,
+		Sign:      true,
+	}
+)
+
+// returns the CKA_LABEL of the public key
+func (o ImportOptions) pubLabel() string {
+	if o.PubLabel == "" {
+		return o.Label
+	}
+	return o.PubLabel
+}
+
+// returns the CKA_ID for a key with ski
+func (o ImportOptions) id(ski SubjectKeyIdentifier) (id []byte, err error) {
+	switch o.IDStrategy {
+	case IDSha256:
+		id = ski.Sha256Bytes
+	case IDSha1:
+		id = ski.Sha1Bytes
+	case IDExplicit:
+		if len(o.ID) == 0 {
+			err = errors.New("explicit id strategy requires an id")
+			return
+		}
+		id = o.ID
+	default:
+		err = fmt.Errorf("unsupported id strategy: %d", o.IDStrategy)
+	}
+	return
+}
+
+// returns the attributes common to the public key objects
+func (o ImportOptions) pubAttributes(keyType uint, id []byte) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, !o.Session),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, o.Sign),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, o.Decrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, o.Wrap),
+
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, o.pubLabel()),
+	}
+}
+
+// returns the attributes common to the private key objects
+func (o ImportOptions) privAttributes(keyType uint, id []byte) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, !o.Session),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, o.Sign),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, o.Decrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, o.Derive),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, o.Wrap),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, o.Extractable),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, o.Sensitive),
+
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, o.Label),
+	}
+}
+
+// returns the attributes common to the secret key objects, Sign allows HMAC
+func (o ImportOptions) secretAttributes(keyType uint, id []byte) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, !o.Session),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, o.Sign),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, o.Sign),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, o.Decrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, o.Decrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, o.Derive),
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, o.Wrap),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, o.Wrap),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, o.Extractable),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, o.Sensitive),
+
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, o.Label),
+	}
+}
+
+// returns the key type and the attributes holding the value of a public key
+func publicKeyAttributes(pub crypto.PublicKey) (keyType uint, attrs []*pkcs11.Attribute, err error) {
+
+	switch k := pub.(type) {
+
+	case *ecdsa.PublicKey:
+		marshaledOID, errOID := GetECParamMarshaled(k.Params().Name)
+		if errOID != nil {
+			err = errOID
+			return
+		}
+		// DER OCTET STRING of the point, with a long form length from P-521 on
+		ecPt, errPt := encodeECPoint(elliptic.Marshal(k.Curve, k.X, k.Y))
+		if errPt != nil {
+			err = errPt
+			return
+		}
+
+		keyType = pkcs11.CKK_EC
+		attrs = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, ecPt),
+		}
+
+	case ed25519.PublicKey:
+		marshaledOID, errOID := GetECParamMarshaled("Ed25519")
+		if errOID != nil {
+			err = errOID
+			return
+		}
+		ecPt, errPt := encodeECPoint(k)
+		if errPt != nil {
+			err = errPt
+			return
+		}
+
+		keyType = CKK_EC_EDWARDS
+		attrs = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, ecPt),
+		}
+
+	case *rsa.PublicKey:
+		keyType = pkcs11.CKK_RSA
+		attrs = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, k.N.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(k.E)).Bytes()),
+		}
+
+	default:
+		err = fmt.Errorf("unsupported public key type: %T", pub)
+	}
+
+	return
+}
+
+// Imports only the public key object of a key pair, with the public key label, CKA_ID and usage of opts.
+// Importing the public key of a wrapping key pair of another HSM with Wrap allows to wrap keys for it.
+// Returns the CKA_ID of the object.
+func (p11w *Pkcs11Wrapper) ImportPublicKey(pub crypto.PublicKey, opts ImportOptions) (id []byte, err error) {
+
+	ski, err := GenSKIFromPublicKey(pub)
+	if err != nil {
+		return
+	}
+
+	id, err = opts.id(ski)
+	if err != nil {
+		return
+	}
+
+	keyType, valueAttrs, err := publicKeyAttributes(pub)
+	if err != nil {
+		return
+	}
+
+	_, err = p11w.Context.CreateObject(p11w.Session, append(opts.pubAttributes(keyType, id), valueAttrs...))
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.pubLabel(), id)
+	}
+
+	return
+}
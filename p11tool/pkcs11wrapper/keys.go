@@ -0,0 +1,449 @@
+package pkcs11wrapper
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+)
+
+var (
+	// returned by LoadKey for encrypted keys when no passphrase is given
+	ErrPassphraseRequired = errors.New("key is encrypted, a passphrase is required")
+	// returned by LoadKey when the key can't be decrypted with the passphrase
+	ErrIncorrectPassphrase = errors.New("key could not be decrypted, incorrect passphrase")
+)
+
+// Key is a key read by LoadKey
+type Key struct {
+	// nil for public keys and certificates
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+	// set when the key was read from a certificate
+	Cert *x509.Certificate
+	// encoding the key was read from: PKCS#8, SEC1, PKCS#1, PKIX or X.509
+	Format string
+}
+
+// KeyType returns EC, RSA or Ed25519
+func (k Key) KeyType() string {
+	switch k.Public.(type) {
+	case *ecdsa.PublicKey:
+		return "EC"
+	case *rsa.PublicKey:
+		return "RSA"
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", k.Public)
+}
+
+// EcdsaKey returns k as an EcdsaKey with its SKI, PrivKey is nil for public keys
+func (k Key) EcdsaKey() (ec EcdsaKey, err error) {
+
+	pub, ok := k.Public.(*ecdsa.PublicKey)
+	if !ok {
+		err = fmt.Errorf("not an EC key: %s", k.KeyType())
+		return
+	}
+
+	ec.PubKey = pub
+	if k.Private != nil {
+		ec.PrivKey = k.Private.(*ecdsa.PrivateKey)
+	}
+	ec.GenSKI()
+
+	return
+}
+
+// RsaKey returns k as an RsaKey with its SKI, PrivKey is nil for public keys
+func (k Key) RsaKey() (r RsaKey, err error) {
+
+	pub, ok := k.Public.(*rsa.PublicKey)
+	if !ok {
+		err = fmt.Errorf("not an RSA key: %s", k.KeyType())
+		return
+	}
+
+	r.PubKey = pub
+	if k.Private != nil {
+		r.PrivKey = k.Private.(*rsa.PrivateKey)
+	}
+	r.GenSKI()
+
+	return
+}
+
+// Ed25519Key returns k as an Ed25519Key with its SKI, PrivKey is nil for public keys
+func (k Key) Ed25519Key() (ed Ed25519Key, err error) {
+
+	pub, ok := k.Public.(ed25519.PublicKey)
+	if !ok {
+		err = fmt.Errorf("not an Ed25519 key: %s", k.KeyType())
+		return
+	}
+
+	ed.PubKey = pub
+	if k.Private != nil {
+		ed.PrivKey = k.Private.(ed25519.PrivateKey)
+	}
+	ed.GenSKI()
+
+	return
+}
+
+// LoadKeyFile reads file with LoadKey
+func LoadKeyFile(file string, passphrase []byte) (key Key, err error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	key, err = LoadKey(data, passphrase)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", file, err)
+	}
+
+	return
+}
+
+// LoadKey reads an EC, RSA or Ed25519 key from PEM or DER data, EC keys on the NIST curves or
+// secp256k1. Private keys can be PKCS#8, SEC1 or PKCS#1, encrypted with a passphrase as PKCS#8 ENCRYPTED PRIVATE KEY (PBES2) or with the
+// legacy Proc-Type header. Public keys can be PKIX or PKCS#1, or come from a certificate.
+func LoadKey(data []byte, passphrase []byte) (key Key, err error) {
+
+	block, rest := pem.Decode(data)
+	if block == nil {
+		// not PEM, try every DER encoding
+		if key, err = parsePrivateKeyDER(data); err == nil {
+			return
+		}
+		if key, err = parsePublicKeyDER(data); err == nil {
+			return
+		}
+		err = errors.New("no PEM block or DER encoded key found")
+		return
+	}
+
+	// openssl ecparam -genkey writes the curve before the key
+	for block != nil && block.Type == "EC PARAMETERS" {
+		block, rest = pem.Decode(rest)
+	}
+	if block == nil {
+		err = errors.New("no key found after EC PARAMETERS")
+		return
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			err = ErrPassphraseRequired
+			return
+		}
+		if der, err = x509.DecryptPEMBlock(block, passphrase); err != nil {
+			err = ErrIncorrectPassphrase
+			return
+		}
+	}
+
+	switch block.Type {
+
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			err = ErrPassphraseRequired
+			return
+		}
+		if der, err = decryptPKCS8(der, passphrase); err != nil {
+			return
+		}
+		key, err = parseKey("PKCS#8", der)
+
+	case "PRIVATE KEY":
+		key, err = parseKey("PKCS#8", der)
+
+	case "EC PRIVATE KEY":
+		key, err = parseKey("SEC1", der)
+
+	case "RSA PRIVATE KEY":
+		key, err = parseKey("PKCS#1", der)
+
+	case "PUBLIC KEY":
+		key, err = parseKey("PKIX", der)
+
+	case "RSA PUBLIC KEY":
+		key, err = parseKey("PKCS#1 public", der)
+
+	case "CERTIFICATE":
+		key, err = parseKey("X.509", der)
+
+	default:
+		err = fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+
+	return
+}
+
+// parses der in format
+func parseKey(format string, der []byte) (key Key, err error) {
+
+	key.Format = format
+
+	switch format {
+	case "PKCS#8":
+		if key.Private, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+			key.Private, err = parseSecp256k1PKCS8(der, err)
+		}
+	case "SEC1":
+		if key.Private, err = x509.ParseECPrivateKey(der); err != nil {
+			key.Private, err = parseSecp256k1SEC1(der, err)
+		}
+	case "PKCS#1":
+		key.Private, err = x509.ParsePKCS1PrivateKey(der)
+	case "PKIX":
+		if key.Public, err = x509.ParsePKIXPublicKey(der); err != nil {
+			key.Public, err = parseSecp256k1PKIX(der, err)
+		}
+	case "PKCS#1 public":
+		key.Format = "PKCS#1"
+		key.Public, err = x509.ParsePKCS1PublicKey(der)
+	case "X.509":
+		if key.Cert, err = x509.ParseCertificate(der); err == nil {
+			key.Public = key.Cert.PublicKey
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid %s key: %v", key.Format, err)
+		return
+	}
+
+	switch k := key.Private.(type) {
+	case nil:
+	case *ecdsa.PrivateKey:
+		key.Public = &k.PublicKey
+	case *rsa.PrivateKey:
+		key.Public = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Public = k.Public()
+	default:
+		err = fmt.Errorf("unsupported private key type: %T", key.Private)
+		return
+	}
+
+	switch key.Public.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		err = fmt.Errorf("unsupported public key type: %T", key.Public)
+	}
+
+	return
+}
+
+// tries the DER encodings of private keys
+func parsePrivateKeyDER(der []byte) (key Key, err error) {
+	for _, format := range []string{"PKCS#8", "SEC1", "PKCS#1"} {
+		if key, err = parseKey(format, der); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// tries the DER encodings of public keys and certificates
+func parsePublicKeyDER(der []byte) (key Key, err error) {
+	for _, format := range []string{"PKIX", "X.509", "PKCS#1 public"} {
+		if key, err = parseKey(format, der); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// RFC 8018 (PKCS#5 v2.1) structures of an ENCRYPTED PRIVATE KEY
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// decrypts a PBES2 ENCRYPTED PRIVATE KEY, as written by openssl pkcs8 -topk8 -v2 and genpkey
+func decryptPKCS8(der []byte, passphrase []byte) (decrypted []byte, err error) {
+
+	var info encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(der, &info); err != nil {
+		err = fmt.Errorf("invalid ENCRYPTED PRIVATE KEY: %v", err)
+		return
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		err = fmt.Errorf("unsupported key encryption %v, only PBES2 is supported", info.Algo.Algorithm)
+		return
+	}
+
+	var params pbes2Params
+	if _, err = asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		err = fmt.Errorf("invalid PBES2 parameters: %v", err)
+		return
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		err = fmt.Errorf("unsupported key derivation %v, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+		return
+	}
+
+	var kdf pbkdf2Params
+	if _, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		err = fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+		return
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA224):
+		prf = sha256.New224
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA384):
+		prf = sha512.New384
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA512):
+		prf = sha512.New
+	default:
+		err = fmt.Errorf("unsupported PBKDF2 PRF %v", kdf.PRF.Algorithm)
+		return
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		err = fmt.Errorf("unsupported key encryption scheme %v", scheme)
+		return
+	}
+
+	// the key length is optional, when present it has to be the one of the cipher
+	if kdf.KeyLength != 0 && kdf.KeyLength != keyLen {
+		err = fmt.Errorf("invalid PBKDF2 key length %d, the encryption scheme needs %d", kdf.KeyLength, keyLen)
+		return
+	}
+	if kdf.Iterations < 1 {
+		err = fmt.Errorf("invalid PBKDF2 iteration count %d", kdf.Iterations)
+		return
+	}
+
+	var iv []byte
+	if _, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		err = fmt.Errorf("invalid encryption scheme IV: %v", err)
+		return
+	}
+
+	block, err := newCipher(pbkdf2(passphrase, kdf.Salt, kdf.Iterations, keyLen, prf))
+	if err != nil {
+		return
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 || len(info.EncryptedData)%block.BlockSize() != 0 {
+		err = errors.New("invalid ENCRYPTED PRIVATE KEY: bad IV or data length")
+		return
+	}
+
+	decrypted = make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, info.EncryptedData)
+
+	// a wrong passphrase almost always shows as bad padding
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		err = ErrIncorrectPassphrase
+		return
+	}
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			err = ErrIncorrectPassphrase
+			return
+		}
+	}
+	decrypted = decrypted[:len(decrypted)-padding]
+
+	return
+}
+
+// RFC 8018 5.2 PBKDF2
+func pbkdf2(password []byte, salt []byte, iterations int, keyLen int, prf func() hash.Hash) []byte {
+
+	mac := hmac.New(prf, password)
+	size := mac.Size()
+	blocks := (keyLen + size - 1) / size
+
+	var key []byte
+	u := make([]byte, 0, size)
+	t := make([]byte, size)
+	for i := 1; i <= blocks; i++ {
+		var index [4]byte
+		binary.BigEndian.PutUint32(index[:], uint32(i))
+
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(index[:])
+		u = mac.Sum(u[:0])
+		copy(t, u)
+
+		for n := 1; n < iterations; n++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
@@ -0,0 +1,49 @@
+package pkcs11wrapper
+
+import (
+	"errors"
+
+	"github.com/miekg/pkcs11"
+)
+
+// the most objects DestroyObjects will search for
+const MaxObjects = 10000
+
+// Destroys all the objects matching template, returns what was destroyed, also when it fails
+// part way. An empty template is refused, it would match every object of the slot.
+func (p11w *Pkcs11Wrapper) DestroyObjects(template []*pkcs11.Attribute) (destroyed []Pkcs11Object, err error) {
+
+	if len(template) == 0 {
+		err = errors.New("refusing to destroy all objects, template is empty")
+		return
+	}
+
+	objects, err := p11w.GetObjects(template, MaxObjects)
+	if err != nil {
+		return
+	}
+
+	for _, o := range objects {
+		err = p11w.Context.DestroyObject(p11w.Session, o.ObjectHandle)
+		if err != nil {
+			return
+		}
+		destroyed = append(destroyed, o)
+	}
+
+	return
+}
+
+// Modifies attributes of an object, like CKA_LABEL or CKA_ID
+func (p11w *Pkcs11Wrapper) SetAttributes(object pkcs11.ObjectHandle, attrs []*pkcs11.Attribute) (err error) {
+
+	err = p11w.Context.SetAttributeValue(p11w.Session, object, attrs)
+	return
+}
+
+// Copies an object, attrs override the attributes of the copy
+func (p11w *Pkcs11Wrapper) CopyObject(object pkcs11.ObjectHandle, attrs []*pkcs11.Attribute) (copy pkcs11.ObjectHandle, err error) {
+
+	copy, err = p11w.Context.CopyObject(p11w.Session, object, attrs)
+	return
+}
@@ -1,14 +1,20 @@
 package pkcs11wrapper
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"strings"
+	"unsafe"
 
 	"github.com/miekg/pkcs11"
 	"github.com/olekukonko/tablewriter"
@@ -19,6 +25,8 @@ type Pkcs11Library struct {
 	Info pkcs11.Info
 }
 
+// Pkcs11Wrapper uses a single session and must not be used from several goroutines at once,
+// see SessionPool for that
 type Pkcs11Wrapper struct {
 
 	// Context
@@ -34,13 +42,13 @@ type Pkcs11Wrapper struct {
 }
 
 type Pkcs11Object struct {
-	ObjectHandle pkcs11.ObjectHandle
+	ObjectHandle pkcs11.ObjectHandle `json:"-"`
 
 	// Some human readable attributes
-	Count     string
-	CKA_CLASS string
-	CKA_LABEL string
-	CKA_ID    string
+	Count     string `json:"count"`
+	CKA_CLASS string `json:"class"`
+	CKA_LABEL string `json:"label"`
+	CKA_ID    string `json:"id"`
 }
 
 // Initialize pkcs11 context
@@ -106,17 +114,15 @@ func (p11w *Pkcs11Wrapper) FindObjects(template []*pkcs11.Attribute, max int) (p
 		return
 	}
 
+	// finishes the search, also when it failed so the session can search again
+	defer func() {
+		if errFinal := p11w.Context.FindObjectsFinal(p11w.Session); err == nil {
+			err = errFinal
+		}
+	}()
+
 	// continue the search, get object handlers
 	p11ObjHandlers, moreThanMax, err = p11w.Context.FindObjects(p11w.Session, max)
-	if err != nil {
-		return
-	}
-
-	// finishes the search
-	err = p11w.Context.FindObjectsFinal(p11w.Session)
-	if err != nil {
-		return
-	}
 
 	return
 }
@@ -144,7 +150,7 @@ func FindSlotByLabel(p *pkcs11.Ctx, slotLabel string) (slot uint, index int, err
 				slotFound = true
 				slot = s
 				index = i
-				fmt.Printf("PKCS11 provider found specified slot label: %s (slot: %d, index: %d)\n", slotLabel, slot, i)
+				fmt.Fprintf(os.Stderr, "PKCS11 provider found specified slot label: %s (slot: %d, index: %d)\n", slotLabel, slot, i)
 				break
 			}
 		}
@@ -158,50 +164,80 @@ func FindSlotByLabel(p *pkcs11.Ctx, slotLabel string) (slot uint, index int, err
 	return
 }
 
+// Returns the human readable attributes of the objects matching template
+func (p11w *Pkcs11Wrapper) GetObjects(template []*pkcs11.Attribute, max int) (objects []Pkcs11Object, err error) {
+
+	// do an object search
+	handles, _, err := p11w.FindObjects(template, max)
+	if err != nil {
+		return
+	}
+
+	for i, k := range handles {
+		al, errGa := p11w.Context.GetAttributeValue(
+			p11w.Session,
+			k,
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+				pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, nil),
+			},
+		)
+		if errGa != nil {
+			err = errGa
+			return
+		}
+
+		class, errClass := decodeULong(al[2].Value)
+		if errClass != nil {
+			err = errClass
+			return
+		}
+		className := "UNKNOWN"
+		if class <= 0xff {
+			className = DecodeCKACLASS(byte(class))
+		}
+
+		objects = append(objects, Pkcs11Object{
+			ObjectHandle: k,
+			Count:        fmt.Sprintf("%03d", i+1),
+			CKA_CLASS:    className,
+			CKA_LABEL:    fmt.Sprintf("%s", al[0].Value),
+			CKA_ID:       fmt.Sprintf("%x", al[1].Value),
+		})
+	}
+
+	return
+}
+
 // List content of slot
 func (p11w *Pkcs11Wrapper) ListObjects(template []*pkcs11.Attribute, max int) {
 
 	// do an object search
-	objects, _, err := p11w.FindObjects(template, max)
+	objects, err := p11w.GetObjects(template, max)
 
 	if err != nil {
 		fmt.Println("Could not find any objects:", err)
 	} else {
+		PrintObjects(objects, max)
+	}
+}
 
-		// prepare table headers
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"COUNT", "CKA_CLASS", "CKA_LABEL", "CKA_ID"})
-		table.SetCaption(true, fmt.Sprintf("Total objects found (max %d): %d", max, len(objects)))
-
-		// populate table data
-		for i, k := range objects {
-			al, err := p11w.Context.GetAttributeValue(
-				p11w.Session,
-				k,
-				[]*pkcs11.Attribute{
-					pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
-					pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
-					pkcs11.NewAttribute(pkcs11.CKA_CLASS, nil),
-				},
-			)
-
-			if err != nil {
-				panic(err)
-			}
-			table.Append(
-				[]string{
-					fmt.Sprintf("%03d", i+1),
-					DecodeCKACLASS(al[2].Value[0]),
-					fmt.Sprintf("%s", al[0].Value),
-					fmt.Sprintf("%x", al[1].Value),
-				},
-			)
-		}
+// Prints objects found by GetObjects as a table
+func PrintObjects(objects []Pkcs11Object, max int) {
 
-		// render table
-		table.Render()
+	// prepare table headers
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"COUNT", "CKA_CLASS", "CKA_LABEL", "CKA_ID"})
+	table.SetCaption(true, fmt.Sprintf("Total objects found (max %d): %d", max, len(objects)))
 
+	// populate table data
+	for _, o := range objects {
+		table.Append([]string{o.Count, o.CKA_CLASS, o.CKA_LABEL, o.CKA_ID})
 	}
+
+	// render table
+	table.Render()
 }
 
 func DecodeCKACLASS(b byte) string {
@@ -223,8 +259,42 @@ func DecodeCKACLASS(b byte) string {
 
 }
 
+// byte order of the CK_ULONG attribute values returned by the library
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Decodes a CK_ULONG attribute value like CKA_CLASS or CKA_KEY_TYPE, which is in native byte
+// order and of the size of the C unsigned long of the library
+func decodeULong(value []byte) (ul uint, err error) {
+
+	switch len(value) {
+	case 4:
+		ul = uint(nativeEndian.Uint32(value))
+	case 8:
+		ul = uint(nativeEndian.Uint64(value))
+	default:
+		err = fmt.Errorf("invalid CK_ULONG attribute value of %d bytes", len(value))
+	}
+
+	return
+}
+
 func (p11w *Pkcs11Wrapper) ImportECKey(ec EcdsaKey) (err error) {
 
+	_, err = p11w.ImportECKeyWithOptions(ec, DefaultECImportOptions)
+	return
+
+}
+
+// ImportECKeyWithOptions imports the key pair with the labels, CKA_ID and attributes of opts,
+// returns the CKA_ID of the objects
+func (p11w *Pkcs11Wrapper) ImportECKeyWithOptions(ec EcdsaKey, opts ImportOptions) (id []byte, err error) {
+
 	if ec.PrivKey == nil {
 		err = errors.New("no key to import")
 		return
@@ -232,57 +302,39 @@ func (p11w *Pkcs11Wrapper) ImportECKey(ec EcdsaKey) (err error) {
 
 	ec.GenSKI()
 
+	id, err = opts.id(ec.SKI)
+	if err != nil {
+		return
+	}
+
 	marshaledOID, err := GetECParamMarshaled(ec.PrivKey.Params().Name)
 	if err != nil {
 		return
 	}
 
 	// pubkey import
-	ecPt := elliptic.Marshal(ec.PubKey.Curve, ec.PubKey.X, ec.PubKey.Y)
-	// Add DER encoding for the CKA_EC_POINT
-	ecPt = append([]byte{0x04, byte(len(ecPt))}, ecPt...)
-
-	keyTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
-		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID),
-
-		pkcs11.NewAttribute(pkcs11.CKA_ID, ec.SKI.Sha256Bytes),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "BCPUB1"),
-		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, ecPt),
+	_, pubAttrs, err := publicKeyAttributes(ec.PubKey)
+	if err != nil {
+		return
 	}
 
+	keyTemplate := append(opts.pubAttributes(pkcs11.CKK_EC, id), pubAttrs...)
+
 	_, err = p11w.Context.CreateObject(p11w.Session, keyTemplate)
 	if err != nil {
 		return
 	} else {
-		fmt.Printf("Object was imported with CKA_LABEL:%s CKA_ID:%x\n", "BCPUB1", ec.SKI.Sha256Bytes)
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.pubLabel(), id)
 	}
 
-	keyTemplate = []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	keyTemplate = append(opts.privAttributes(pkcs11.CKK_EC, id),
 		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID),
-
-		pkcs11.NewAttribute(pkcs11.CKA_ID, ec.SKI.Sha256Bytes),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "BCPRV1"),
-		pkcs11.NewAttribute(pkcs11.CKR_ATTRIBUTE_SENSITIVE, false),
-		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, ec.PrivKey.D.Bytes()),
-
-		// implicitly enable derive for now
-		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
-	}
+	)
 
 	_, err = p11w.Context.CreateObject(p11w.Session, keyTemplate)
 	if err == nil {
-		fmt.Printf("Object was imported with CKA_LABEL:%s CKA_ID:%x\n", "BCPRV1", ec.SKI.Sha256Bytes)
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
 	}
 	return
 
@@ -290,6 +342,15 @@ func (p11w *Pkcs11Wrapper) ImportECKey(ec EcdsaKey) (err error) {
 
 func (p11w *Pkcs11Wrapper) ImportRSAKey(rsa RsaKey) (err error) {
 
+	_, err = p11w.ImportRSAKeyWithOptions(rsa, DefaultRSAImportOptions)
+	return
+
+}
+
+// ImportRSAKeyWithOptions imports the key pair with the labels, CKA_ID and attributes of opts,
+// returns the CKA_ID of the objects
+func (p11w *Pkcs11Wrapper) ImportRSAKeyWithOptions(rsa RsaKey, opts ImportOptions) (id []byte, err error) {
+
 	if rsa.PrivKey == nil {
 		err = errors.New("no key to import")
 		return
@@ -297,57 +358,100 @@ func (p11w *Pkcs11Wrapper) ImportRSAKey(rsa RsaKey) (err error) {
 
 	rsa.GenSKI()
 
+	id, err = opts.id(rsa.SKI)
+	if err != nil {
+		return
+	}
+
 	// pubkey import
 	pubExpBytes := big.NewInt(int64(rsa.PubKey.E)).Bytes()
 
-	keyTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	keyTemplate := append(opts.pubAttributes(pkcs11.CKK_RSA, id),
 		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, rsa.PubKey.N.Bytes()),
 		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, pubExpBytes),
-
-		pkcs11.NewAttribute(pkcs11.CKA_ID, rsa.SKI.Sha256Bytes),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "TLSPUBKEY"),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
-	}
+	)
 
 	_, err = p11w.Context.CreateObject(p11w.Session, keyTemplate)
 	if err != nil {
 		return
 	} else {
-		fmt.Printf("Object was imported with CKA_LABEL:%s CKA_ID:%x\n", "TLSPUBKEY", rsa.SKI.Sha256Bytes)
-	}
-
-	keyTemplate = []*pkcs11.Attribute{
-		// According to: https://www.cryptsoft.com/pkcs11doc/v220/group__SEC__12__1__3__RSA__PRIVATE__KEY__OBJECTS.html
-		// if a particular token stores values only for the CKA_PRIVATE_EXPONENT, CKA_PRIME_1, and CKA_PRIME_2 attributes,
-		// then Cryptoki is certainly able to report values for all the attributes above (since they can all be computed
-		// efficiently from these three values).
-		// However, a Cryptoki implementation may or may not actually do this extra computation.
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.pubLabel(), id)
+	}
+
+	// According to: https://www.cryptsoft.com/pkcs11doc/v220/group__SEC__12__1__3__RSA__PRIVATE__KEY__OBJECTS.html
+	// if a particular token stores values only for the CKA_PRIVATE_EXPONENT, CKA_PRIME_1, and CKA_PRIME_2 attributes,
+	// then Cryptoki is certainly able to report values for all the attributes above (since they can all be computed
+	// efficiently from these three values).
+	// However, a Cryptoki implementation may or may not actually do this extra computation.
+	keyTemplate = append(opts.privAttributes(pkcs11.CKK_RSA, id),
 		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, rsa.PrivKey.N.Bytes()),
 		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, pubExpBytes),
 		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE_EXPONENT, rsa.PrivKey.D.Bytes()),
 		pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, rsa.PrivKey.Primes[0].Bytes()),
 		pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, rsa.PrivKey.Primes[1].Bytes()),
+	)
+
+	_, err = p11w.Context.CreateObject(p11w.Session, keyTemplate)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
+	}
+	return
+
+}
+
+func (p11w *Pkcs11Wrapper) ImportEd25519Key(ed Ed25519Key) (err error) {
+
+	_, err = p11w.ImportEd25519KeyWithOptions(ed, DefaultEd25519ImportOptions)
+	return
+
+}
 
-		pkcs11.NewAttribute(pkcs11.CKA_ID, rsa.SKI.Sha256Bytes),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "TLSPRVKEY"),
-		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+// ImportEd25519KeyWithOptions imports the key pair as CKK_EC_EDWARDS objects with the labels,
+// CKA_ID and attributes of opts, returns the CKA_ID of the objects
+func (p11w *Pkcs11Wrapper) ImportEd25519KeyWithOptions(ed Ed25519Key, opts ImportOptions) (id []byte, err error) {
 
-		// Error: pkcs11: 0x12: CKR_ATTRIBUTE_TYPE_INVALID
-		//pkcs11.NewAttribute(pkcs11.CKR_ATTRIBUTE_SENSITIVE, false),
+	if len(ed.PrivKey) == 0 {
+		err = errors.New("no key to import")
+		return
 	}
 
+	ed.PubKey = ed.PrivKey.Public().(ed25519.PublicKey)
+	ed.GenSKI()
+
+	id, err = opts.id(ed.SKI)
+	if err != nil {
+		return
+	}
+
+	marshaledOID, err := GetECParamMarshaled("Ed25519")
+	if err != nil {
+		return
+	}
+
+	// pubkey import
+	_, pubAttrs, err := publicKeyAttributes(ed.PubKey)
+	if err != nil {
+		return
+	}
+
+	keyTemplate := append(opts.pubAttributes(CKK_EC_EDWARDS, id), pubAttrs...)
+
+	_, err = p11w.Context.CreateObject(p11w.Session, keyTemplate)
+	if err != nil {
+		return
+	} else {
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.pubLabel(), id)
+	}
+
+	// CKA_VALUE is the 32 byte RFC 8032 private key, the seed
+	keyTemplate = append(opts.privAttributes(CKK_EC_EDWARDS, id),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, marshaledOID),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, ed.PrivKey.Seed()),
+	)
+
 	_, err = p11w.Context.CreateObject(p11w.Session, keyTemplate)
 	if err == nil {
-		fmt.Printf("Object was imported with CKA_LABEL:%s CKA_ID:%x\n", "TLSPRVKEY", rsa.SKI.Sha256Bytes)
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
 	}
 	return
 
@@ -453,3 +557,132 @@ func (p11w *Pkcs11Wrapper) VerifySignature(message string, signature string, key
 
 	return
 }
+
+/* Advanced form of verifying a signature, specify mechanism. Assume data is already prepared for mechanism (not altered in this function) */
+func (p11w *Pkcs11Wrapper) VerifySignatureAdvanced(data []byte, signature string, key pkcs11.ObjectHandle, mechanism *pkcs11.Mechanism) (verified bool, err error) {
+
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return
+	}
+
+	err = p11w.Context.VerifyInit(p11w.Session, []*pkcs11.Mechanism{mechanism}, key)
+	if err != nil {
+		return
+	}
+
+	// if there is an error, we can assume signature was invalid
+	errSig := p11w.Context.Verify(p11w.Session, data, signatureBytes)
+	if errSig == nil {
+		verified = true
+	}
+
+	return
+}
+
+// Returns the value of CKA_KEY_TYPE of an object
+func (p11w *Pkcs11Wrapper) GetKeyType(object pkcs11.ObjectHandle) (keyType uint, err error) {
+
+	al, err := p11w.Context.GetAttributeValue(
+		p11w.Session,
+		object,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)},
+	)
+	if err != nil {
+		return
+	}
+	if len(al) != 1 || len(al[0].Value) == 0 {
+		err = errors.New("object has no CKA_KEY_TYPE")
+		return
+	}
+
+	keyType, err = decodeULong(al[0].Value)
+
+	return
+}
+
+// Reads the public key out of a CKO_PUBLIC_KEY object
+func (p11w *Pkcs11Wrapper) GetPublicKey(object pkcs11.ObjectHandle) (pubKey crypto.PublicKey, err error) {
+
+	keyType, err := p11w.GetKeyType(object)
+	if err != nil {
+		return
+	}
+
+	switch keyType {
+
+	case pkcs11.CKK_RSA:
+		al, errGa := p11w.Context.GetAttributeValue(
+			p11w.Session,
+			object,
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+				pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+			},
+		)
+		if errGa != nil {
+			err = errGa
+			return
+		}
+		pubKey = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(al[0].Value),
+			E: int(new(big.Int).SetBytes(al[1].Value).Int64()),
+		}
+
+	case pkcs11.CKK_EC:
+		al, errGa := p11w.Context.GetAttributeValue(
+			p11w.Session,
+			object,
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+				pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+			},
+		)
+		if errGa != nil {
+			err = errGa
+			return
+		}
+		curve, errCurve := GetECCurve(al[0].Value)
+		if errCurve != nil {
+			err = errCurve
+			return
+		}
+
+		x, y := elliptic.Unmarshal(curve, decodeECPoint(al[1].Value))
+		if x == nil {
+			err = errors.New("could not decode CKA_EC_POINT")
+			return
+		}
+		pubKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	case CKK_EC_EDWARDS:
+		al, errGa := p11w.Context.GetAttributeValue(
+			p11w.Session,
+			object,
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+				pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+			},
+		)
+		if errGa != nil {
+			err = errGa
+			return
+		}
+		if !isEd25519Params(al[0].Value) {
+			err = fmt.Errorf("Unsupported CKA_EC_PARAMS: %x", al[0].Value)
+			return
+		}
+
+		point := decodeECPoint(al[1].Value)
+		if len(point) != ed25519.PublicKeySize {
+			err = errors.New("could not decode CKA_EC_POINT")
+			return
+		}
+		pubKey = ed25519.PublicKey(point)
+
+	default:
+		err = fmt.Errorf("unsupported key type: %d", keyType)
+	}
+
+	return
+}
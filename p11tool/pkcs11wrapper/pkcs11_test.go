@@ -0,0 +1,34 @@
+package pkcs11wrapper
+
+import (
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestDecodeULong(t *testing.T) {
+	// encoded the way the library returns them, as a native CK_ULONG
+	for _, expected := range []uint{
+		pkcs11.CKO_SECRET_KEY,
+		pkcs11.CKK_EC,
+		pkcs11.CKK_AES,
+		pkcs11.CKK_VENDOR_DEFINED | 0x104,
+		0x1ff,
+	} {
+		value := pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, expected).Value
+		ul, err := decodeULong(value)
+		if err != nil {
+			t.Errorf("%#x: Error: %v", expected, err)
+			continue
+		}
+		if ul != expected {
+			t.Errorf("expected %#x got %#x from %x", expected, ul, value)
+		}
+	}
+
+	for _, invalid := range [][]byte{nil, {3}, {3, 0, 0}} {
+		if _, err := decodeULong(invalid); err == nil {
+			t.Errorf("%x: expected an error", invalid)
+		}
+	}
+}
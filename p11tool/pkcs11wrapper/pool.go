@@ -0,0 +1,363 @@
+package pkcs11wrapper
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// returned by SessionPool.Do once the pool is closed
+var ErrPoolClosed = errors.New("session pool is closed")
+
+// returned by do while the pool has no context, after a reconnect failed
+var errDisconnected = errors.New("session pool is disconnected")
+
+// SessionPool shares a logged in pkcs11 context between goroutines. Pkcs11Wrapper uses a
+// single session and must not be used concurrently, as an operation like FindObjects is a
+// sequence of calls on its session. The pool instead gives each operation a session of its
+// own: Do runs a function with a Pkcs11Wrapper holding a session checked out of the pool,
+// so all the methods of Pkcs11Wrapper can be used from many goroutines.
+//
+// The user is logged in once, on a session the pool keeps open so the login lasts. When a
+// session becomes invalid it is discarded, when the device is removed or its login is lost
+// the pool reconnects: it finalizes the library, initializes it again, finds the slot by
+// its label and logs in. Either way the function passed to Do is run a second time. When
+// the reconnection fails, as long as the device is away, Do returns its error and the next
+// call tries to connect again.
+type SessionPool struct {
+	library   Pkcs11Library
+	slotLabel string
+	pin       string
+
+	// held for reading by Do, for writing by reconnect and Close. ctx is nil while the
+	// pool is disconnected.
+	mu           sync.RWMutex
+	ctx          *pkcs11.Ctx
+	slot         uint
+	loginSession pkcs11.SessionHandle
+	generation   uint64
+	closed       bool
+
+	// limits the sessions in use to the size of the pool, idle holds the ones not in use
+	tokens chan struct{}
+	idle   chan pooledSession
+}
+
+type pooledSession struct {
+	handle     pkcs11.SessionHandle
+	generation uint64
+}
+
+// NewSessionPool loads the library at libPath, logs in the token labeled slotLabel with pin
+// and returns a pool of at most size sessions
+func NewSessionPool(libPath string, slotLabel string, pin string, size int) (pool *SessionPool, err error) {
+
+	if size < 1 {
+		err = fmt.Errorf("invalid session pool size: %d", size)
+		return
+	}
+
+	p := &SessionPool{
+		library:   Pkcs11Library{Path: libPath},
+		slotLabel: slotLabel,
+		pin:       pin,
+		tokens:    make(chan struct{}, size),
+		idle:      make(chan pooledSession, size),
+	}
+
+	if err = p.connect(); err != nil {
+		return
+	}
+
+	pool = p
+	return
+}
+
+// initializes the library, opens the login session and logs in
+func (p *SessionPool) connect() (err error) {
+
+	p11w := Pkcs11Wrapper{Library: p.library, SlotLabel: p.slotLabel, SlotPin: p.pin}
+
+	if err = p11w.InitContext(); err != nil {
+		if p11w.Context != nil {
+			p11w.Context.Destroy()
+		}
+		return
+	}
+
+	slot, _, err := FindSlotByLabel(p11w.Context, p.slotLabel)
+	if err == nil {
+		p11w.Session, err = p11w.Context.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	}
+	if err == nil {
+		err = p11w.Login()
+	}
+	if err != nil {
+		p11w.Context.Finalize()
+		p11w.Context.Destroy()
+		return
+	}
+
+	p.library = p11w.Library
+	p.ctx = p11w.Context
+	p.slot = slot
+	p.loginSession = p11w.Session
+	p.generation++
+
+	return
+}
+
+// closes every session and finalizes the library, unless already disconnected
+func (p *SessionPool) disconnect() {
+
+	if p.ctx == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-p.idle:
+			continue
+		default:
+		}
+		break
+	}
+
+	p.ctx.Logout(p.loginSession)
+	p.ctx.CloseAllSessions(p.slot)
+	p.ctx.Finalize()
+	p.ctx.Destroy()
+	p.ctx = nil
+}
+
+// reconnects unless another goroutine already did since generation, connects when the
+// pool is disconnected
+func (p *SessionPool) reconnect(generation uint64) (err error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		err = ErrPoolClosed
+		return
+	}
+	if p.ctx != nil && p.generation != generation {
+		return
+	}
+
+	p.disconnect()
+	err = p.connect()
+
+	return
+}
+
+// Close logs out, closes all the sessions and finalizes the library, once the operations
+// in progress are done
+func (p *SessionPool) Close() {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	p.disconnect()
+}
+
+// Do runs f with a Pkcs11Wrapper holding a session of the pool, which f must not keep. f
+// runs a second time, with a new session, when it fails because its session became invalid
+// or the device was removed, so it should be safe to repeat.
+func (p *SessionPool) Do(f func(p11w *Pkcs11Wrapper) error) (err error) {
+
+	generation, err := p.do(f)
+
+	switch {
+	case isSessionError(err):
+		_, err = p.do(f)
+	case err == errDisconnected || isDeviceError(err):
+		if err = p.reconnect(generation); err != nil {
+			return
+		}
+		_, err = p.do(f)
+	}
+
+	return
+}
+
+// runs f with a session of the pool, returns the generation of the connection it used
+func (p *SessionPool) do(f func(p11w *Pkcs11Wrapper) error) (generation uint64, err error) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		err = ErrPoolClosed
+		return
+	}
+	generation = p.generation
+
+	if p.ctx == nil {
+		err = errDisconnected
+		return
+	}
+
+	session, err := p.get()
+	if err != nil {
+		return
+	}
+
+	err = f(&Pkcs11Wrapper{
+		Library:   p.library,
+		Context:   p.ctx,
+		SlotLabel: p.slotLabel,
+		Session:   session.handle,
+		SlotPin:   p.pin,
+	})
+
+	if isSessionError(err) || isDeviceError(err) || isOperationActive(err) {
+		p.discard(session)
+	} else {
+		p.put(session)
+	}
+
+	return
+}
+
+// checks out an idle session, or opens one, waiting while all the sessions are in use
+func (p *SessionPool) get() (session pooledSession, err error) {
+
+	p.tokens <- struct{}{}
+
+	for {
+		select {
+		case session = <-p.idle:
+			if session.generation == p.generation {
+				return
+			}
+			// from before a reconnect, already closed
+			continue
+		default:
+		}
+		break
+	}
+
+	handle, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		<-p.tokens
+		return
+	}
+
+	session = pooledSession{handle: handle, generation: p.generation}
+	return
+}
+
+// returns session to the pool
+func (p *SessionPool) put(session pooledSession) {
+	p.idle <- session
+	<-p.tokens
+}
+
+// closes session instead of returning it to the pool
+func (p *SessionPool) discard(session pooledSession) {
+	p.ctx.CloseSession(session.handle)
+	<-p.tokens
+}
+
+// reports whether err means the session can't be used anymore
+func isSessionError(err error) bool {
+	var rv pkcs11.Error
+	if !errors.As(err, &rv) {
+		return false
+	}
+	switch rv {
+	case pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED:
+		return true
+	}
+	return false
+}
+
+// reports whether err means an operation was left active on the session, which can't be
+// handed out again then
+func isOperationActive(err error) bool {
+	var rv pkcs11.Error
+	return errors.As(err, &rv) && rv == pkcs11.CKR_OPERATION_ACTIVE
+}
+
+// reports whether err means the library has to be initialized again
+func isDeviceError(err error) bool {
+	var rv pkcs11.Error
+	if !errors.As(err, &rv) {
+		return false
+	}
+	switch rv {
+	case pkcs11.CKR_DEVICE_REMOVED, pkcs11.CKR_DEVICE_ERROR, pkcs11.CKR_TOKEN_NOT_PRESENT,
+		pkcs11.CKR_TOKEN_NOT_RECOGNIZED, pkcs11.CKR_SLOT_ID_INVALID,
+		pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED, pkcs11.CKR_USER_NOT_LOGGED_IN:
+		return true
+	}
+	return false
+}
+
+// PoolSigner is a private key of the HSM usable as a crypto.Signer and crypto.Decrypter from
+// many goroutines. Each operation finds the key again on a session of the pool, so it keeps
+// working after a reconnect.
+type PoolSigner struct {
+	pool  *SessionPool
+	label string
+	id    []byte
+	pub   crypto.PublicKey
+}
+
+// NewSigner returns the PoolSigner of the private key with label and/or id, see
+// Pkcs11Wrapper.NewSigner
+func (p *SessionPool) NewSigner(label string, id []byte) (signer *PoolSigner, err error) {
+
+	err = p.Do(func(p11w *Pkcs11Wrapper) (errDo error) {
+		s, errDo := p11w.NewSigner(label, id)
+		if errDo == nil {
+			signer = &PoolSigner{pool: p, label: label, id: id, pub: s.Public()}
+		}
+		return
+	})
+
+	return
+}
+
+// Public returns the public key of the signer
+func (s *PoolSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs digest like Signer.Sign
+func (s *PoolSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+
+	err = s.pool.Do(func(p11w *Pkcs11Wrapper) (errDo error) {
+		signer, errDo := p11w.NewSigner(s.label, s.id)
+		if errDo == nil {
+			signature, errDo = signer.Sign(rand, digest, opts)
+		}
+		return
+	})
+
+	return
+}
+
+// Decrypt decrypts ciphertext like Signer.Decrypt
+func (s *PoolSigner) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error) {
+
+	err = s.pool.Do(func(p11w *Pkcs11Wrapper) (errDo error) {
+		signer, errDo := p11w.NewSigner(s.label, s.id)
+		if errDo == nil {
+			plaintext, errDo = signer.Decrypt(rand, ciphertext, opts)
+		}
+		return
+	})
+
+	return
+}
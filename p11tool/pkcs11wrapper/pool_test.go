@@ -0,0 +1,99 @@
+package pkcs11wrapper
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// returns a pool in the state a failed reconnect leaves it in: no context, and a library
+// which can't be loaded, like while the device is removed
+func newDisconnectedPool(t *testing.T) *SessionPool {
+	return &SessionPool{
+		library:    Pkcs11Library{Path: filepath.Join(t.TempDir(), "missing.so")},
+		slotLabel:  "someLabel",
+		pin:        "somePin",
+		generation: 1,
+		tokens:     make(chan struct{}, 2),
+		idle:       make(chan pooledSession, 2),
+	}
+}
+
+func TestSessionPoolFailedReconnect(t *testing.T) {
+	p := newDisconnectedPool(t)
+
+	if err := p.reconnect(p.generation); err == nil {
+		t.Fatal("expected the reconnect to fail")
+	}
+	if p.ctx != nil {
+		t.Fatal("expected no context after a failed reconnect")
+	}
+
+	// each call tries to connect again and returns its error instead of using a dead context
+	for i := 0; i < 2; i++ {
+		called := false
+		err := p.Do(func(p11w *Pkcs11Wrapper) error {
+			called = true
+			return nil
+		})
+		if err == nil || err == errDisconnected {
+			t.Fatalf("expected the connect error, got %v", err)
+		}
+		if called {
+			t.Fatal("f was run without a context")
+		}
+		if len(p.tokens) != 0 {
+			t.Fatalf("%d sessions left checked out", len(p.tokens))
+		}
+	}
+
+	p.Close()
+	p.Close()
+
+	if err := p.Do(func(p11w *Pkcs11Wrapper) error { return nil }); err != ErrPoolClosed {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+	if err := p.reconnect(p.generation); err != ErrPoolClosed {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestNewSessionPool(t *testing.T) {
+	if _, err := NewSessionPool("/nonexistent/libpkcs11.so", "someLabel", "somePin", 0); err == nil {
+		t.Fatal("expected an error for size 0")
+	}
+	if _, err := NewSessionPool(filepath.Join(t.TempDir(), "missing.so"), "someLabel", "somePin", 2); err == nil {
+		t.Fatal("expected an error for a missing library")
+	}
+}
+
+func TestSessionPoolErrors(t *testing.T) {
+	tests := []struct {
+		err             error
+		session         bool
+		device          bool
+		operationActive bool
+	}{
+		{err: nil},
+		{err: fmt.Errorf("not a pkcs11 error")},
+		{err: pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)},
+		{err: pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID), session: true},
+		{err: fmt.Errorf("sign: %w", pkcs11.Error(pkcs11.CKR_SESSION_CLOSED)), session: true},
+		{err: pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED), device: true},
+		{err: fmt.Errorf("sign: %w", pkcs11.Error(pkcs11.CKR_TOKEN_NOT_PRESENT)), device: true},
+		{err: pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE), operationActive: true},
+	}
+	for _, tt := range tests {
+		if got := isSessionError(tt.err); got != tt.session {
+			t.Errorf("isSessionError(%v) = %v", tt.err, got)
+		}
+		if got := isDeviceError(tt.err); got != tt.device {
+			t.Errorf("isDeviceError(%v) = %v", tt.err, got)
+		}
+		if got := isOperationActive(tt.err); got != tt.operationActive {
+			t.Errorf("isOperationActive(%v) = %v", tt.err, got)
+		}
+	}
+}
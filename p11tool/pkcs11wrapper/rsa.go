@@ -7,11 +7,10 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"fmt"
 
 	"crypto/rsa"
 	"crypto/sha512"
-	"encoding/pem"
-	"io/ioutil"
 )
 
 type RsaKey struct {
@@ -62,19 +61,24 @@ func (k *RsaKey) Generate(bits int) (err error) {
 
 func (k *RsaKey) ImportPrivKeyFromFile(file string) (err error) {
 
-	keyFile, err := ioutil.ReadFile(file)
+	key, err := LoadKeyFile(file, nil)
 	if err != nil {
 		return
 	}
 
-	keyBlock, _ := pem.Decode(keyFile)
-	k.PrivKey, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	r, err := key.RsaKey()
 	if err != nil {
 		return
 	}
+	if r.PrivKey == nil {
+		err = fmt.Errorf("%s: not a private key", file)
+		return
+	}
+
+	k.PrivKey = r.PrivKey
 
 	// store public key
-	k.PubKey = &k.PrivKey.PublicKey
+	k.PubKey = r.PubKey
 
 	return
 }
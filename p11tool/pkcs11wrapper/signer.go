@@ -0,0 +1,275 @@
+package pkcs11wrapper
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Signer is a private key of the HSM usable as a crypto.Signer, and as a crypto.Decrypter
+// for RSA keys. Like the rest of Pkcs11Wrapper it uses the session of the wrapper, which
+// must not be used concurrently.
+type Signer struct {
+	p11w    *Pkcs11Wrapper
+	key     pkcs11.ObjectHandle
+	keyType uint
+	pub     crypto.PublicKey
+}
+
+// NewSigner returns the Signer of the private key with label and/or id. The public key is
+// read from the public key object with the same label and/or id, or from the private key
+// object itself for RSA.
+func (p11w *Pkcs11Wrapper) NewSigner(label string, id []byte) (signer *Signer, err error) {
+
+	key, err := p11w.findKeyObject(pkcs11.CKO_PRIVATE_KEY, label, id)
+	if err != nil {
+		return
+	}
+
+	keyType, err := p11w.GetKeyType(key)
+	if err != nil {
+		return
+	}
+
+	var pub crypto.PublicKey
+	pubKey, errFind := p11w.findKeyObject(pkcs11.CKO_PUBLIC_KEY, label, id)
+	if errFind == nil {
+		pub, err = p11w.GetPublicKey(pubKey)
+	} else if keyType == pkcs11.CKK_RSA {
+		pub, err = p11w.GetPublicKey(key)
+	} else {
+		err = fmt.Errorf("public key not found: %v", errFind)
+	}
+	if err != nil {
+		return
+	}
+
+	signer = &Signer{p11w: p11w, key: key, keyType: keyType, pub: pub}
+	return
+}
+
+// returns the single object of class with label and/or id
+func (p11w *Pkcs11Wrapper) findKeyObject(class uint, label string, id []byte) (object pkcs11.ObjectHandle, err error) {
+
+	if label == "" && len(id) == 0 {
+		err = errors.New("a label or an id is required to find a key")
+		return
+	}
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+	if len(id) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+
+	objects, _, err := p11w.FindObjects(template, 2)
+	if err != nil {
+		return
+	}
+
+	switch len(objects) {
+	case 0:
+		err = fmt.Errorf("no %s found with CKA_LABEL:%s CKA_ID:%x", DecodeCKACLASS(byte(class)), label, id)
+	case 1:
+		object = objects[0]
+	default:
+		err = fmt.Errorf("more than one %s found with CKA_LABEL:%s CKA_ID:%x", DecodeCKACLASS(byte(class)), label, id)
+	}
+
+	return
+}
+
+// Public returns the public key of the signer
+func (s *Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs digest with CKM_ECDSA, CKM_RSA_PKCS or, when opts is *rsa.PSSOptions,
+// CKM_RSA_PKCS_PSS. ECDSA signatures are returned ASN.1 DER encoded like ecdsa.SignASN1.
+// Ed25519 keys sign with CKM_EDDSA, like ed25519.PrivateKey digest is the message itself
+// and opts must not have a hash.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+
+	hash := crypto.Hash(0)
+	if opts != nil {
+		hash = opts.HashFunc()
+	}
+	if hash != 0 && len(digest) != hash.Size() {
+		err = fmt.Errorf("digest length %d does not match hash %v", len(digest), hash)
+		return
+	}
+
+	switch s.keyType {
+
+	case pkcs11.CKK_EC:
+		raw, errSign := s.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+		if errSign != nil {
+			err = errSign
+			return
+		}
+		signature, err = ecdsaRawToDER(raw)
+
+	case CKK_EC_EDWARDS:
+		if hash != 0 {
+			err = fmt.Errorf("Ed25519 signs the unhashed message, not a %v digest", hash)
+			return
+		}
+		signature, err = s.sign(pkcs11.NewMechanism(CKM_EDDSA, nil), digest)
+
+	case pkcs11.CKK_RSA:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			mechanism, errPss := pssMechanism(hash, pss.SaltLength)
+			if errPss != nil {
+				err = errPss
+				return
+			}
+			signature, err = s.sign(mechanism, digest)
+			return
+		}
+
+		// CKM_RSA_PKCS only pads, the DigestInfo has to be added like rsa.SignPKCS1v15 does
+		prefix, found := digestInfoPrefixes[hash]
+		if !found {
+			err = fmt.Errorf("unsupported hash for RSA PKCS#1 v1.5: %v", hash)
+			return
+		}
+		signature, err = s.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), append(append([]byte{}, prefix...), digest...))
+
+	default:
+		err = fmt.Errorf("unsupported key type: %d", s.keyType)
+	}
+
+	return
+}
+
+// Decrypt decrypts ciphertext with CKM_RSA_PKCS_OAEP when opts is *rsa.OAEPOptions,
+// CKM_RSA_PKCS otherwise
+func (s *Signer) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error) {
+
+	if s.keyType != pkcs11.CKK_RSA {
+		err = errors.New("decrypt is only supported with RSA keys")
+		return
+	}
+
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+
+	switch o := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+	case *rsa.OAEPOptions:
+		hashMech, mgf, errHash := hashMechanisms(o.Hash)
+		if errHash != nil {
+			err = errHash
+			return
+		}
+		params := pkcs11.NewOAEPParams(hashMech, mgf, pkcs11.CKZ_DATA_SPECIFIED, o.Label)
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)
+	default:
+		err = fmt.Errorf("unsupported decrypter options: %T", opts)
+		return
+	}
+
+	err = s.p11w.Context.DecryptInit(s.p11w.Session, []*pkcs11.Mechanism{mechanism}, s.key)
+	if err != nil {
+		return
+	}
+
+	plaintext, err = s.p11w.Context.Decrypt(s.p11w.Session, ciphertext)
+	return
+}
+
+// signs data with the key of s
+func (s *Signer) sign(mechanism *pkcs11.Mechanism, data []byte) (signature []byte, err error) {
+
+	err = s.p11w.Context.SignInit(s.p11w.Session, []*pkcs11.Mechanism{mechanism}, s.key)
+	if err != nil {
+		return
+	}
+
+	signature, err = s.p11w.Context.Sign(s.p11w.Session, data)
+	return
+}
+
+// converts the r||s output of CKM_ECDSA to an ASN.1 DER ECDSA-Sig-Value
+func ecdsaRawToDER(raw []byte) (der []byte, err error) {
+
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		err = fmt.Errorf("invalid CKM_ECDSA signature length: %d", len(raw))
+		return
+	}
+
+	half := len(raw) / 2
+	der, err = asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		new(big.Int).SetBytes(raw[:half]),
+		new(big.Int).SetBytes(raw[half:]),
+	})
+	return
+}
+
+// returns the CKM_RSA_PKCS_PSS mechanism for hash and a rsa.PSSOptions salt length
+func pssMechanism(hash crypto.Hash, saltLength int) (mechanism *pkcs11.Mechanism, err error) {
+
+	hashMech, mgf, err := hashMechanisms(hash)
+	if err != nil {
+		return
+	}
+
+	// the HSM can't sign with the maximum salt length rsa.PSSSaltLengthAuto would use,
+	// the length of the hash is what TLS 1.3 requires anyway
+	switch {
+	case saltLength == rsa.PSSSaltLengthAuto, saltLength == rsa.PSSSaltLengthEqualsHash:
+		saltLength = hash.Size()
+	case saltLength < 0:
+		err = fmt.Errorf("invalid PSS salt length: %d", saltLength)
+		return
+	}
+
+	mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(hashMech, mgf, uint(saltLength)))
+	return
+}
+
+// returns the PKCS#11 hash mechanism and MGF1 function of hash
+func hashMechanisms(hash crypto.Hash) (hashMech uint, mgf uint, err error) {
+
+	switch hash {
+	case crypto.SHA1:
+		hashMech, mgf = pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1
+	case crypto.SHA224:
+		hashMech, mgf = pkcs11.CKM_SHA224, pkcs11.CKG_MGF1_SHA224
+	case crypto.SHA256:
+		hashMech, mgf = pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256
+	case crypto.SHA384:
+		hashMech, mgf = pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384
+	case crypto.SHA512:
+		hashMech, mgf = pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512
+	default:
+		err = fmt.Errorf("unsupported hash: %v", hash)
+	}
+
+	return
+}
+
+// DER encoded DigestInfo prefixes, as in crypto/rsa, 0 signs the data as given
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	0:             {},
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// make sure Signer satisfies the interfaces
+var (
+	_ crypto.Signer    = (*Signer)(nil)
+	_ crypto.Decrypter = (*Signer)(nil)
+)
@@ -0,0 +1,123 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestPSSMechanism(t *testing.T) {
+	tests := []struct {
+		hash       crypto.Hash
+		saltLength int
+		hashMech   uint
+		mgf        uint
+		expected   int
+		invalid    bool
+	}{
+		{hash: crypto.SHA256, saltLength: rsa.PSSSaltLengthAuto, hashMech: pkcs11.CKM_SHA256, mgf: pkcs11.CKG_MGF1_SHA256, expected: 32},
+		{hash: crypto.SHA384, saltLength: rsa.PSSSaltLengthEqualsHash, hashMech: pkcs11.CKM_SHA384, mgf: pkcs11.CKG_MGF1_SHA384, expected: 48},
+		{hash: crypto.SHA512, saltLength: rsa.PSSSaltLengthAuto, hashMech: pkcs11.CKM_SHA512, mgf: pkcs11.CKG_MGF1_SHA512, expected: 64},
+		{hash: crypto.SHA1, saltLength: 10, hashMech: pkcs11.CKM_SHA_1, mgf: pkcs11.CKG_MGF1_SHA1, expected: 10},
+		// a salt length of 0 is rsa.PSSSaltLengthAuto
+		{hash: crypto.SHA224, saltLength: 0, hashMech: pkcs11.CKM_SHA224, mgf: pkcs11.CKG_MGF1_SHA224, expected: 28},
+		{hash: crypto.SHA256, saltLength: -2, invalid: true},
+		{hash: crypto.MD5, saltLength: rsa.PSSSaltLengthAuto, invalid: true},
+		{hash: 0, saltLength: rsa.PSSSaltLengthAuto, invalid: true},
+	}
+	for _, tt := range tests {
+		mechanism, err := pssMechanism(tt.hash, tt.saltLength)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%v salt %d: expected an error", tt.hash, tt.saltLength)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v salt %d: Error: %v", tt.hash, tt.saltLength, err)
+			continue
+		}
+		params := pkcs11.NewPSSParams(tt.hashMech, tt.mgf, uint(tt.expected))
+		if mechanism.Mechanism != pkcs11.CKM_RSA_PKCS_PSS || !bytes.Equal(mechanism.Parameter, params) {
+			t.Errorf("%v salt %d: unexpected mechanism %d %x", tt.hash, tt.saltLength, mechanism.Mechanism, mechanism.Parameter)
+		}
+	}
+}
+
+func TestDigestInfoPrefixes(t *testing.T) {
+	oids := map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+		crypto.SHA224: {2, 16, 840, 1, 101, 3, 4, 2, 4},
+		crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+		crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+		crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	}
+	for hash, oid := range oids {
+		digest := bytes.Repeat([]byte{0xab}, hash.Size())
+		expected, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			Digest    []byte
+		}{pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, digest})
+		if err != nil {
+			t.Fatal("Error:", err)
+		}
+		if got := append(append([]byte{}, digestInfoPrefixes[hash]...), digest...); !bytes.Equal(got, expected) {
+			t.Errorf("%v: expected DigestInfo %x got %x", hash, expected, got)
+		}
+	}
+	if len(digestInfoPrefixes[0]) != 0 {
+		t.Error("a signature without hash has no DigestInfo")
+	}
+
+	// CKM_RSA_PKCS pads the DigestInfo like this before the private key operation, the
+	// result has to verify as a rsa.SignPKCS1v15 signature
+	key, err := LoadKeyFile("testdata/rsa_pkcs1.pem", nil)
+	if err != nil {
+		t.Fatal("Error:", err)
+	}
+	priv := key.Private.(*rsa.PrivateKey)
+	digest := sha256.Sum256([]byte("FooBar"))
+	info := append(append([]byte{}, digestInfoPrefixes[crypto.SHA256]...), digest[:]...)
+	em := make([]byte, priv.Size())
+	em[1] = 1
+	for i := 2; i < len(em)-len(info)-1; i++ {
+		em[i] = 0xff
+	}
+	copy(em[len(em)-len(info):], info)
+	signature := new(big.Int).Exp(new(big.Int).SetBytes(em), priv.D, priv.N).FillBytes(make([]byte, priv.Size()))
+	if err = rsa.VerifyPKCS1v15(&priv.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Error("Error:", err)
+	}
+}
+
+func TestSignerSignErrors(t *testing.T) {
+	digest := sha256.Sum256([]byte("FooBar"))
+	tests := []struct {
+		name    string
+		keyType uint
+		digest  []byte
+		opts    crypto.SignerOpts
+	}{
+		{name: "digest too short", keyType: pkcs11.CKK_RSA, digest: digest[:20], opts: crypto.SHA256},
+		{name: "digest too long", keyType: pkcs11.CKK_EC, digest: append(digest[:], 0), opts: crypto.SHA256},
+		{name: "PKCS#1 v1.5 unsupported hash", keyType: pkcs11.CKK_RSA, digest: digest[:16], opts: crypto.MD5},
+		{name: "PSS unsupported hash", keyType: pkcs11.CKK_RSA, digest: digest[:16], opts: &rsa.PSSOptions{Hash: crypto.MD5}},
+		{name: "PSS negative salt", keyType: pkcs11.CKK_RSA, digest: digest[:], opts: &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: -5}},
+		{name: "Ed25519 digest", keyType: CKK_EC_EDWARDS, digest: digest[:], opts: crypto.SHA256},
+		{name: "unsupported key type", keyType: pkcs11.CKK_DSA, digest: digest[:], opts: crypto.SHA256},
+	}
+	// all of them fail before the HSM is used
+	for _, tt := range tests {
+		s := &Signer{keyType: tt.keyType}
+		if _, err := s.Sign(nil, tt.digest, tt.opts); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
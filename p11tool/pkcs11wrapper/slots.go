@@ -0,0 +1,364 @@
+package pkcs11wrapper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Slot is a slot of the library, with the info of its token when present
+type Slot struct {
+	ID           uint       `json:"id"`
+	Description  string     `json:"description"`
+	Manufacturer string     `json:"manufacturer"`
+	Flags        []string   `json:"flags"`
+	Token        *TokenInfo `json:"token,omitempty"`
+}
+
+// TokenInfo is the human readable CK_TOKEN_INFO of a token
+type TokenInfo struct {
+	Label              string   `json:"label"`
+	Manufacturer       string   `json:"manufacturer"`
+	Model              string   `json:"model"`
+	SerialNumber       string   `json:"serialNumber"`
+	Flags              []string `json:"flags"`
+	Initialized        bool     `json:"initialized"`
+	SessionCount       uint     `json:"sessionCount"`
+	MaxSessionCount    uint     `json:"maxSessionCount"`
+	MinPinLen          uint     `json:"minPinLen"`
+	MaxPinLen          uint     `json:"maxPinLen"`
+	TotalPublicMemory  uint     `json:"totalPublicMemory"`
+	FreePublicMemory   uint     `json:"freePublicMemory"`
+	TotalPrivateMemory uint     `json:"totalPrivateMemory"`
+	FreePrivateMemory  uint     `json:"freePrivateMemory"`
+	HardwareVersion    string   `json:"hardwareVersion"`
+	FirmwareVersion    string   `json:"firmwareVersion"`
+}
+
+// Mechanism is a mechanism supported by a token
+type Mechanism struct {
+	ID         uint     `json:"id"`
+	Name       string   `json:"name"`
+	MinKeySize uint     `json:"minKeySize"`
+	MaxKeySize uint     `json:"maxKeySize"`
+	Flags      []string `json:"flags"`
+}
+
+type flagName struct {
+	flag uint
+	name string
+}
+
+var slotFlagNames = []flagName{
+	{pkcs11.CKF_TOKEN_PRESENT, "TOKEN_PRESENT"},
+	{pkcs11.CKF_REMOVABLE_DEVICE, "REMOVABLE_DEVICE"},
+	{pkcs11.CKF_HW_SLOT, "HW_SLOT"},
+}
+
+var tokenFlagNames = []flagName{
+	{pkcs11.CKF_RNG, "RNG"},
+	{pkcs11.CKF_WRITE_PROTECTED, "WRITE_PROTECTED"},
+	{pkcs11.CKF_LOGIN_REQUIRED, "LOGIN_REQUIRED"},
+	{pkcs11.CKF_USER_PIN_INITIALIZED, "USER_PIN_INITIALIZED"},
+	{pkcs11.CKF_RESTORE_KEY_NOT_NEEDED, "RESTORE_KEY_NOT_NEEDED"},
+	{pkcs11.CKF_CLOCK_ON_TOKEN, "CLOCK_ON_TOKEN"},
+	{pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH, "PROTECTED_AUTHENTICATION_PATH"},
+	{pkcs11.CKF_DUAL_CRYPTO_OPERATIONS, "DUAL_CRYPTO_OPERATIONS"},
+	{pkcs11.CKF_TOKEN_INITIALIZED, "TOKEN_INITIALIZED"},
+	{pkcs11.CKF_SECONDARY_AUTHENTICATION, "SECONDARY_AUTHENTICATION"},
+	{pkcs11.CKF_USER_PIN_COUNT_LOW, "USER_PIN_COUNT_LOW"},
+	{pkcs11.CKF_USER_PIN_FINAL_TRY, "USER_PIN_FINAL_TRY"},
+	{pkcs11.CKF_USER_PIN_LOCKED, "USER_PIN_LOCKED"},
+	{pkcs11.CKF_USER_PIN_TO_BE_CHANGED, "USER_PIN_TO_BE_CHANGED"},
+	{pkcs11.CKF_SO_PIN_COUNT_LOW, "SO_PIN_COUNT_LOW"},
+	{pkcs11.CKF_SO_PIN_FINAL_TRY, "SO_PIN_FINAL_TRY"},
+	{pkcs11.CKF_SO_PIN_LOCKED, "SO_PIN_LOCKED"},
+	{pkcs11.CKF_SO_PIN_TO_BE_CHANGED, "SO_PIN_TO_BE_CHANGED"},
+}
+
+var mechanismFlagNames = []flagName{
+	{pkcs11.CKF_HW, "HW"},
+	{pkcs11.CKF_ENCRYPT, "ENCRYPT"},
+	{pkcs11.CKF_DECRYPT, "DECRYPT"},
+	{pkcs11.CKF_DIGEST, "DIGEST"},
+	{pkcs11.CKF_SIGN, "SIGN"},
+	{pkcs11.CKF_SIGN_RECOVER, "SIGN_RECOVER"},
+	{pkcs11.CKF_VERIFY, "VERIFY"},
+	{pkcs11.CKF_VERIFY_RECOVER, "VERIFY_RECOVER"},
+	{pkcs11.CKF_GENERATE, "GENERATE"},
+	{pkcs11.CKF_GENERATE_KEY_PAIR, "GENERATE_KEY_PAIR"},
+	{pkcs11.CKF_WRAP, "WRAP"},
+	{pkcs11.CKF_UNWRAP, "UNWRAP"},
+	{pkcs11.CKF_DERIVE, "DERIVE"},
+}
+
+// names of the mechanisms this package and fabric use, the others are shown as hex
+var mechanismNames = map[uint]string{
+	pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN:  "CKM_RSA_PKCS_KEY_PAIR_GEN",
+	pkcs11.CKM_RSA_PKCS:               "CKM_RSA_PKCS",
+	pkcs11.CKM_RSA_X_509:              "CKM_RSA_X_509",
+	pkcs11.CKM_RSA_PKCS_OAEP:          "CKM_RSA_PKCS_OAEP",
+	pkcs11.CKM_RSA_PKCS_PSS:           "CKM_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA1_RSA_PKCS:          "CKM_SHA1_RSA_PKCS",
+	pkcs11.CKM_SHA256_RSA_PKCS:        "CKM_SHA256_RSA_PKCS",
+	pkcs11.CKM_SHA384_RSA_PKCS:        "CKM_SHA384_RSA_PKCS",
+	pkcs11.CKM_SHA512_RSA_PKCS:        "CKM_SHA512_RSA_PKCS",
+	pkcs11.CKM_SHA256_RSA_PKCS_PSS:    "CKM_SHA256_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA384_RSA_PKCS_PSS:    "CKM_SHA384_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA512_RSA_PKCS_PSS:    "CKM_SHA512_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA_1:                  "CKM_SHA_1",
+	pkcs11.CKM_SHA224:                 "CKM_SHA224",
+	pkcs11.CKM_SHA256:                 "CKM_SHA256",
+	pkcs11.CKM_SHA384:                 "CKM_SHA384",
+	pkcs11.CKM_SHA512:                 "CKM_SHA512",
+	pkcs11.CKM_SHA_1_HMAC:             "CKM_SHA_1_HMAC",
+	pkcs11.CKM_SHA256_HMAC:            "CKM_SHA256_HMAC",
+	pkcs11.CKM_SHA384_HMAC:            "CKM_SHA384_HMAC",
+	pkcs11.CKM_SHA512_HMAC:            "CKM_SHA512_HMAC",
+	pkcs11.CKM_GENERIC_SECRET_KEY_GEN: "CKM_GENERIC_SECRET_KEY_GEN",
+	pkcs11.CKM_EC_KEY_PAIR_GEN:        "CKM_EC_KEY_PAIR_GEN",
+	pkcs11.CKM_ECDSA:                  "CKM_ECDSA",
+	pkcs11.CKM_ECDSA_SHA1:             "CKM_ECDSA_SHA1",
+	pkcs11.CKM_ECDSA_SHA256:           "CKM_ECDSA_SHA256",
+	pkcs11.CKM_ECDSA_SHA384:           "CKM_ECDSA_SHA384",
+	pkcs11.CKM_ECDSA_SHA512:           "CKM_ECDSA_SHA512",
+	pkcs11.CKM_ECDH1_DERIVE:           "CKM_ECDH1_DERIVE",
+	CKM_EC_EDWARDS_KEY_PAIR_GEN:       "CKM_EC_EDWARDS_KEY_PAIR_GEN",
+	CKM_EDDSA:                         "CKM_EDDSA",
+	pkcs11.CKM_AES_KEY_GEN:            "CKM_AES_KEY_GEN",
+	pkcs11.CKM_AES_ECB:                "CKM_AES_ECB",
+	pkcs11.CKM_AES_CBC:                "CKM_AES_CBC",
+	pkcs11.CKM_AES_CBC_PAD:            "CKM_AES_CBC_PAD",
+	pkcs11.CKM_AES_CTR:                "CKM_AES_CTR",
+	pkcs11.CKM_AES_GCM:                "CKM_AES_GCM",
+	pkcs11.CKM_AES_CMAC:               "CKM_AES_CMAC",
+	pkcs11.CKM_AES_KEY_WRAP:           "CKM_AES_KEY_WRAP",
+	pkcs11.CKM_AES_KEY_WRAP_PAD:       "CKM_AES_KEY_WRAP_PAD",
+	pkcs11.CKM_DES3_KEY_GEN:           "CKM_DES3_KEY_GEN",
+	pkcs11.CKM_DES3_CBC:               "CKM_DES3_CBC",
+}
+
+// returns the names of the flags set
+func decodeFlags(flags uint, names []flagName) (set []string) {
+	set = []string{}
+	for _, f := range names {
+		if flags&f.flag != 0 {
+			set = append(set, f.name)
+		}
+	}
+	return
+}
+
+// MechanismName returns the CKM_ name of a mechanism, or its hex value when unknown
+func MechanismName(mechanism uint) string {
+	if name, found := mechanismNames[mechanism]; found {
+		return name
+	}
+	return fmt.Sprintf("0x%08X", mechanism)
+}
+
+// Returns all the slots of the library, with or without a token
+func (p11w *Pkcs11Wrapper) GetSlots() (slots []Slot, err error) {
+
+	ids, err := p11w.Context.GetSlotList(false)
+	if err != nil {
+		return
+	}
+
+	for _, id := range ids {
+		sInfo, errGs := p11w.Context.GetSlotInfo(id)
+		if errGs != nil {
+			err = errGs
+			return
+		}
+
+		slot := Slot{
+			ID:           id,
+			Description:  strings.TrimSpace(sInfo.SlotDescription),
+			Manufacturer: strings.TrimSpace(sInfo.ManufacturerID),
+			Flags:        decodeFlags(sInfo.Flags, slotFlagNames),
+		}
+
+		if sInfo.Flags&pkcs11.CKF_TOKEN_PRESENT != 0 {
+			tInfo, errGt := p11w.Context.GetTokenInfo(id)
+			if errGt != nil {
+				err = errGt
+				return
+			}
+			slot.Token = &TokenInfo{
+				Label:              strings.TrimSpace(tInfo.Label),
+				Manufacturer:       strings.TrimSpace(tInfo.ManufacturerID),
+				Model:              strings.TrimSpace(tInfo.Model),
+				SerialNumber:       strings.TrimSpace(tInfo.SerialNumber),
+				Flags:              decodeFlags(tInfo.Flags, tokenFlagNames),
+				Initialized:        tInfo.Flags&pkcs11.CKF_TOKEN_INITIALIZED != 0,
+				SessionCount:       tInfo.SessionCount,
+				MaxSessionCount:    tInfo.MaxSessionCount,
+				MinPinLen:          tInfo.MinPinLen,
+				MaxPinLen:          tInfo.MaxPinLen,
+				TotalPublicMemory:  tInfo.TotalPublicMemory,
+				FreePublicMemory:   tInfo.FreePublicMemory,
+				TotalPrivateMemory: tInfo.TotalPrivateMemory,
+				FreePrivateMemory:  tInfo.FreePrivateMemory,
+				HardwareVersion:    fmt.Sprintf("%d.%d", tInfo.HardwareVersion.Major, tInfo.HardwareVersion.Minor),
+				FirmwareVersion:    fmt.Sprintf("%d.%d", tInfo.FirmwareVersion.Major, tInfo.FirmwareVersion.Minor),
+			}
+		}
+
+		slots = append(slots, slot)
+	}
+
+	return
+}
+
+// Prints a table of the slots and their tokens
+func (p11w *Pkcs11Wrapper) ListSlots() (err error) {
+
+	slots, err := p11w.GetSlots()
+	if err != nil {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"SLOT", "DESCRIPTION", "TOKEN LABEL", "SERIAL", "MODEL", "PUBLIC MEM FREE/TOTAL", "PRIVATE MEM FREE/TOTAL", "FLAGS"})
+	table.SetCaption(true, fmt.Sprintf("Total slots found: %d", len(slots)))
+
+	for _, s := range slots {
+		row := []string{fmt.Sprintf("%d", s.ID), s.Description, "", "", "", "", "", strings.Join(s.Flags, " ")}
+		if t := s.Token; t != nil {
+			row[2], row[3], row[4] = t.Label, t.SerialNumber, t.Model
+			row[5] = fmt.Sprintf("%s/%s", memory(t.FreePublicMemory), memory(t.TotalPublicMemory))
+			row[6] = fmt.Sprintf("%s/%s", memory(t.FreePrivateMemory), memory(t.TotalPrivateMemory))
+			row[7] = strings.Join(t.Flags, " ")
+		}
+		table.Append(row)
+	}
+
+	table.Render()
+	return
+}
+
+// formats a CK_TOKEN_INFO memory size, which can be CK_UNAVAILABLE_INFORMATION
+func memory(size uint) string {
+	if size == ^uint(0) || size == uint(^uint32(0)) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%d", size)
+}
+
+// Returns the slot of the first token which is not initialized, like softhsm2-util --free
+func (p11w *Pkcs11Wrapper) FindFreeSlot() (slot uint, err error) {
+
+	slots, err := p11w.GetSlots()
+	if err != nil {
+		return
+	}
+
+	for _, s := range slots {
+		if s.Token != nil && !s.Token.Initialized {
+			slot = s.ID
+			return
+		}
+	}
+
+	err = errors.New("no slot with an uninitialized token found")
+	return
+}
+
+// Returns the mechanisms supported by the token of slot
+func (p11w *Pkcs11Wrapper) GetMechanisms(slot uint) (mechanisms []Mechanism, err error) {
+
+	list, err := p11w.Context.GetMechanismList(slot)
+	if err != nil {
+		return
+	}
+
+	for _, m := range list {
+		mInfo, errGm := p11w.Context.GetMechanismInfo(slot, []*pkcs11.Mechanism{m})
+		if errGm != nil {
+			err = errGm
+			return
+		}
+		mechanisms = append(mechanisms, Mechanism{
+			ID:         m.Mechanism,
+			Name:       MechanismName(m.Mechanism),
+			MinKeySize: mInfo.MinKeySize,
+			MaxKeySize: mInfo.MaxKeySize,
+			Flags:      decodeFlags(mInfo.Flags, mechanismFlagNames),
+		})
+	}
+
+	return
+}
+
+// Prints a table of the mechanisms supported by the token of slot
+func (p11w *Pkcs11Wrapper) ListMechanisms(slot uint) (err error) {
+
+	mechanisms, err := p11w.GetMechanisms(slot)
+	if err != nil {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"MECHANISM", "MIN KEY SIZE", "MAX KEY SIZE", "FLAGS"})
+	table.SetCaption(true, fmt.Sprintf("Total mechanisms found: %d", len(mechanisms)))
+
+	for _, m := range mechanisms {
+		table.Append([]string{m.Name, fmt.Sprintf("%d", m.MinKeySize), fmt.Sprintf("%d", m.MaxKeySize), strings.Join(m.Flags, " ")})
+	}
+
+	table.Render()
+	return
+}
+
+// Initializes the token of slot with label and the SO PIN, destroying all its objects
+func (p11w *Pkcs11Wrapper) InitToken(slot uint, soPin string, label string) (err error) {
+
+	err = p11w.Context.InitToken(slot, soPin, label)
+	return
+}
+
+// runs f in a new read/write session of slot logged in as userType with pin
+func (p11w *Pkcs11Wrapper) withLogin(slot uint, userType uint, pin string, f func(session pkcs11.SessionHandle) error) (err error) {
+
+	session, err := p11w.Context.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return
+	}
+	defer p11w.Context.CloseSession(session)
+
+	if err = p11w.Context.Login(session, userType, pin); err != nil {
+		return
+	}
+	defer p11w.Context.Logout(session)
+
+	err = f(session)
+	return
+}
+
+// Sets the user PIN of the token of slot, logged in as SO
+func (p11w *Pkcs11Wrapper) InitPIN(slot uint, soPin string, userPin string) (err error) {
+
+	err = p11w.withLogin(slot, pkcs11.CKU_SO, soPin, func(session pkcs11.SessionHandle) error {
+		return p11w.Context.InitPIN(session, userPin)
+	})
+	return
+}
+
+// Changes the user PIN of the token of slot, or the SO PIN when so is true
+func (p11w *Pkcs11Wrapper) SetPIN(slot uint, so bool, oldPin string, newPin string) (err error) {
+
+	userType := uint(pkcs11.CKU_USER)
+	if so {
+		userType = pkcs11.CKU_SO
+	}
+
+	err = p11w.withLogin(slot, userType, oldPin, func(session pkcs11.SessionHandle) error {
+		return p11w.Context.SetPIN(session, oldPin, newPin)
+	})
+	return
+}
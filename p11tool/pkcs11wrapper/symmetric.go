@@ -0,0 +1,405 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+)
+
+// names of the supported symmetric cipher modes
+const (
+	// CKM_AES_GCM with a 96 bit IV and a 128 bit tag appended to the ciphertext
+	CipherAESGCM = "aes-gcm"
+	// CKM_AES_CBC_PAD, PKCS#7 padded, with a 128 bit IV
+	CipherAESCBCPad = "aes-cbc-pad"
+)
+
+// names of the supported MAC mechanisms
+const (
+	// CKM_AES_CMAC with an AES key
+	MACAESCMAC = "cmac"
+	// CKM_SHA256_HMAC, CKM_SHA384_HMAC and CKM_SHA512_HMAC with a generic secret key
+	MACHMACSHA256 = "hmac-sha256"
+	MACHMACSHA384 = "hmac-sha384"
+	MACHMACSHA512 = "hmac-sha512"
+)
+
+// size of the parts given to C_EncryptUpdate, C_DecryptUpdate and C_SignUpdate
+const StreamChunkSize = 64 * 1024
+
+// ParseSecretKeyType parses AES or GENERIC, the CKK_GENERIC_SECRET keys used for HMAC
+func ParseSecretKeyType(s string) (keyType uint, err error) {
+	switch strings.ToUpper(s) {
+	case "AES":
+		keyType = pkcs11.CKK_AES
+	case "GENERIC":
+		keyType = pkcs11.CKK_GENERIC_SECRET
+	default:
+		err = fmt.Errorf("unsupported secret key type: %s (AES,GENERIC)", s)
+	}
+	return
+}
+
+// CipherIVSize returns the length of the IV of mode
+func CipherIVSize(mode string) (size int, err error) {
+	switch mode {
+	case CipherAESGCM:
+		size = 12
+	case CipherAESCBCPad:
+		size = 16
+	default:
+		err = fmt.Errorf("unsupported cipher mode: %s (%s,%s)", mode, CipherAESGCM, CipherAESCBCPad)
+	}
+	return
+}
+
+// returns the mechanism of mode with iv and, for GCM, aad. gcm must be freed once the
+// operation is finished.
+func cipherMechanism(mode string, iv []byte, aad []byte) (mechanism *pkcs11.Mechanism, gcm *pkcs11.GCMParams, err error) {
+
+	size, err := CipherIVSize(mode)
+	if err != nil {
+		return
+	}
+	if len(iv) != size {
+		err = fmt.Errorf("%s requires a %d byte IV, not %d", mode, size, len(iv))
+		return
+	}
+
+	switch mode {
+	case CipherAESGCM:
+		gcm = pkcs11.NewGCMParams(iv, aad, 128)
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, gcm)
+	case CipherAESCBCPad:
+		if len(aad) > 0 {
+			err = fmt.Errorf("%s does not authenticate additional data", mode)
+			return
+		}
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_AES_CBC_PAD, iv)
+	}
+
+	return
+}
+
+// MACMechanism returns the mechanism named name
+func MACMechanism(name string) (mechanism *pkcs11.Mechanism, err error) {
+	switch name {
+	case MACAESCMAC:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_AES_CMAC, nil)
+	case MACHMACSHA256:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_SHA256_HMAC, nil)
+	case MACHMACSHA384:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_SHA384_HMAC, nil)
+	case MACHMACSHA512:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_SHA512_HMAC, nil)
+	default:
+		err = fmt.Errorf("unsupported MAC mechanism: %s (%s,%s,%s,%s)", name, MACAESCMAC, MACHMACSHA256, MACHMACSHA384, MACHMACSHA512)
+	}
+	return
+}
+
+// returns the CKA_ID of a new secret key: explicit with IDExplicit, otherwise random as
+// there is no public key to compute it from, of the length of a SHA-256 or SHA-1 SKI
+func (p11w *Pkcs11Wrapper) secretKeyID(opts ImportOptions) (id []byte, err error) {
+	switch opts.IDStrategy {
+	case IDSha256:
+		id, err = p11w.Context.GenerateRandom(p11w.Session, 32)
+	case IDSha1:
+		id, err = p11w.Context.GenerateRandom(p11w.Session, 20)
+	default:
+		id, err = opts.id(SubjectKeyIdentifier{})
+	}
+	return
+}
+
+// returns an error unless bits is a valid size for a key of keyType
+func checkSecretKeySize(keyType uint, bits int) (err error) {
+	switch {
+	case keyType == pkcs11.CKK_AES && bits != 128 && bits != 192 && bits != 256:
+		err = fmt.Errorf("invalid AES key size: %d bits (128,192,256)", bits)
+	case bits <= 0 || bits%8 != 0:
+		err = fmt.Errorf("invalid secret key size: %d bits", bits)
+	}
+	return
+}
+
+// Generates a secret key of keyType (CKK_AES or CKK_GENERIC_SECRET) of bits in the HSM with
+// the label, CKA_ID and attributes of opts, returns the CKA_ID of the key
+func (p11w *Pkcs11Wrapper) GenerateSecretKey(keyType uint, bits int, opts ImportOptions) (id []byte, err error) {
+
+	if err = checkSecretKeySize(keyType, bits); err != nil {
+		return
+	}
+
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_GENERIC_SECRET_KEY_GEN, nil)
+	if keyType == pkcs11.CKK_AES {
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)
+	}
+
+	id, err = p11w.secretKeyID(opts)
+	if err != nil {
+		return
+	}
+
+	template := append(opts.secretAttributes(keyType, id), pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, bits/8))
+
+	_, err = p11w.Context.GenerateKey(p11w.Session, []*pkcs11.Mechanism{mechanism}, template)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Object was generated with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
+	}
+
+	return
+}
+
+// Generates an AES key of bits (128,192,256) in the HSM, returns the CKA_ID of the key
+func (p11w *Pkcs11Wrapper) GenerateAESKey(bits int, opts ImportOptions) (id []byte, err error) {
+
+	id, err = p11w.GenerateSecretKey(pkcs11.CKK_AES, bits, opts)
+	return
+}
+
+// Imports value as a secret key of keyType (CKK_AES or CKK_GENERIC_SECRET) with the label,
+// CKA_ID and attributes of opts, returns the CKA_ID of the key
+func (p11w *Pkcs11Wrapper) ImportSecretKey(keyType uint, value []byte, opts ImportOptions) (id []byte, err error) {
+
+	if err = checkSecretKeySize(keyType, len(value)*8); err != nil {
+		return
+	}
+
+	id, err = p11w.secretKeyID(opts)
+	if err != nil {
+		return
+	}
+
+	template := append(opts.secretAttributes(keyType, id), pkcs11.NewAttribute(pkcs11.CKA_VALUE, value))
+
+	_, err = p11w.Context.CreateObject(p11w.Session, template)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Object was imported with CKA_LABEL:%s CKA_ID:%x\n", opts.Label, id)
+	}
+
+	return
+}
+
+// Imports value as an AES key, returns the CKA_ID of the key
+func (p11w *Pkcs11Wrapper) ImportAESKey(value []byte, opts ImportOptions) (id []byte, err error) {
+
+	id, err = p11w.ImportSecretKey(pkcs11.CKK_AES, value, opts)
+	return
+}
+
+// Encrypts everything read from r with key in mode and writes the ciphertext to w, passing it
+// to C_EncryptUpdate in parts of StreamChunkSize so files of any size can be encrypted. aad is
+// only used by GCM, which appends its tag to the ciphertext.
+func (p11w *Pkcs11Wrapper) EncryptStream(mode string, key pkcs11.ObjectHandle, iv []byte, aad []byte, r io.Reader, w io.Writer) (err error) {
+
+	mechanism, gcm, err := cipherMechanism(mode, iv, aad)
+	if err != nil {
+		return
+	}
+	defer gcm.Free()
+
+	if err = p11w.Context.EncryptInit(p11w.Session, []*pkcs11.Mechanism{mechanism}, key); err != nil {
+		return
+	}
+
+	err = p11w.stream(r, w, p11w.Context.EncryptUpdate, p11w.Context.EncryptFinal)
+	if err != nil {
+		return
+	}
+
+	// some HSMs write their own IV, which would not be the one the caller stored
+	if gcm != nil && !bytes.Equal(gcm.IV(), iv) {
+		err = errors.New("the HSM replaced the GCM IV, the ciphertext can't be decrypted with the given one")
+	}
+
+	return
+}
+
+// Decrypts everything read from r with key in mode and writes the plaintext to w, in parts
+// of StreamChunkSize. With GCM most HSMs return the plaintext only once the tag is verified,
+// at the end.
+func (p11w *Pkcs11Wrapper) DecryptStream(mode string, key pkcs11.ObjectHandle, iv []byte, aad []byte, r io.Reader, w io.Writer) (err error) {
+
+	mechanism, gcm, err := cipherMechanism(mode, iv, aad)
+	if err != nil {
+		return
+	}
+	defer gcm.Free()
+
+	if err = p11w.Context.DecryptInit(p11w.Session, []*pkcs11.Mechanism{mechanism}, key); err != nil {
+		return
+	}
+
+	err = p11w.stream(r, w, p11w.Context.DecryptUpdate, p11w.Context.DecryptFinal)
+	return
+}
+
+// Encrypts plaintext with key in mode
+func (p11w *Pkcs11Wrapper) Encrypt(mode string, key pkcs11.ObjectHandle, iv []byte, aad []byte, plaintext []byte) (ciphertext []byte, err error) {
+
+	var out bytes.Buffer
+	if err = p11w.EncryptStream(mode, key, iv, aad, bytes.NewReader(plaintext), &out); err != nil {
+		return
+	}
+
+	ciphertext = out.Bytes()
+	return
+}
+
+// Decrypts ciphertext with key in mode
+func (p11w *Pkcs11Wrapper) Decrypt(mode string, key pkcs11.ObjectHandle, iv []byte, aad []byte, ciphertext []byte) (plaintext []byte, err error) {
+
+	var out bytes.Buffer
+	if err = p11w.DecryptStream(mode, key, iv, aad, bytes.NewReader(ciphertext), &out); err != nil {
+		return
+	}
+
+	plaintext = out.Bytes()
+	return
+}
+
+// Encrypts r to w like EncryptStream with an IV from C_GenerateRandom, written to w before
+// the ciphertext so Open can read it back. This is the format of the p11tool encrypt command.
+func (p11w *Pkcs11Wrapper) Seal(mode string, key pkcs11.ObjectHandle, aad []byte, r io.Reader, w io.Writer) (err error) {
+
+	size, err := CipherIVSize(mode)
+	if err != nil {
+		return
+	}
+
+	iv, err := p11w.Context.GenerateRandom(p11w.Session, size)
+	if err != nil {
+		return
+	}
+
+	if _, err = w.Write(iv); err != nil {
+		return
+	}
+
+	err = p11w.EncryptStream(mode, key, iv, aad, r, w)
+	return
+}
+
+// Decrypts what Seal wrote, the IV followed by the ciphertext, from r to w
+func (p11w *Pkcs11Wrapper) Open(mode string, key pkcs11.ObjectHandle, aad []byte, r io.Reader, w io.Writer) (err error) {
+
+	size, err := CipherIVSize(mode)
+	if err != nil {
+		return
+	}
+
+	iv := make([]byte, size)
+	if _, err = io.ReadFull(r, iv); err != nil {
+		err = fmt.Errorf("could not read the IV: %v", err)
+		return
+	}
+
+	err = p11w.DecryptStream(mode, key, iv, aad, r, w)
+	return
+}
+
+// Computes the MAC of everything read from r with key and the mechanism named name, with
+// C_SignUpdate in parts of StreamChunkSize
+func (p11w *Pkcs11Wrapper) MAC(name string, key pkcs11.ObjectHandle, r io.Reader) (mac []byte, err error) {
+
+	mechanism, err := MACMechanism(name)
+	if err != nil {
+		return
+	}
+
+	if err = p11w.Context.SignInit(p11w.Session, []*pkcs11.Mechanism{mechanism}, key); err != nil {
+		return
+	}
+
+	update := func(sh pkcs11.SessionHandle, part []byte) ([]byte, error) {
+		return nil, p11w.Context.SignUpdate(sh, part)
+	}
+	var out bytes.Buffer
+	if err = p11w.stream(r, &out, update, p11w.Context.SignFinal); err != nil {
+		return
+	}
+
+	mac = out.Bytes()
+	return
+}
+
+// Verifies the MAC of everything read from r with key and the mechanism named name
+func (p11w *Pkcs11Wrapper) VerifyMAC(name string, key pkcs11.ObjectHandle, r io.Reader, mac []byte) (verified bool, err error) {
+
+	mechanism, err := MACMechanism(name)
+	if err != nil {
+		return
+	}
+
+	if err = p11w.Context.VerifyInit(p11w.Session, []*pkcs11.Mechanism{mechanism}, key); err != nil {
+		return
+	}
+
+	update := func(sh pkcs11.SessionHandle, part []byte) ([]byte, error) {
+		return nil, p11w.Context.VerifyUpdate(sh, part)
+	}
+	// if there is an error, we can assume the MAC was invalid
+	var errVerify error
+	final := func(sh pkcs11.SessionHandle) ([]byte, error) {
+		errVerify = p11w.Context.VerifyFinal(sh, mac)
+		return nil, nil
+	}
+	if err = p11w.stream(r, ioutil.Discard, update, final); err != nil {
+		return
+	}
+
+	verified = errVerify == nil
+	return
+}
+
+// passes what is read from r to update in parts of StreamChunkSize then calls final, writing
+// their output to w. When reading or writing fails the operation is ended with final so the
+// session can be used again.
+func (p11w *Pkcs11Wrapper) stream(
+	r io.Reader,
+	w io.Writer,
+	update func(pkcs11.SessionHandle, []byte) ([]byte, error),
+	final func(pkcs11.SessionHandle) ([]byte, error),
+) (err error) {
+
+	buf := make([]byte, StreamChunkSize)
+
+	for {
+		n, errRead := io.ReadFull(r, buf)
+		if n > 0 {
+			out, errUpdate := update(p11w.Session, buf[:n])
+			if errUpdate != nil {
+				// a failed update ends the operation
+				err = errUpdate
+				return
+			}
+			if _, err = w.Write(out); err != nil {
+				final(p11w.Session)
+				return
+			}
+		}
+		if errRead == io.EOF || errRead == io.ErrUnexpectedEOF {
+			break
+		}
+		if errRead != nil {
+			err = errRead
+			final(p11w.Session)
+			return
+		}
+	}
+
+	out, err := final(p11w.Session)
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(out)
+	return
+}
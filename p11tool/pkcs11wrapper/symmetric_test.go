@@ -0,0 +1,131 @@
+package pkcs11wrapper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestCipherMechanism(t *testing.T) {
+	tests := []struct {
+		mode      string
+		ivSize    int
+		aad       []byte
+		mechanism uint
+		invalid   bool
+	}{
+		{mode: CipherAESGCM, ivSize: 12, mechanism: pkcs11.CKM_AES_GCM},
+		{mode: CipherAESGCM, ivSize: 12, aad: []byte("FooBar"), mechanism: pkcs11.CKM_AES_GCM},
+		{mode: CipherAESCBCPad, ivSize: 16, mechanism: pkcs11.CKM_AES_CBC_PAD},
+		// CBC has no additional data
+		{mode: CipherAESCBCPad, ivSize: 16, aad: []byte("FooBar"), invalid: true},
+		{mode: "aes-ecb", invalid: true},
+		{mode: "AES-GCM", invalid: true},
+		{mode: "", invalid: true},
+	}
+	for _, tt := range tests {
+		size, err := CipherIVSize(tt.mode)
+		if tt.ivSize == 0 {
+			if err == nil {
+				t.Errorf("%q: expected an error for the IV size", tt.mode)
+			}
+		} else if err != nil || size != tt.ivSize {
+			t.Errorf("%q: expected a %d byte IV got %d, %v", tt.mode, tt.ivSize, size, err)
+		}
+
+		iv := bytes.Repeat([]byte{1}, tt.ivSize)
+		mechanism, gcm, err := cipherMechanism(tt.mode, iv, tt.aad)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: Error: %v", tt.mode, err)
+			continue
+		}
+		if mechanism.Mechanism != tt.mechanism || (gcm != nil) != (tt.mechanism == pkcs11.CKM_AES_GCM) {
+			t.Errorf("%q: unexpected mechanism %d", tt.mode, mechanism.Mechanism)
+		}
+		if gcm != nil {
+			gcm.Free()
+		} else if !bytes.Equal(mechanism.Parameter, iv) {
+			t.Errorf("%q: the IV is not the parameter, got %x", tt.mode, mechanism.Parameter)
+		}
+
+		// an IV of another size
+		for _, size := range []int{0, tt.ivSize - 1, tt.ivSize + 1} {
+			if _, _, err = cipherMechanism(tt.mode, make([]byte, size), tt.aad); err == nil {
+				t.Errorf("%q: expected an error for a %d byte IV", tt.mode, size)
+			}
+		}
+	}
+}
+
+func TestMACMechanism(t *testing.T) {
+	tests := []struct {
+		name      string
+		mechanism uint
+		invalid   bool
+	}{
+		{name: MACAESCMAC, mechanism: pkcs11.CKM_AES_CMAC},
+		{name: MACHMACSHA256, mechanism: pkcs11.CKM_SHA256_HMAC},
+		{name: MACHMACSHA384, mechanism: pkcs11.CKM_SHA384_HMAC},
+		{name: MACHMACSHA512, mechanism: pkcs11.CKM_SHA512_HMAC},
+		{name: "hmac-sha1", invalid: true},
+		{name: "HMAC-SHA256", invalid: true},
+		{name: "", invalid: true},
+	}
+	for _, tt := range tests {
+		mechanism, err := MACMechanism(tt.name)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil || mechanism.Mechanism != tt.mechanism {
+			t.Errorf("%q: unexpected mechanism %v, %v", tt.name, mechanism, err)
+		}
+	}
+}
+
+func TestSecretKeySize(t *testing.T) {
+	tests := []struct {
+		keyType string
+		bits    int
+		invalid bool
+	}{
+		{keyType: "AES", bits: 128},
+		{keyType: "aes", bits: 192},
+		{keyType: "AES", bits: 256},
+		{keyType: "AES", bits: 64, invalid: true},
+		{keyType: "AES", bits: 160, invalid: true},
+		{keyType: "AES", bits: 512, invalid: true},
+		{keyType: "AES", bits: 0, invalid: true},
+		{keyType: "GENERIC", bits: 256},
+		{keyType: "generic", bits: 8},
+		{keyType: "GENERIC", bits: 1024},
+		{keyType: "GENERIC", bits: 100, invalid: true},
+		{keyType: "GENERIC", bits: 0, invalid: true},
+		{keyType: "GENERIC", bits: -8, invalid: true},
+	}
+	for _, tt := range tests {
+		keyType, err := ParseSecretKeyType(tt.keyType)
+		if err != nil {
+			t.Errorf("%s: Error: %v", tt.keyType, err)
+			continue
+		}
+		if err = checkSecretKeySize(keyType, tt.bits); (err != nil) != tt.invalid {
+			t.Errorf("%s %d bits: unexpected %v", tt.keyType, tt.bits, err)
+		}
+	}
+
+	for _, invalid := range []string{"DES3", "HMAC", ""} {
+		if _, err := ParseSecretKeyType(invalid); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
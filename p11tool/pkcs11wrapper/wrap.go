@@ -0,0 +1,146 @@
+package pkcs11wrapper
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+// names of the supported wrapping mechanisms
+const (
+	// CKM_AES_KEY_WRAP_PAD (RFC 5649) with a secret AES key, wraps private and secret keys
+	WrapAESKeyWrapPad = "aes-kwp"
+	// CKM_RSA_PKCS_OAEP with SHA-1 and a public RSA key, wraps secret keys
+	WrapRSAOAEP = "rsa-oaep"
+	// CKM_RSA_PKCS_OAEP with SHA-256 and a public RSA key, wraps secret keys
+	WrapRSAOAEPSHA256 = "rsa-oaep-sha256"
+)
+
+// WrapMechanism returns the mechanism named name and the class of the key wrapping with it
+func WrapMechanism(name string) (mechanism *pkcs11.Mechanism, wrappingClass uint, err error) {
+
+	switch name {
+	case WrapAESKeyWrapPad:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP_PAD, nil)
+		wrappingClass = pkcs11.CKO_SECRET_KEY
+	case WrapRSAOAEP:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP,
+			pkcs11.NewOAEPParams(pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1, pkcs11.CKZ_DATA_SPECIFIED, nil))
+		wrappingClass = pkcs11.CKO_PUBLIC_KEY
+	case WrapRSAOAEPSHA256:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP,
+			pkcs11.NewOAEPParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, pkcs11.CKZ_DATA_SPECIFIED, nil))
+		wrappingClass = pkcs11.CKO_PUBLIC_KEY
+	default:
+		err = fmt.Errorf("unsupported wrapping mechanism: %s (%s,%s,%s)", name, WrapAESKeyWrapPad, WrapRSAOAEP, WrapRSAOAEPSHA256)
+	}
+
+	return
+}
+
+// WrappedKey is a key wrapped by WrapKeyObject, with what UnwrapKeyObject needs to recreate it
+type WrappedKey struct {
+	Mechanism string `json:"mechanism"`
+	Class     uint   `json:"class"`
+	KeyType   uint   `json:"keyType"`
+	Label     string `json:"label"`
+	ID        []byte `json:"id"`
+	Wrapped   []byte `json:"wrapped"`
+}
+
+// Wraps key with wrappingKey, calls C_WrapKey
+func (p11w *Pkcs11Wrapper) WrapKey(mechanism *pkcs11.Mechanism, wrappingKey pkcs11.ObjectHandle, key pkcs11.ObjectHandle) (wrapped []byte, err error) {
+
+	wrapped, err = p11w.Context.WrapKey(p11w.Session, []*pkcs11.Mechanism{mechanism}, wrappingKey, key)
+	return
+}
+
+// Unwraps wrapped with unwrappingKey into a new object with template, calls C_UnwrapKey
+func (p11w *Pkcs11Wrapper) UnwrapKey(mechanism *pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrapped []byte, template []*pkcs11.Attribute) (key pkcs11.ObjectHandle, err error) {
+
+	key, err = p11w.Context.UnwrapKey(p11w.Session, []*pkcs11.Mechanism{mechanism}, unwrappingKey, wrapped, template)
+	return
+}
+
+// Wraps the private or secret key object with wrappingKey using the mechanism named
+// mechanismName, keeping its class, key type, label and id for UnwrapKeyObject
+func (p11w *Pkcs11Wrapper) WrapKeyObject(mechanismName string, wrappingKey pkcs11.ObjectHandle, key pkcs11.ObjectHandle) (wrapped WrappedKey, err error) {
+
+	mechanism, _, err := WrapMechanism(mechanismName)
+	if err != nil {
+		return
+	}
+
+	al, err := p11w.Context.GetAttributeValue(
+		p11w.Session,
+		key,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		},
+	)
+	if err != nil {
+		return
+	}
+	if len(al[0].Value) == 0 || len(al[1].Value) == 0 {
+		err = errors.New("key object has no CKA_CLASS or CKA_KEY_TYPE")
+		return
+	}
+
+	wrapped = WrappedKey{
+		Mechanism: mechanismName,
+		Label:     string(al[2].Value),
+		ID:        al[3].Value,
+	}
+	if wrapped.Class, err = decodeULong(al[0].Value); err != nil {
+		return
+	}
+	if wrapped.KeyType, err = decodeULong(al[1].Value); err != nil {
+		return
+	}
+	if wrapped.Class != pkcs11.CKO_PRIVATE_KEY && wrapped.Class != pkcs11.CKO_SECRET_KEY {
+		err = fmt.Errorf("only private and secret keys can be wrapped, not %s", DecodeCKACLASS(byte(wrapped.Class)))
+		return
+	}
+
+	wrapped.Wrapped, err = p11w.WrapKey(mechanism, wrappingKey, key)
+	return
+}
+
+// Unwraps a key wrapped by WrapKeyObject with unwrappingKey. The label and CKA_ID are the
+// ones of the wrapped key unless opts has a Label or an IDExplicit ID, the other attributes
+// are set from opts like an import.
+func (p11w *Pkcs11Wrapper) UnwrapKeyObject(wrapped WrappedKey, unwrappingKey pkcs11.ObjectHandle, opts ImportOptions) (key pkcs11.ObjectHandle, err error) {
+
+	mechanism, _, err := WrapMechanism(wrapped.Mechanism)
+	if err != nil {
+		return
+	}
+
+	if opts.Label == "" {
+		opts.Label = wrapped.Label
+	}
+	id := wrapped.ID
+	if opts.IDStrategy == IDExplicit {
+		if id, err = opts.id(SubjectKeyIdentifier{}); err != nil {
+			return
+		}
+	}
+
+	var template []*pkcs11.Attribute
+	switch wrapped.Class {
+	case pkcs11.CKO_PRIVATE_KEY:
+		template = opts.privAttributes(wrapped.KeyType, id)
+	case pkcs11.CKO_SECRET_KEY:
+		template = opts.secretAttributes(wrapped.KeyType, id)
+	default:
+		err = fmt.Errorf("wrapped key has unsupported class: %s", DecodeCKACLASS(byte(wrapped.Class)))
+		return
+	}
+
+	key, err = p11w.UnwrapKey(mechanism, unwrappingKey, wrapped.Wrapped, template)
+	return
+}
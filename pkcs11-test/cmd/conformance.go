@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/gbolo/go-util/pkcs11-test/p11"
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// conformanceCmd represents the conformance command
+var conformanceCmd = &cobra.Command{
+	Use:   "conformance",
+	Short: "Runs a matrix of key types, mechanisms and attribute templates against a slot",
+	Long: `Runs a matrix of key types, mechanisms and attribute templates against a slot.
+AES, GENERIC_SECRET, EC and RSA keys of several sizes are generated (or imported) with a
+session, token, sensitive and imported template, their attributes are read back, then
+each mechanism of the key type is tested when the slot supports it. The keys are destroyed
+afterwards. Every case is recorded as pass, fail (with its CKR code) or skip in a JUnit XML
+or JSON report. The command exits with 1 when a case failed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		setGlobalFlagValues()
+		PrintPkcs11Settings()
+		p, session, _ := LoginPkcs11()
+		defer p.Destroy()
+		defer p.Finalize()
+		defer p.CloseSession(session)
+		defer p.Logout(session)
+
+		RunConformanceSuite(p, session)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(conformanceCmd)
+
+	conformanceCmd.PersistentFlags().StringP("format", "f", "junit", "Format of the report (junit, json)")
+	conformanceCmd.PersistentFlags().StringP("report", "r", "", "File to write the report to (default pkcs11-conformance.xml or .json)")
+	conformanceCmd.PersistentFlags().String("run", "", "Only run the key specs and templates matching this regexp, e.g. '^EC-P256/' or '/sensitive$'")
+	conformanceCmd.PersistentFlags().String("message", "FooBar", "Raw message to sign and encrypt")
+	viper.BindPFlag("conformance.format", conformanceCmd.PersistentFlags().Lookup("format"))
+	viper.BindPFlag("conformance.report", conformanceCmd.PersistentFlags().Lookup("report"))
+	viper.BindPFlag("conformance.run", conformanceCmd.PersistentFlags().Lookup("run"))
+	viper.BindPFlag("conformance.message", conformanceCmd.PersistentFlags().Lookup("message"))
+
+}
+
+// Runs the conformance cases against the slot and writes the report
+func RunConformanceSuite(p *pkcs11.Ctx, session pkcs11.SessionHandle) {
+
+	// Set conformance variables
+	format := viper.GetString("conformance.format")
+	reportFile := viper.GetString("conformance.report")
+	run := viper.GetString("conformance.run")
+	messageToSign := viper.GetString("conformance.message")
+
+	var extension string
+	switch format {
+	case "junit":
+		extension = "xml"
+	case "json":
+		extension = "json"
+	default:
+		ExitWithMessage(fmt.Sprintf("unsupported report format: %s (junit, json)", format), nil)
+	}
+	if reportFile == "" {
+		reportFile = "pkcs11-conformance." + extension
+	}
+
+	opts := p11.ConformanceOptions{
+		Library: pkcs11Lib,
+		Message: []byte(messageToSign),
+	}
+	if run != "" {
+		re, err := regexp.Compile(run)
+		if err != nil {
+			ExitWithMessage(fmt.Sprintf("invalid run regexp: %s", run), err)
+		}
+		opts.Run = re
+	}
+
+	// line break for readability
+	fmt.Printf("\n")
+
+	report, err := p11.RunConformance(p, session, opts)
+	if err != nil {
+		ExitWithMessage("running conformance cases", err)
+	}
+
+	f, err := os.Create(reportFile)
+	if err != nil {
+		ExitWithMessage(fmt.Sprintf("creating report: %s", reportFile), err)
+	}
+
+	if format == "json" {
+		err = report.WriteJSON(f)
+	} else {
+		err = report.WriteJUnit(f)
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		ExitWithMessage(fmt.Sprintf("writing report: %s", reportFile), err)
+	}
+
+	fmt.Printf("\n%d passed, %d failed, %d skipped in %.3fs\n", report.Passed, report.Failed, report.Skipped, report.Time)
+	fmt.Printf("Report written to: %s\n", reportFile)
+
+	// Exit with 1 when a case failed
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+
+}
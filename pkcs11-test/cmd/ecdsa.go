@@ -149,9 +149,11 @@ func CreateECDSAKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, sindex int) {
 			sig,
 		)
 
-		fmt.Printf("\n\nDEBUGGING: Public Key Info\n")
-		s, e := p11.GetPublicKey(p, session, ObjLabel)
-		fmt.Printf("s: %s e: %s\n", s, e)
+		pubKeyPem, err := p11.GetPublicKey(p, session, ObjLabel)
+		if err != nil {
+			ExitWithMessage(fmt.Sprintf("exporting public key with label: %s", ObjLabel), err)
+		}
+		fmt.Printf("Public key of key with label: %s\n%s", ObjLabel, pubKeyPem)
 
 		//Test Encryption
 		//err = p.EncryptInit(session,)
@@ -0,0 +1,859 @@
+package p11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Conformance case statuses
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+/* a key type and size of the conformance matrix, with the mechanisms tested on its keys */
+type keySpec struct {
+	name    string
+	keyType uint
+	// bytes of a secret key, bits of an RSA key
+	size int
+	// named curve of an EC key (P224, P256, P384, P521)
+	curve      string
+	genMech    uint
+	operations []keyOperation
+}
+
+/* a mechanism tested with encrypt/decrypt or sign/verify */
+type keyOperation struct {
+	mechanism uint
+	encrypt   bool
+}
+
+/* the attributes a key of the matrix is created with */
+type attributeTemplate struct {
+	name string
+	// sets CKA_TOKEN, the key is stored on the token until destroyed
+	token bool
+	// sets CKA_SENSITIVE and clears CKA_EXTRACTABLE, the key value must not be readable
+	sensitive bool
+	// creates the key with C_CreateObject from a key generated in software
+	imported bool
+}
+
+var (
+	aesOperations = []keyOperation{
+		{mechanism: pkcs11.CKM_AES_ECB, encrypt: true},
+		{mechanism: pkcs11.CKM_AES_CBC_PAD, encrypt: true},
+		{mechanism: pkcs11.CKM_AES_CMAC},
+		// what fabric uses, most vendors require a CKK_GENERIC_SECRET key for it
+		{mechanism: pkcs11.CKM_SHA256_HMAC},
+	}
+	hmacOperations = []keyOperation{
+		{mechanism: pkcs11.CKM_SHA256_HMAC},
+		{mechanism: pkcs11.CKM_SHA384_HMAC},
+		{mechanism: pkcs11.CKM_SHA512_HMAC},
+	}
+	ecOperations = []keyOperation{
+		{mechanism: pkcs11.CKM_ECDSA},
+		{mechanism: pkcs11.CKM_ECDSA_SHA256},
+	}
+	rsaOperations = []keyOperation{
+		{mechanism: pkcs11.CKM_SHA256_RSA_PKCS},
+		{mechanism: pkcs11.CKM_RSA_PKCS, encrypt: true},
+	}
+)
+
+/* the key types and sizes tested by RunConformance */
+var conformanceMatrix = []keySpec{
+	{name: "AES-128", keyType: pkcs11.CKK_AES, size: 16, genMech: pkcs11.CKM_AES_KEY_GEN, operations: aesOperations},
+	{name: "AES-192", keyType: pkcs11.CKK_AES, size: 24, genMech: pkcs11.CKM_AES_KEY_GEN, operations: aesOperations},
+	{name: "AES-256", keyType: pkcs11.CKK_AES, size: 32, genMech: pkcs11.CKM_AES_KEY_GEN, operations: aesOperations},
+	{name: "GENERIC-256", keyType: pkcs11.CKK_GENERIC_SECRET, size: 32, genMech: pkcs11.CKM_GENERIC_SECRET_KEY_GEN, operations: hmacOperations},
+	{name: "EC-P224", keyType: pkcs11.CKK_EC, curve: "P224", genMech: pkcs11.CKM_EC_KEY_PAIR_GEN, operations: ecOperations},
+	{name: "EC-P256", keyType: pkcs11.CKK_EC, curve: "P256", genMech: pkcs11.CKM_EC_KEY_PAIR_GEN, operations: ecOperations},
+	{name: "EC-P384", keyType: pkcs11.CKK_EC, curve: "P384", genMech: pkcs11.CKM_EC_KEY_PAIR_GEN, operations: ecOperations},
+	{name: "EC-P521", keyType: pkcs11.CKK_EC, curve: "P521", genMech: pkcs11.CKM_EC_KEY_PAIR_GEN, operations: ecOperations},
+	{name: "RSA-2048", keyType: pkcs11.CKK_RSA, size: 2048, genMech: pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, operations: rsaOperations},
+	{name: "RSA-3072", keyType: pkcs11.CKK_RSA, size: 3072, genMech: pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, operations: rsaOperations},
+	{name: "RSA-4096", keyType: pkcs11.CKK_RSA, size: 4096, genMech: pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, operations: rsaOperations},
+}
+
+/* the attribute templates each key spec of the matrix is tested with */
+var conformanceTemplates = []attributeTemplate{
+	{name: "session"},
+	{name: "token", token: true},
+	{name: "sensitive", sensitive: true},
+	{name: "imported", imported: true},
+}
+
+/* names of the mechanisms of the matrix */
+var mechanismNames = map[uint]string{
+	pkcs11.CKM_AES_KEY_GEN:            "CKM_AES_KEY_GEN",
+	pkcs11.CKM_GENERIC_SECRET_KEY_GEN: "CKM_GENERIC_SECRET_KEY_GEN",
+	pkcs11.CKM_EC_KEY_PAIR_GEN:        "CKM_EC_KEY_PAIR_GEN",
+	pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN:  "CKM_RSA_PKCS_KEY_PAIR_GEN",
+	pkcs11.CKM_AES_ECB:                "CKM_AES_ECB",
+	pkcs11.CKM_AES_CBC_PAD:            "CKM_AES_CBC_PAD",
+	pkcs11.CKM_AES_CMAC:               "CKM_AES_CMAC",
+	pkcs11.CKM_SHA256_HMAC:            "CKM_SHA256_HMAC",
+	pkcs11.CKM_SHA384_HMAC:            "CKM_SHA384_HMAC",
+	pkcs11.CKM_SHA512_HMAC:            "CKM_SHA512_HMAC",
+	pkcs11.CKM_ECDSA:                  "CKM_ECDSA",
+	pkcs11.CKM_ECDSA_SHA256:           "CKM_ECDSA_SHA256",
+	pkcs11.CKM_SHA256_RSA_PKCS:        "CKM_SHA256_RSA_PKCS",
+	pkcs11.CKM_RSA_PKCS:               "CKM_RSA_PKCS",
+}
+
+/* the attributes read back and compared after a key is created, with their names */
+var comparedAttributes = map[uint]string{
+	pkcs11.CKA_CLASS:        "CKA_CLASS",
+	pkcs11.CKA_KEY_TYPE:     "CKA_KEY_TYPE",
+	pkcs11.CKA_TOKEN:        "CKA_TOKEN",
+	pkcs11.CKA_PRIVATE:      "CKA_PRIVATE",
+	pkcs11.CKA_LABEL:        "CKA_LABEL",
+	pkcs11.CKA_ID:           "CKA_ID",
+	pkcs11.CKA_SENSITIVE:    "CKA_SENSITIVE",
+	pkcs11.CKA_EXTRACTABLE:  "CKA_EXTRACTABLE",
+	pkcs11.CKA_ENCRYPT:      "CKA_ENCRYPT",
+	pkcs11.CKA_DECRYPT:      "CKA_DECRYPT",
+	pkcs11.CKA_SIGN:         "CKA_SIGN",
+	pkcs11.CKA_VERIFY:       "CKA_VERIFY",
+	pkcs11.CKA_VALUE_LEN:    "CKA_VALUE_LEN",
+	pkcs11.CKA_EC_PARAMS:    "CKA_EC_PARAMS",
+	pkcs11.CKA_MODULUS_BITS: "CKA_MODULUS_BITS",
+}
+
+/* returns the name of a mechanism */
+func MechanismName(mechanism uint) string {
+	if name, found := mechanismNames[mechanism]; found {
+		return name
+	}
+	return fmt.Sprintf("0x%08X", mechanism)
+}
+
+// ConformanceOptions selects the cases of RunConformance
+type ConformanceOptions struct {
+	// path of the library, for the report
+	Library string
+	// data signed and encrypted by the operations
+	Message []byte
+	// when set, only the key specs and templates whose "spec/template" matches are run
+	Run *regexp.Regexp
+}
+
+// ConformanceResult is the outcome of one case, CKR is set when a PKCS#11 call failed
+type ConformanceResult struct {
+	Suite    string  `json:"suite"`
+	Template string  `json:"template"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	CKR      string  `json:"ckr,omitempty"`
+	CKRCode  string  `json:"ckrCode,omitempty"`
+	Message  string  `json:"message,omitempty"`
+	Time     float64 `json:"time"`
+}
+
+// ConformanceReport is the outcome of a conformance run against a slot
+type ConformanceReport struct {
+	Library         string              `json:"library"`
+	Manufacturer    string              `json:"manufacturer"`
+	LibraryVersion  string              `json:"libraryVersion"`
+	CryptokiVersion string              `json:"cryptokiVersion"`
+	Token           ConformanceToken    `json:"token"`
+	Timestamp       time.Time           `json:"timestamp"`
+	Time            float64             `json:"time"`
+	Passed          int                 `json:"passed"`
+	Failed          int                 `json:"failed"`
+	Skipped         int                 `json:"skipped"`
+	Results         []ConformanceResult `json:"results"`
+}
+
+// ConformanceToken is the token the conformance cases ran against
+type ConformanceToken struct {
+	Label           string `json:"label"`
+	Manufacturer    string `json:"manufacturer"`
+	Model           string `json:"model"`
+	SerialNumber    string `json:"serialNumber"`
+	HardwareVersion string `json:"hardwareVersion"`
+	FirmwareVersion string `json:"firmwareVersion"`
+}
+
+/* the error of a PKCS#11 call, the report records its CKR code */
+type callError struct {
+	call string
+	err  error
+}
+
+func (e callError) Error() string {
+	return fmt.Sprintf("%s: %s", e.call, e.err)
+}
+
+/* returned by a case that could not run */
+type skipError string
+
+func (e skipError) Error() string {
+	return string(e)
+}
+
+/* returns the CKR name and code of a failed PKCS#11 call */
+func ckrOf(err error) (name string, code string) {
+
+	if ce, ok := err.(callError); ok {
+		err = ce.err
+	}
+
+	rv, ok := err.(pkcs11.Error)
+	if !ok {
+		return
+	}
+
+	// the message of a pkcs11.Error ends with the CKR name
+	message := rv.Error()
+	name = message[strings.LastIndex(message, " ")+1:]
+	code = fmt.Sprintf("0x%08X", uint(rv))
+	return
+}
+
+/* a key created for a spec and template */
+type conformanceKey struct {
+	spec     keySpec
+	template attributeTemplate
+	// secret or private key, and public key of a key pair
+	key pkcs11.ObjectHandle
+	pub pkcs11.ObjectHandle
+	// the templates the objects were created with
+	keyAttributes []*pkcs11.Attribute
+	pubAttributes []*pkcs11.Attribute
+	// value of an imported secret key
+	value []byte
+}
+
+/* state of a conformance run */
+type conformance struct {
+	p          *pkcs11.Ctx
+	session    pkcs11.SessionHandle
+	opts       ConformanceOptions
+	mechanisms map[uint]bool
+	report     *ConformanceReport
+}
+
+// RunConformance runs every key spec of the matrix with every attribute template against the
+// slot of session, which must be logged in. Each key is created, its attributes are read
+// back, each mechanism of its spec is tested when the slot supports it, then it is destroyed.
+func RunConformance(p *pkcs11.Ctx, session pkcs11.SessionHandle, opts ConformanceOptions) (report ConformanceReport, err error) {
+
+	report.Library = opts.Library
+	report.Timestamp = time.Now()
+
+	info, err := p.GetInfo()
+	if err != nil {
+		return
+	}
+	report.Manufacturer = strings.TrimSpace(info.ManufacturerID)
+	report.LibraryVersion = fmt.Sprintf("%d.%d", info.LibraryVersion.Major, info.LibraryVersion.Minor)
+	report.CryptokiVersion = fmt.Sprintf("%d.%d", info.CryptokiVersion.Major, info.CryptokiVersion.Minor)
+
+	sessionInfo, err := p.GetSessionInfo(session)
+	if err != nil {
+		return
+	}
+
+	tokenInfo, err := p.GetTokenInfo(sessionInfo.SlotID)
+	if err != nil {
+		return
+	}
+	report.Token = ConformanceToken{
+		Label:           strings.TrimSpace(tokenInfo.Label),
+		Manufacturer:    strings.TrimSpace(tokenInfo.ManufacturerID),
+		Model:           strings.TrimSpace(tokenInfo.Model),
+		SerialNumber:    strings.TrimSpace(tokenInfo.SerialNumber),
+		HardwareVersion: fmt.Sprintf("%d.%d", tokenInfo.HardwareVersion.Major, tokenInfo.HardwareVersion.Minor),
+		FirmwareVersion: fmt.Sprintf("%d.%d", tokenInfo.FirmwareVersion.Major, tokenInfo.FirmwareVersion.Minor),
+	}
+
+	mechanisms, err := p.GetMechanismList(sessionInfo.SlotID)
+	if err != nil {
+		return
+	}
+
+	c := conformance{
+		p:          p,
+		session:    session,
+		opts:       opts,
+		mechanisms: make(map[uint]bool),
+		report:     &report,
+	}
+	for _, m := range mechanisms {
+		c.mechanisms[m.Mechanism] = true
+	}
+
+	start := time.Now()
+	for _, spec := range conformanceMatrix {
+		for _, template := range conformanceTemplates {
+			if opts.Run == nil || opts.Run.MatchString(spec.name+"/"+template.name) {
+				c.runKey(spec, template)
+			}
+		}
+	}
+	report.Time = time.Since(start).Seconds()
+
+	return
+}
+
+/* runs the cases of a key spec with a template */
+func (c *conformance) runKey(spec keySpec, template attributeTemplate) {
+
+	createStep := "generate"
+	if template.imported {
+		createStep = "import"
+	}
+
+	steps := []string{"attributes"}
+	if template.sensitive {
+		steps = append(steps, "sensitive")
+	}
+	for _, op := range spec.operations {
+		steps = append(steps, MechanismName(op.mechanism))
+	}
+
+	var k *conformanceKey
+	err := c.run(spec, template, createStep, func() (err error) {
+		k, err = c.createKey(spec, template)
+		return
+	})
+	if err != nil {
+		for _, step := range steps {
+			c.record(spec, template, step, 0, skipError(fmt.Sprintf("key was not created: %s", err)))
+		}
+		return
+	}
+
+	c.run(spec, template, "attributes", func() error {
+		return c.checkAttributes(k)
+	})
+	if template.sensitive {
+		c.run(spec, template, "sensitive", func() error {
+			return c.checkSensitive(k)
+		})
+	}
+	for _, op := range spec.operations {
+		op := op
+		c.run(spec, template, MechanismName(op.mechanism), func() error {
+			return c.runOperation(k, op)
+		})
+	}
+
+	c.run(spec, template, "destroy", func() error {
+		return c.destroyKey(k)
+	})
+}
+
+/* runs and records a case */
+func (c *conformance) run(spec keySpec, template attributeTemplate, step string, f func() error) (err error) {
+
+	start := time.Now()
+	err = f()
+	c.record(spec, template, step, time.Since(start), err)
+
+	return
+}
+
+/* records the result of a case and prints it */
+func (c *conformance) record(spec keySpec, template attributeTemplate, step string, elapsed time.Duration, err error) {
+
+	result := ConformanceResult{
+		Suite:    spec.name,
+		Template: template.name,
+		Name:     step,
+		Status:   StatusPass,
+		Time:     elapsed.Seconds(),
+	}
+
+	if _, skipped := err.(skipError); skipped {
+		result.Status = StatusSkip
+		result.Message = err.Error()
+		c.report.Skipped++
+	} else if err != nil {
+		result.Status = StatusFail
+		result.Message = err.Error()
+		result.CKR, result.CKRCode = ckrOf(err)
+		c.report.Failed++
+	} else {
+		c.report.Passed++
+	}
+
+	c.report.Results = append(c.report.Results, result)
+
+	fmt.Printf("%s %s/%s/%s (%.3fs)\n", strings.ToUpper(result.Status), spec.name, template.name, step, result.Time)
+	if result.Message != "" {
+		fmt.Printf("     %s\n", result.Message)
+	}
+}
+
+/* returns the usage attributes of a key of spec of class */
+func (spec keySpec) usage(class uint) []*pkcs11.Attribute {
+
+	var encrypt, decrypt, sign, verify bool
+	switch {
+	case class == pkcs11.CKO_SECRET_KEY:
+		encrypt, decrypt, sign, verify = spec.keyType == pkcs11.CKK_AES, spec.keyType == pkcs11.CKK_AES, true, true
+	case class == pkcs11.CKO_PRIVATE_KEY:
+		decrypt, sign = spec.keyType == pkcs11.CKK_RSA, true
+	case class == pkcs11.CKO_PUBLIC_KEY:
+		encrypt, verify = spec.keyType == pkcs11.CKK_RSA, true
+	}
+
+	var attrs []*pkcs11.Attribute
+	for _, usage := range []struct {
+		attribute uint
+		set       bool
+	}{
+		{pkcs11.CKA_ENCRYPT, encrypt},
+		{pkcs11.CKA_DECRYPT, decrypt},
+		{pkcs11.CKA_SIGN, sign},
+		{pkcs11.CKA_VERIFY, verify},
+	} {
+		if usage.set {
+			attrs = append(attrs, pkcs11.NewAttribute(usage.attribute, true))
+		}
+	}
+
+	return attrs
+}
+
+/* returns the attributes of an object of class for a key of spec */
+func (template attributeTemplate) attributes(spec keySpec, class uint, label string, id []byte) []*pkcs11.Attribute {
+
+	attrs := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, spec.keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, template.token),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, class != pkcs11.CKO_PUBLIC_KEY),
+	}
+
+	if template.sensitive && class != pkcs11.CKO_PUBLIC_KEY {
+		attrs = append(attrs,
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		)
+	}
+
+	return append(attrs, spec.usage(class)...)
+}
+
+/* creates the key of spec with template */
+func (c *conformance) createKey(spec keySpec, template attributeTemplate) (k *conformanceKey, err error) {
+
+	id := make([]byte, 8)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+	label := fmt.Sprintf("conformance-%s-%s-%x", spec.name, template.name, id)
+
+	keyClass := pkcs11.CKO_PRIVATE_KEY
+	if spec.keyType == pkcs11.CKK_AES || spec.keyType == pkcs11.CKK_GENERIC_SECRET {
+		keyClass = pkcs11.CKO_SECRET_KEY
+	}
+
+	k = &conformanceKey{
+		spec:          spec,
+		template:      template,
+		keyAttributes: template.attributes(spec, uint(keyClass), label, id),
+	}
+	if keyClass == pkcs11.CKO_PRIVATE_KEY {
+		k.pubAttributes = template.attributes(spec, pkcs11.CKO_PUBLIC_KEY, label, id)
+	}
+
+	if template.imported {
+		err = c.importKey(k)
+		return
+	}
+
+	if !c.mechanisms[spec.genMech] {
+		err = skipError(fmt.Sprintf("%s is not supported by the slot", MechanismName(spec.genMech)))
+		return
+	}
+
+	err = c.generateKey(k)
+	return
+}
+
+/* generates the key in the HSM */
+func (c *conformance) generateKey(k *conformanceKey) (err error) {
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(k.spec.genMech, nil)}
+
+	switch k.spec.keyType {
+	case pkcs11.CKK_AES, pkcs11.CKK_GENERIC_SECRET:
+		k.keyAttributes = append(k.keyAttributes, pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, k.spec.size))
+		if k.key, err = c.p.GenerateKey(c.session, mechanism, k.keyAttributes); err != nil {
+			err = callError{"C_GenerateKey", err}
+		}
+		return
+
+	case pkcs11.CKK_EC:
+		var ecParam []byte
+		if ecParam, err = GetECParamMarshaled(k.spec.curve); err != nil {
+			return
+		}
+		k.pubAttributes = append(k.pubAttributes, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParam))
+
+	case pkcs11.CKK_RSA:
+		k.pubAttributes = append(k.pubAttributes,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, k.spec.size),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{0x01, 0x00, 0x01}),
+		)
+	}
+
+	if k.pub, k.key, err = c.p.GenerateKeyPair(c.session, mechanism, k.pubAttributes, k.keyAttributes); err != nil {
+		err = callError{"C_GenerateKeyPair", err}
+	}
+	return
+}
+
+/* generates the key in software and creates its objects */
+func (c *conformance) importKey(k *conformanceKey) (err error) {
+
+	var keyValues, pubValues []*pkcs11.Attribute
+
+	switch k.spec.keyType {
+	case pkcs11.CKK_AES, pkcs11.CKK_GENERIC_SECRET:
+		k.value = make([]byte, k.spec.size)
+		if _, err = rand.Read(k.value); err != nil {
+			return
+		}
+		keyValues = []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, k.value)}
+
+	case pkcs11.CKK_EC:
+		var ecParam, ecPoint []byte
+		var curve elliptic.Curve
+		var key *ecdsa.PrivateKey
+		if ecParam, err = GetECParamMarshaled(k.spec.curve); err != nil {
+			return
+		}
+		if curve, err = GetECCurve(ecParam); err != nil {
+			return
+		}
+		if key, err = ecdsa.GenerateKey(curve, rand.Reader); err != nil {
+			return
+		}
+		if ecPoint, err = asn1.Marshal(elliptic.Marshal(curve, key.X, key.Y)); err != nil {
+			return
+		}
+		d := make([]byte, (curve.Params().BitSize+7)/8)
+		keyValues = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParam),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, append(d[:len(d)-len(key.D.Bytes())], key.D.Bytes()...)),
+		}
+		pubValues = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParam),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, ecPoint),
+		}
+
+	case pkcs11.CKK_RSA:
+		var key *rsa.PrivateKey
+		if key, err = rsa.GenerateKey(rand.Reader, k.spec.size); err != nil {
+			return
+		}
+		e := big.NewInt(int64(key.E)).Bytes()
+		keyValues = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, e),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE_EXPONENT, key.D.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, key.Primes[0].Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, key.Primes[1].Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_1, key.Precomputed.Dp.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_2, key.Precomputed.Dq.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_COEFFICIENT, key.Precomputed.Qinv.Bytes()),
+		}
+		pubValues = []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, e),
+		}
+	}
+
+	if k.pubAttributes != nil {
+		if k.pub, err = c.p.CreateObject(c.session, append(k.pubAttributes, pubValues...)); err != nil {
+			err = callError{"C_CreateObject", err}
+			return
+		}
+	}
+
+	if k.key, err = c.p.CreateObject(c.session, append(k.keyAttributes, keyValues...)); err != nil {
+		err = callError{"C_CreateObject", err}
+		if k.pub != 0 {
+			c.p.DestroyObject(c.session, k.pub)
+		}
+	}
+
+	return
+}
+
+/* reads back the attributes the objects were created with and compares them */
+func (c *conformance) checkAttributes(k *conformanceKey) (err error) {
+
+	var mismatches []string
+
+	for _, object := range []struct {
+		name   string
+		handle pkcs11.ObjectHandle
+		attrs  []*pkcs11.Attribute
+	}{
+		{"key", k.key, k.keyAttributes},
+		{"public key", k.pub, k.pubAttributes},
+	} {
+		if object.attrs == nil {
+			continue
+		}
+
+		var template []*pkcs11.Attribute
+		for _, a := range object.attrs {
+			if _, compared := comparedAttributes[a.Type]; compared {
+				template = append(template, pkcs11.NewAttribute(a.Type, nil))
+			}
+		}
+
+		var values []*pkcs11.Attribute
+		if values, err = c.p.GetAttributeValue(c.session, object.handle, template); err != nil {
+			err = callError{"C_GetAttributeValue", err}
+			return
+		}
+
+		for _, a := range object.attrs {
+			for _, v := range values {
+				if v.Type == a.Type && !bytes.Equal(v.Value, a.Value) {
+					mismatches = append(mismatches, fmt.Sprintf("%s of %s is %x instead of %x", comparedAttributes[a.Type], object.name, v.Value, a.Value))
+				}
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		err = errors.New(strings.Join(mismatches, ", "))
+	}
+
+	return
+}
+
+/* checks the value of a sensitive key can't be read and that it was always sensitive */
+func (c *conformance) checkSensitive(k *conformanceKey) (err error) {
+
+	secret := uint(pkcs11.CKA_VALUE)
+	secretName := "CKA_VALUE"
+	if k.spec.keyType == pkcs11.CKK_RSA {
+		secret, secretName = pkcs11.CKA_PRIVATE_EXPONENT, "CKA_PRIVATE_EXPONENT"
+	}
+
+	_, err = c.p.GetAttributeValue(c.session, k.key, []*pkcs11.Attribute{pkcs11.NewAttribute(secret, nil)})
+	if err == nil {
+		err = fmt.Errorf("%s of a sensitive key can be read", secretName)
+		return
+	}
+	if rv, ok := err.(pkcs11.Error); !ok || rv != pkcs11.CKR_ATTRIBUTE_SENSITIVE {
+		err = callError{"C_GetAttributeValue", err}
+		return
+	}
+
+	values, err := c.p.GetAttributeValue(c.session, k.key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ALWAYS_SENSITIVE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_NEVER_EXTRACTABLE, nil),
+	})
+	if err != nil {
+		err = callError{"C_GetAttributeValue", err}
+		return
+	}
+	for i, name := range []string{"CKA_ALWAYS_SENSITIVE", "CKA_NEVER_EXTRACTABLE"} {
+		if !bytes.Equal(values[i].Value, []byte{1}) {
+			err = fmt.Errorf("%s of a generated sensitive key is %x", name, values[i].Value)
+			return
+		}
+	}
+
+	return
+}
+
+/* runs an operation of the key spec, when the slot supports its mechanism */
+func (c *conformance) runOperation(k *conformanceKey, op keyOperation) (err error) {
+
+	if !c.mechanisms[op.mechanism] {
+		err = skipError(fmt.Sprintf("%s is not supported by the slot", MechanismName(op.mechanism)))
+		return
+	}
+
+	// the public key encrypts and verifies, a secret key does everything
+	pub := k.pub
+	if pub == 0 {
+		pub = k.key
+	}
+
+	data := c.opts.Message
+	switch op.mechanism {
+	case pkcs11.CKM_ECDSA, pkcs11.CKM_AES_ECB:
+		// a SHA-256 digest, which is also 2 AES blocks
+		digest := sha256.Sum256(c.opts.Message)
+		data = digest[:]
+	}
+
+	var param []byte
+	if op.mechanism == pkcs11.CKM_AES_CBC_PAD {
+		param = make([]byte, 16)
+		if _, err = rand.Read(param); err != nil {
+			return
+		}
+	}
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(op.mechanism, param)}
+
+	if op.encrypt {
+		err = c.encryptDecrypt(mechanism, pub, k.key, data)
+		return
+	}
+
+	signature, err := c.signVerify(mechanism, k.key, pub, data)
+	if err != nil {
+		return
+	}
+
+	err = c.verifySoftware(k, op.mechanism, signature)
+	return
+}
+
+/* encrypts data with key and decrypts it with decryptKey */
+func (c *conformance) encryptDecrypt(mechanism []*pkcs11.Mechanism, key, decryptKey pkcs11.ObjectHandle, data []byte) (err error) {
+
+	if err = c.p.EncryptInit(c.session, mechanism, key); err != nil {
+		err = callError{"C_EncryptInit", err}
+		return
+	}
+	ciphertext, err := c.p.Encrypt(c.session, data)
+	if err != nil {
+		err = callError{"C_Encrypt", err}
+		return
+	}
+
+	if err = c.p.DecryptInit(c.session, mechanism, decryptKey); err != nil {
+		err = callError{"C_DecryptInit", err}
+		return
+	}
+	plaintext, err := c.p.Decrypt(c.session, ciphertext)
+	if err != nil {
+		err = callError{"C_Decrypt", err}
+		return
+	}
+
+	if !bytes.Equal(plaintext, data) {
+		err = errors.New("decrypted data differs from the encrypted data")
+	}
+	return
+}
+
+/* signs data with key and verifies the signature with verifyKey */
+func (c *conformance) signVerify(mechanism []*pkcs11.Mechanism, key, verifyKey pkcs11.ObjectHandle, data []byte) (signature []byte, err error) {
+
+	if err = c.p.SignInit(c.session, mechanism, key); err != nil {
+		err = callError{"C_SignInit", err}
+		return
+	}
+	if signature, err = c.p.Sign(c.session, data); err != nil {
+		err = callError{"C_Sign", err}
+		return
+	}
+
+	if err = c.p.VerifyInit(c.session, mechanism, verifyKey); err != nil {
+		err = callError{"C_VerifyInit", err}
+		return
+	}
+	if err = c.p.Verify(c.session, data, signature); err != nil {
+		err = callError{"C_Verify", err}
+	}
+	return
+}
+
+/* checks a signature of the HSM in software: with the public key, or the HMAC of an imported key */
+func (c *conformance) verifySoftware(k *conformanceKey, mechanism uint, signature []byte) (err error) {
+
+	digest := sha256.Sum256(c.opts.Message)
+
+	switch mechanism {
+	case pkcs11.CKM_ECDSA, pkcs11.CKM_ECDSA_SHA256:
+		var pub interface{}
+		if pub, err = GetECPublicKey(c.p, c.session, k.pub); err != nil {
+			return
+		}
+		ecdsaPub := pub.(*ecdsa.PublicKey)
+		r := new(big.Int).SetBytes(signature[:len(signature)/2])
+		s := new(big.Int).SetBytes(signature[len(signature)/2:])
+		if !ecdsa.Verify(ecdsaPub, digest[:], r, s) {
+			err = errors.New("signature does not verify with crypto/ecdsa")
+		}
+
+	case pkcs11.CKM_SHA256_RSA_PKCS:
+		var values []*pkcs11.Attribute
+		values, err = c.p.GetAttributeValue(c.session, k.pub, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			err = callError{"C_GetAttributeValue", err}
+			return
+		}
+		rsaPub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(values[0].Value),
+			E: int(new(big.Int).SetBytes(values[1].Value).Int64()),
+		}
+		if err = rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, digest[:], signature); err != nil {
+			err = fmt.Errorf("signature does not verify with crypto/rsa: %s", err)
+		}
+
+	case pkcs11.CKM_SHA256_HMAC, pkcs11.CKM_SHA384_HMAC, pkcs11.CKM_SHA512_HMAC:
+		if k.value == nil {
+			return
+		}
+		hashes := map[uint]func() hash.Hash{
+			pkcs11.CKM_SHA256_HMAC: sha256.New,
+			pkcs11.CKM_SHA384_HMAC: sha512.New384,
+			pkcs11.CKM_SHA512_HMAC: sha512.New,
+		}
+		mac := hmac.New(hashes[mechanism], k.value)
+		mac.Write(c.opts.Message)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			err = errors.New("HMAC differs from crypto/hmac")
+		}
+	}
+
+	return
+}
+
+/* destroys the objects of the key */
+func (c *conformance) destroyKey(k *conformanceKey) (err error) {
+
+	for _, object := range []pkcs11.ObjectHandle{k.key, k.pub} {
+		if object == 0 {
+			continue
+		}
+		if errDestroy := c.p.DestroyObject(c.session, object); errDestroy != nil && err == nil {
+			err = callError{"C_DestroyObject", errDestroy}
+		}
+	}
+
+	return
+}
@@ -1,12 +1,14 @@
 package p11
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/x509"
 	"encoding/asn1"
+	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 
 	"github.com/miekg/pkcs11"
 )
@@ -127,6 +129,26 @@ func VerifyECDSAKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, oLabel string,
 	return
 }
 
+/* returns the curve of a CKA_EC_PARAMS value */
+func GetECCurve(ecParamMarshaled []byte) (curve elliptic.Curve, err error) {
+
+	for name, c := range map[string]elliptic.Curve{
+		"P224": elliptic.P224(),
+		"P256": elliptic.P256(),
+		"P384": elliptic.P384(),
+		"P521": elliptic.P521(),
+	} {
+		marshaled, errMarshal := GetECParamMarshaled(name)
+		if errMarshal == nil && bytes.Equal(marshaled, ecParamMarshaled) {
+			curve = c
+			return
+		}
+	}
+
+	err = fmt.Errorf("Unsupported CKA_EC_PARAMS: %x", ecParamMarshaled)
+	return
+}
+
 /* This should return the public key in PEM format */
 func GetPublicKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, objectLabel string) (pubKeyPem string, err error) {
 
@@ -153,55 +175,60 @@ func GetPublicKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, objectLabel strin
 		err = fmt.Errorf("more than 1 key found")
 		return
 	}
-
-	// get CKA_VALUE
-	fmt.Println("Found a pub key:", oHs[0])
-	pubKeyHandle := oHs[0]
-	template := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
-		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	if len(oHs) == 0 {
+		err = fmt.Errorf("no public key found with label: %s", objectLabel)
+		return
 	}
-	pubKeyAttrValues, err := p.GetAttributeValue(session, pubKeyHandle, template)
 
+	pubKey, err := GetECPublicKey(p, session, oHs[0])
 	if err != nil {
 		return
 	}
 
-	// according to: http://docs.oasis-open.org/pkcs11/pkcs11-curr/v2.40/csprd02/pkcs11-curr-v2.40-csprd02.html#_Toc387327769
-	// DER-encoding of an ANSI X9.62 Parameters value
-	fmt.Println("CKA_EC_PARAMS:", pubKeyAttrValues[0].Value)
-	// DER-encoding of ANSI X9.62 ECPoint value Q
-	fmt.Println("CKA_EC_POINT:", pubKeyAttrValues[1].Value)
-
-	err = ioutil.WriteFile("ecpoint.der", pubKeyAttrValues[1].Value, 0644)
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
 	if err != nil {
-		panic(err)
+		return
 	}
 
-	var ecp []byte
-	_, err1 := asn1.Unmarshal(pubKeyAttrValues[1].Value, &ecp)
-	if err1 != nil {
-		fmt.Printf("Failed to decode ASN.1 encoded CKA_EC_POINT (%s)", err1.Error())
+	pubKeyPem = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return
+}
+
+/* returns the public key of an EC public key object */
+func GetECPublicKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, object pkcs11.ObjectHandle) (pubKey crypto.PublicKey, err error) {
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	}
+	pubKeyAttrValues, err := p.GetAttributeValue(session, object, template)
+	if err != nil {
+		return
 	}
-	fmt.Println("ecp:", ecp)
 
-	pubKey, err := getPublic(ecp)
+	// according to: http://docs.oasis-open.org/pkcs11/pkcs11-curr/v2.40/csprd02/pkcs11-curr-v2.40-csprd02.html#_Toc387327769
+	// DER-encoding of an ANSI X9.62 Parameters value
+	curve, err := GetECCurve(pubKeyAttrValues[0].Value)
 	if err != nil {
-		fmt.Printf("Failed to decode public key (%s)", err.Error())
 		return
 	}
 
-	fmt.Printf("Public key: %#v", pubKey)
+	// DER-encoding of ANSI X9.62 ECPoint value Q, some providers return the raw point
+	ecp := pubKeyAttrValues[1].Value
+	var derEcp []byte
+	if rest, errAsn := asn1.Unmarshal(ecp, &derEcp); errAsn == nil && len(rest) == 0 {
+		ecp = derEcp
+	}
 
-	pubKeyPem = ""
+	pubKey, err = getPublic(curve, ecp)
 	return
 }
 
-func getPublic(point []byte) (pub crypto.PublicKey, err error) {
+func getPublic(curve elliptic.Curve, point []byte) (pub crypto.PublicKey, err error) {
 	var ecdsaPub ecdsa.PublicKey
 
-	ecdsaPub.Curve = elliptic.P256()
-	pointLenght := ecdsaPub.Curve.Params().BitSize/8*2 + 1
+	ecdsaPub.Curve = curve
+	pointLenght := (ecdsaPub.Curve.Params().BitSize+7)/8*2 + 1
 	if len(point) != pointLenght {
 		err = fmt.Errorf("CKA_EC_POINT (%d) does not fit used curve (%d)", len(point), pointLenght)
 		return
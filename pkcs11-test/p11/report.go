@@ -0,0 +1,158 @@
+package p11
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+/* JUnit XML as read by Jenkins, GitLab and most CI servers */
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+/* returns the seconds of a JUnit time attribute */
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+/* returns the library and token of the report as JUnit properties */
+func (report *ConformanceReport) properties() []junitProperty {
+	return []junitProperty{
+		{"library", report.Library},
+		{"manufacturer", report.Manufacturer},
+		{"libraryVersion", report.LibraryVersion},
+		{"cryptokiVersion", report.CryptokiVersion},
+		{"token.label", report.Token.Label},
+		{"token.manufacturer", report.Token.Manufacturer},
+		{"token.model", report.Token.Model},
+		{"token.serialNumber", report.Token.SerialNumber},
+		{"token.hardwareVersion", report.Token.HardwareVersion},
+		{"token.firmwareVersion", report.Token.FirmwareVersion},
+	}
+}
+
+// WriteJUnit writes the report as JUnit XML, with a testsuite per key spec and a testcase
+// per step of each template. Failures carry the CKR name as their type.
+func (report *ConformanceReport) WriteJUnit(w io.Writer) (err error) {
+
+	suites := junitTestSuites{
+		Name:     "pkcs11-conformance",
+		Tests:    len(report.Results),
+		Failures: report.Failed,
+		Skipped:  report.Skipped,
+		Time:     junitTime(report.Time),
+	}
+
+	// the results of a spec are consecutive
+	for _, result := range report.Results {
+
+		if len(suites.Suites) == 0 || suites.Suites[len(suites.Suites)-1].Name != result.Suite {
+			suites.Suites = append(suites.Suites, junitTestSuite{
+				Name:       result.Suite,
+				Timestamp:  report.Timestamp.Format(time.RFC3339),
+				Properties: report.properties(),
+			})
+		}
+		suite := &suites.Suites[len(suites.Suites)-1]
+
+		testCase := junitTestCase{
+			Name:      result.Name,
+			Classname: result.Suite + "." + result.Template,
+			Time:      junitTime(result.Time),
+		}
+
+		switch result.Status {
+		case StatusFail:
+			testCase.Failure = &junitFailure{Message: result.Message, Type: result.CKR, Text: result.Message}
+			if result.CKR != "" {
+				testCase.Failure.Text = fmt.Sprintf("%s (%s)\n%s", result.CKR, result.CKRCode, result.Message)
+			}
+			suite.Failures++
+		case StatusSkip:
+			testCase.Skipped = &junitSkipped{Message: result.Message}
+			suite.Skipped++
+		}
+
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	// the time of a suite is the sum of its cases
+	for i := range suites.Suites {
+		var seconds float64
+		for _, result := range report.Results {
+			if result.Suite == suites.Suites[i].Name {
+				seconds += result.Time
+			}
+		}
+		suites.Suites[i].Time = junitTime(seconds)
+	}
+
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err = encoder.Encode(suites); err != nil {
+		return
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+// WriteJSON writes the report as indented JSON
+func (report *ConformanceReport) WriteJSON(w io.Writer) (err error) {
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(append(out, '\n'))
+	return
+}